@@ -0,0 +1,83 @@
+// Command etherply-migrate converts stored workspace documents from one sync
+// strategy to another while the server is stopped.
+//
+// Usage:
+//
+//	etherply-migrate -badger ./badger.db -to automerge -all -dry-run
+//	etherply-migrate -badger ./badger.db -to lww -workspace settings-1,settings-2
+//
+// Each workspace is read with the strategy it was written with and rewritten
+// in the target format; see crdt.Engine.MigrateWorkspace. One JSON report per
+// workspace is printed to stdout. Running servers can use
+// POST /v1/workspaces/{id}/migrate instead.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+// run migrates the requested workspaces and returns the exit code.
+func run() int {
+	badgerPath := flag.String("badger", "./badger.db", "path to the BadgerDB directory")
+	to := flag.String("to", "", "target strategy: automerge, lww or server-auth")
+	workspaces := flag.String("workspace", "", "comma-separated workspace IDs to migrate")
+	all := flag.Bool("all", false, "migrate every stored workspace")
+	dryRun := flag.Bool("dry-run", false, "convert and verify without writing")
+	flag.Parse()
+
+	if *to == "" || (*workspaces == "" && !*all) {
+		fmt.Fprintln(os.Stderr, "usage: etherply-migrate -to <strategy> (-workspace <ids> | -all) [-dry-run] [-badger <path>]")
+		return 2
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	stateStore, err := store.NewBadgerStore(*badgerPath)
+	if err != nil {
+		logger.Error("persistence_init_failed", "path", *badgerPath, "error", err)
+		return 1
+	}
+	defer stateStore.Close()
+
+	engine := crdt.NewEngine(stateStore, crdt.WithLogger(logger))
+
+	ids := strings.Split(*workspaces, ",")
+	if *all {
+		if ids, err = engine.Workspaces(); err != nil {
+			logger.Error("workspace_listing_failed", "error", err)
+			return 1
+		}
+	}
+
+	failed := 0
+	out := json.NewEncoder(os.Stdout)
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		report, err := engine.MigrateWorkspace(id, sync.StrategyType(*to), *dryRun)
+		if err != nil {
+			failed++
+			logger.Error("workspace_migration_failed", "workspace_id", id, "error", err)
+			continue
+		}
+		out.Encode(report)
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -75,6 +75,10 @@ func Middleware(next http.Handler) http.Handler {
 		// Or just pass it?
 		// Ideally `auth.ContextWithScopes`?
 		ctx = NewContextWithScopes(ctx, scopes)
+		ctx = NewContextWithIdentity(ctx, IdentityFromClaims(claims))
+		if workspaces, ok := WorkspacesFromClaims(claims); ok {
+			ctx = NewContextWithWorkspaces(ctx, workspaces)
+		}
 
 		// Pass execution to the next handler
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -84,7 +88,11 @@ func Middleware(next http.Handler) http.Handler {
 // Context keys
 type contextKey string
 
-const scopeContextKey contextKey = "scopes"
+const (
+	scopeContextKey      contextKey = "scopes"
+	identityContextKey   contextKey = "identity"
+	workspacesContextKey contextKey = "workspaces"
+)
 
 func NewContextWithScopes(ctx context.Context, scopes []string) context.Context {
 	return context.WithValue(ctx, scopeContextKey, scopes)
@@ -96,3 +104,84 @@ func ScopesFromContext(ctx context.Context) []string {
 	}
 	return []string{}
 }
+
+// Identity is the authenticated user of a request, taken from the token.
+type Identity struct {
+	UserID string // "sub" claim; empty if the token has none
+	Name   string // "name" claim
+	Avatar string // "picture" claim, or "avatar"
+}
+
+// IdentityFromClaims reads the identity claims of a validated token.
+func IdentityFromClaims(claims map[string]interface{}) Identity {
+	var id Identity
+	id.UserID, _ = claims["sub"].(string)
+	id.Name, _ = claims["name"].(string)
+	if id.Avatar, _ = claims["picture"].(string); id.Avatar == "" {
+		id.Avatar, _ = claims["avatar"].(string)
+	}
+	return id
+}
+
+func NewContextWithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey, id)
+}
+
+// IdentityFromContext returns the identity set by Middleware. It reports
+// false for requests that were not authenticated, e.g. in tests.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityContextKey).(Identity)
+	return id, ok
+}
+
+// WorkspacesFromClaims reads the workspaces a token is limited to from its
+// "workspaces" claim, or "ws" for short: a list of patterns, or a single
+// space-separated string like "scope". It reports false if the token has
+// neither claim and may access every workspace.
+func WorkspacesFromClaims(claims map[string]interface{}) ([]string, bool) {
+	claim, ok := claims["workspaces"]
+	if !ok {
+		if claim, ok = claims["ws"]; !ok {
+			return nil, false
+		}
+	}
+	patterns := []string{}
+	switch v := claim.(type) {
+	case string:
+		patterns = strings.Fields(v)
+	case []interface{}:
+		for _, p := range v {
+			if s, ok := p.(string); ok && s != "" {
+				patterns = append(patterns, s)
+			}
+		}
+	}
+	// A malformed claim grants nothing rather than everything.
+	return patterns, true
+}
+
+func NewContextWithWorkspaces(ctx context.Context, patterns []string) context.Context {
+	return context.WithValue(ctx, workspacesContextKey, patterns)
+}
+
+// WorkspacesFromContext returns the workspace patterns set by Middleware. It
+// reports false if the request is not limited to certain workspaces.
+func WorkspacesFromContext(ctx context.Context) ([]string, bool) {
+	patterns, ok := ctx.Value(workspacesContextKey).([]string)
+	return patterns, ok
+}
+
+// WorkspaceAllowed reports whether workspaceID matches one of patterns: an
+// exact ID, or a prefix ending in "*" ("team-a-*", or "*" for all).
+func WorkspaceAllowed(patterns []string, workspaceID string) bool {
+	for _, p := range patterns {
+		if prefix, wildcard := strings.CutSuffix(p, "*"); wildcard {
+			if strings.HasPrefix(workspaceID, prefix) {
+				return true
+			}
+		} else if p == workspaceID {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package auth_test
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -134,3 +135,89 @@ func TestMiddleware_ValidToken_QueryParam(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", rr.Code)
 	}
 }
+
+func TestMiddleware_Identity(t *testing.T) {
+	secret := "test-secret-for-identity"
+	auth.Init(secret)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":     "alice",
+		"name":    "Alice Example",
+		"picture": "https://example.com/alice.png",
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	})
+	signed, _ := token.SignedString([]byte(secret))
+
+	var got auth.Identity
+	var ok bool
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok = auth.IdentityFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest("GET", "/v1/sync/test-workspace?token="+signed, nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := auth.Identity{UserID: "alice", Name: "Alice Example", Avatar: "https://example.com/alice.png"}
+	if !ok || got != want {
+		t.Errorf("Expected identity %+v, got %+v", want, got)
+	}
+}
+
+func TestMiddleware_WorkspacesClaim(t *testing.T) {
+	secret := "test-secret-for-workspaces"
+	auth.Init(secret)
+
+	sign := func(claims jwt.MapClaims) string {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+		signed, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		return signed
+	}
+
+	tests := []struct {
+		name    string
+		claims  jwt.MapClaims
+		limited bool
+		want    []string
+	}{
+		{"no claim", jwt.MapClaims{"sub": "alice"}, false, nil},
+		{"list", jwt.MapClaims{"workspaces": []string{"ws-1", "team-*"}}, true, []string{"ws-1", "team-*"}},
+		{"short string", jwt.MapClaims{"ws": "ws-1 ws-2"}, true, []string{"ws-1", "ws-2"}},
+		{"malformed", jwt.MapClaims{"workspaces": 42}, true, []string{}},
+	}
+	for _, tt := range tests {
+		var got []string
+		var limited bool
+		handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, limited = auth.WorkspacesFromContext(r.Context())
+		}))
+		req := httptest.NewRequest("GET", "/v1/sync/ws-1?token="+sign(tt.claims), nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if limited != tt.limited || strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: expected %v (limited=%v), got %v (limited=%v)", tt.name, tt.want, tt.limited, got, limited)
+		}
+	}
+}
+
+func TestWorkspaceAllowed(t *testing.T) {
+	patterns := []string{"ws-1", "team-a-*"}
+	tests := map[string]bool{
+		"ws-1":        true,
+		"ws-10":       false,
+		"team-a-":     true,
+		"team-a-docs": true,
+		"team-b-docs": false,
+		"":            false,
+	}
+	for workspaceID, want := range tests {
+		if got := auth.WorkspaceAllowed(patterns, workspaceID); got != want {
+			t.Errorf("WorkspaceAllowed(%q): expected %v, got %v", workspaceID, want, got)
+		}
+	}
+	if !auth.WorkspaceAllowed([]string{"*"}, "anything") {
+		t.Error("Expected * to allow every workspace")
+	}
+	if auth.WorkspaceAllowed(nil, "ws-1") {
+		t.Error("Expected no patterns to allow nothing")
+	}
+}
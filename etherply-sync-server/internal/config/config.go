@@ -26,6 +26,46 @@ type Config struct {
 	// Sync strategy (automerge, lww, server-auth)
 	SyncStrategy sync.StrategyType
 
+	// Change log compaction
+	CompactionThreshold int
+	CompactionInterval  time.Duration
+
+	// Client timestamp bounds (reject, clamp, ignore)
+	TimestampPolicy string
+	MaxClockSkew    time.Duration
+	MaxTimestampAge time.Duration // 0 = unlimited
+
+	// Recent op IDs remembered per client to drop replays (0 = disabled),
+	// and how long they are kept after the client's last op
+	DedupWindow int
+	DedupTTL    time.Duration
+
+	// Broadcast backend (memory, nats, redis); nats and redis fan out across instances
+	PubSubBackend string
+	PubSubNATSURL string
+
+	// Presence backend (memory, redis); redis shares presence across instances
+	PresenceBackend string
+	PresenceTTL     time.Duration
+
+	// Presence updates per second and connection, and their largest payload
+	PresenceRate     int
+	PresenceMaxBytes int
+
+	// Inactivity before a session turns idle, silence before it is removed (0 = never),
+	// and how often that is checked
+	PresenceIdle    time.Duration
+	PresenceOffline time.Duration
+	PresenceSweep   time.Duration
+
+	// Redis server for the redis backends
+	RedisURL string
+
+	// Broadcast to slow subscribers (disconnect, resync, spill)
+	PubSubOverflow   string
+	PubSubBufferSize int
+	PubSubSpillSize  int
+
 	// Replication
 	NATSURLs []string
 	Region   string
@@ -42,16 +82,36 @@ type Config struct {
 // Load reads configuration from environment variables.
 func Load() *Config {
 	cfg := &Config{
-		Port:            getEnv("PORT", "8080"),
-		ShutdownTimeout: getDuration("SHUTDOWN_TIMEOUT_SECONDS", 30*time.Second),
-		JWTSecret:       os.Getenv("ETHERPLY_JWT_SECRET"),
-		BadgerPath:      getEnv("BADGER_PATH", "./badger.db"),
-		SyncStrategy:    sync.StrategyType(getEnv("SYNC_STRATEGY", string(sync.StrategyAutomerge))),
-		Region:          getEnv("REGION", "default"),
-		ServerID:        os.Getenv("SERVER_ID"),
-		WebhookURL:      os.Getenv("WEBHOOK_URL"),
-		LogFormat:       getEnv("LOG_FORMAT", "json"),
-		LogLevel:        parseLogLevel(getEnv("LOG_LEVEL", "info")),
+		Port:                getEnv("PORT", "8080"),
+		ShutdownTimeout:     getDuration("SHUTDOWN_TIMEOUT_SECONDS", 30*time.Second),
+		JWTSecret:           os.Getenv("ETHERPLY_JWT_SECRET"),
+		BadgerPath:          getEnv("BADGER_PATH", "./badger.db"),
+		SyncStrategy:        sync.StrategyType(getEnv("SYNC_STRATEGY", string(sync.StrategyAutomerge))),
+		CompactionThreshold: getInt("COMPACTION_THRESHOLD", 100),
+		CompactionInterval:  getDuration("COMPACTION_INTERVAL_SECONDS", 30*time.Second),
+		TimestampPolicy:     getEnv("TIMESTAMP_POLICY", "clamp"),
+		MaxClockSkew:        getDuration("MAX_CLOCK_SKEW_SECONDS", time.Minute),
+		MaxTimestampAge:     getDurationOrZero("MAX_TIMESTAMP_AGE_SECONDS", 0),
+		DedupWindow:         getIntOrZero("DEDUP_WINDOW", 128),
+		DedupTTL:            getDuration("DEDUP_TTL_SECONDS", 24*time.Hour),
+		PubSubBackend:       getEnv("PUBSUB_BACKEND", "memory"),
+		PubSubNATSURL:       os.Getenv("PUBSUB_NATS_URL"),
+		PresenceBackend:     getEnv("PRESENCE_BACKEND", "memory"),
+		PresenceTTL:         getDuration("PRESENCE_REDIS_TTL_SECONDS", 30*time.Second),
+		PresenceRate:        getInt("PRESENCE_RATE_LIMIT", 20),
+		PresenceMaxBytes:    getInt("PRESENCE_MAX_BYTES", 4096),
+		PresenceIdle:        getDurationOrZero("PRESENCE_IDLE_SECONDS", time.Minute),
+		PresenceOffline:     getDurationOrZero("PRESENCE_OFFLINE_SECONDS", 5*time.Minute),
+		PresenceSweep:       getDuration("PRESENCE_SWEEP_INTERVAL_SECONDS", 5*time.Second),
+		RedisURL:            os.Getenv("REDIS_URL"),
+		PubSubOverflow:      getEnv("PUBSUB_OVERFLOW_POLICY", "resync"),
+		PubSubBufferSize:    getInt("PUBSUB_BUFFER_SIZE", 100),
+		PubSubSpillSize:     getInt("PUBSUB_SPILL_SIZE", 1000),
+		Region:              getEnv("REGION", "default"),
+		ServerID:            os.Getenv("SERVER_ID"),
+		WebhookURL:          os.Getenv("WEBHOOK_URL"),
+		LogFormat:           getEnv("LOG_FORMAT", "json"),
+		LogLevel:            parseLogLevel(getEnv("LOG_LEVEL", "info")),
 	}
 
 	// Parse NATS_URL as comma-separated list
@@ -59,10 +119,20 @@ func Load() *Config {
 		cfg.NATSURLs = splitTrim(natsURL, ",")
 	}
 
+	// The broadcast bus defaults to the replication NATS cluster
+	if cfg.PubSubNATSURL == "" && len(cfg.NATSURLs) > 0 {
+		cfg.PubSubNATSURL = cfg.NATSURLs[0]
+	}
+
 	// Generate server ID if not set
 	if cfg.ServerID == "" && len(cfg.NATSURLs) > 0 {
 		cfg.ServerID = "sync-server-" + cfg.Port
 	}
+	// Shared presence needs an ID that is unique across hosts
+	if cfg.ServerID == "" && cfg.PresenceBackend == "redis" {
+		host, _ := os.Hostname()
+		cfg.ServerID = host + "-" + cfg.Port
+	}
 
 	return cfg
 }
@@ -99,6 +169,68 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	switch c.TimestampPolicy {
+	case "reject", "clamp", "ignore":
+		// Valid
+	default:
+		return &ConfigError{
+			Field:   "TIMESTAMP_POLICY",
+			Message: "must be one of: reject, clamp, ignore",
+		}
+	}
+
+	switch c.PubSubBackend {
+	case "memory":
+		// Valid
+	case "nats":
+		if c.PubSubNATSURL == "" {
+			return &ConfigError{Field: "PUBSUB_NATS_URL", Message: "required for the nats backend (or set NATS_URL)"}
+		}
+	case "redis":
+		if c.RedisURL == "" {
+			return &ConfigError{Field: "REDIS_URL", Message: "required for the redis PubSub backend"}
+		}
+	default:
+		return &ConfigError{
+			Field:   "PUBSUB_BACKEND",
+			Message: "must be one of: memory, nats, redis",
+		}
+	}
+
+	switch c.PresenceBackend {
+	case "memory":
+		// Valid
+	case "redis":
+		if c.RedisURL == "" {
+			return &ConfigError{Field: "REDIS_URL", Message: "required for the redis presence backend"}
+		}
+	default:
+		return &ConfigError{
+			Field:   "PRESENCE_BACKEND",
+			Message: "must be one of: memory, redis",
+		}
+	}
+
+	if c.PresenceRate <= 0 {
+		return &ConfigError{Field: "PRESENCE_RATE_LIMIT", Message: "must be positive"}
+	}
+	if c.PresenceIdle > 0 && c.PresenceOffline > 0 && c.PresenceIdle >= c.PresenceOffline {
+		return &ConfigError{Field: "PRESENCE_IDLE_SECONDS", Message: "must be less than PRESENCE_OFFLINE_SECONDS"}
+	}
+	if c.PresenceSweep <= 0 {
+		return &ConfigError{Field: "PRESENCE_SWEEP_INTERVAL_SECONDS", Message: "must be positive"}
+	}
+
+	switch c.PubSubOverflow {
+	case "disconnect", "resync", "spill":
+		// Valid
+	default:
+		return &ConfigError{
+			Field:   "PUBSUB_OVERFLOW_POLICY",
+			Message: "must be one of: disconnect, resync, spill",
+		}
+	}
+
 	return nil
 }
 
@@ -130,6 +262,35 @@ func getDuration(key string, defaultValue time.Duration) time.Duration {
 	return defaultValue
 }
 
+func getInt(key string, defaultValue int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+// getDurationOrZero is getDuration for settings where 0 disables a feature.
+func getDurationOrZero(key string, defaultValue time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return defaultValue
+}
+
+// getIntOrZero is getInt for settings where 0 disables a feature.
+func getIntOrZero(key string, defaultValue int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
+	}
+	return defaultValue
+}
+
 func parseLogLevel(s string) slog.Level {
 	switch s {
 	case "debug":
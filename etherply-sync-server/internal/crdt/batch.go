@@ -0,0 +1,107 @@
+package crdt
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+// MaxBatchOps is the largest number of operations accepted in one batch.
+const MaxBatchOps = 256
+
+// Batch is a group of operations on one workspace that is applied atomically.
+//
+// The batch carries one timestamp and one precondition for all of its
+// operations. Timestamp, HLC and preconditions on the individual operations
+// are ignored.
+type Batch struct {
+	WorkspaceID string      `json:"workspace_id"`
+	Ops         []Operation `json:"ops"`
+	Timestamp   int64       `json:"timestamp"`     // Unix Microseconds
+	HLC         string      `json:"hlc,omitempty"` // Hybrid logical clock, "<physical>:<logical>:<node>"
+	Precondition
+	// OpID and ClientID identify the batch for deduplication, as on Operation.
+	OpID     string `json:"-"`
+	ClientID string `json:"-"`
+}
+
+// ProcessBatch applies every operation of b in order under a single lock
+// acquisition, as a single change, and persists the result once. If any
+// operation fails, none of them is applied.
+func (e *Engine) ProcessBatch(b Batch) error {
+	_, err := e.ApplyBatch(b)
+	return err
+}
+
+// ApplyBatch is ProcessBatch returning the document heads right after the
+// batch. Replayed batches are handled like in ApplyOperation.
+func (e *Engine) ApplyBatch(b Batch) ([]string, error) {
+	start := time.Now()
+
+	if b.WorkspaceID == "" {
+		return nil, fmt.Errorf("%w: workspace_id is missing", ErrInvalidOperation)
+	}
+	if len(b.Ops) == 0 {
+		return nil, fmt.Errorf("%w: batch is empty", ErrInvalidOperation)
+	}
+	if len(b.Ops) > MaxBatchOps {
+		return nil, fmt.Errorf("%w: batch has %d ops, limit is %d", ErrInvalidOperation, len(b.Ops), MaxBatchOps)
+	}
+	ms := make([]sync.Mutation, len(b.Ops))
+	for i, op := range b.Ops {
+		if op.WorkspaceID != "" && op.WorkspaceID != b.WorkspaceID {
+			return nil, fmt.Errorf("%w: op %d targets workspace %q", ErrInvalidOperation, i, op.WorkspaceID)
+		}
+		m, err := op.validMutation()
+		if err != nil {
+			return nil, fmt.Errorf("op %d: %w", i, err)
+		}
+		ms[i] = m
+	}
+
+	unlock := e.locks.lock(b.WorkspaceID)
+	defer unlock()
+
+	entry, err := e.openDoc(b.WorkspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+	if err := e.checkDuplicate(entry, b.ClientID, b.OpID); err != nil {
+		return duplicateResult(entry, err)
+	}
+	if err := e.checkPrecondition(entry, b.Precondition, ""); err != nil {
+		return nil, err
+	}
+
+	// One stamp for the batch; the logical counter keeps its ops ordered, so
+	// a later op on the same key wins over an earlier one under LWW.
+	stamp, err := e.stamp(Operation{WorkspaceID: b.WorkspaceID, Timestamp: b.Timestamp, HLC: b.HLC})
+	if err != nil {
+		return nil, err
+	}
+	for i := range ms {
+		ms[i].HLC = stamp
+		ms[i].HLC.Logical += uint32(i)
+	}
+	e.clock.Observe(ms[len(ms)-1].HLC)
+
+	if err := entry.doc.ApplyBatch(ms, stamp.Time()); err != nil {
+		// Earlier ops of the batch may already be in the live document.
+		e.cache.remove(b.WorkspaceID)
+		return nil, fmt.Errorf("failed to apply batch: %w", err)
+	}
+	if err := e.checkSchema(entry); err != nil {
+		e.cache.remove(b.WorkspaceID)
+		return nil, err
+	}
+	if err := e.persist(entry); err != nil {
+		e.cache.remove(b.WorkspaceID)
+		return nil, fmt.Errorf("failed to persist state: %w", err)
+	}
+	e.recordOp(entry, b.ClientID, b.OpID)
+	e.replicate(entry)
+
+	e.fireSyncOperationMetric(Operation{WorkspaceID: b.WorkspaceID}, entry.strategy.Name(), time.Since(start).Milliseconds())
+	return entry.doc.Heads()
+}
@@ -0,0 +1,87 @@
+package crdt_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+func TestEngine_ProcessBatch(t *testing.T) {
+	engine, ms := setupMockEngine()
+	defer ms.Close()
+
+	err := engine.ProcessBatch(crdt.Batch{
+		WorkspaceID: "ws-batch",
+		Ops: []crdt.Operation{
+			{Key: "title", Value: "Sprint"},
+			{Key: "owner", Value: "ana"},
+			{Key: "title", Value: "Sprint 2"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ProcessBatch failed: %v", err)
+	}
+
+	snapshot, _ := engine.GetFullState("ws-batch")
+	if snapshot.Data["title"] != "Sprint 2" || snapshot.Data["owner"] != "ana" {
+		t.Errorf("Expected title 'Sprint 2' and owner 'ana', got %v", snapshot.Data)
+	}
+	history, _ := engine.GetHistory("ws-batch")
+	if len(history) != 1 {
+		t.Errorf("Expected the batch to be one change, got %d", len(history))
+	}
+}
+
+func TestEngine_ProcessBatchIsAllOrNothing(t *testing.T) {
+	for _, st := range []sync.StrategyType{sync.StrategyAutomerge, sync.StrategyLWW, sync.StrategyServerAuthoritative} {
+		engine, ms := setupMockEngine()
+		ws := "ws-" + string(st)
+		if _, err := engine.CreateWorkspace(ws, st); err != nil {
+			t.Fatalf("%s: CreateWorkspace failed: %v", st, err)
+		}
+		if err := engine.ProcessOperation(crdt.Operation{WorkspaceID: ws, Key: "a", Value: "before"}); err != nil {
+			t.Fatalf("%s: ProcessOperation failed: %v", st, err)
+		}
+
+		// The second op fails in the document (no list to insert into), after
+		// the first one has already been applied to the working state.
+		err := engine.ProcessBatch(crdt.Batch{
+			WorkspaceID: ws,
+			Ops: []crdt.Operation{
+				{Key: "a", Value: "after"},
+				{Op: sync.OpInsert, Path: []interface{}{"a", 0}, Value: "x"},
+			},
+		})
+		if err == nil {
+			t.Fatalf("%s: expected batch to fail", st)
+		}
+
+		snapshot, _ := engine.GetFullState(ws)
+		if snapshot.Data["a"] != "before" {
+			t.Errorf("%s: expected a='before' after failed batch, got %v", st, snapshot.Data["a"])
+		}
+		ms.Close()
+	}
+}
+
+func TestEngine_ProcessBatchValidation(t *testing.T) {
+	engine, ms := setupMockEngine()
+	defer ms.Close()
+
+	bad := []crdt.Batch{
+		{WorkspaceID: "ws-batch"},
+		{WorkspaceID: "ws-batch", Ops: []crdt.Operation{{Key: "a", Value: 1}, {Value: 2}}},
+		{WorkspaceID: "ws-batch", Ops: []crdt.Operation{{WorkspaceID: "other", Key: "a", Value: 1}}},
+		{WorkspaceID: "ws-batch", Ops: make([]crdt.Operation, crdt.MaxBatchOps+1)},
+	}
+	for i, b := range bad {
+		if err := engine.ProcessBatch(b); !errors.Is(err, crdt.ErrInvalidOperation) {
+			t.Errorf("batch %d: expected ErrInvalidOperation, got %v", i, err)
+		}
+	}
+	if snapshot, _ := engine.GetFullState("ws-batch"); len(snapshot.Data) != 0 {
+		t.Errorf("Expected no state after rejected batches, got %v", snapshot.Data)
+	}
+}
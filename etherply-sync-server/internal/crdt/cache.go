@@ -0,0 +1,103 @@
+package crdt
+
+import (
+	"container/list"
+	gosync "sync"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+// cachedDoc is a live document resident in memory together with its persistence state.
+// Fields other than workspaceID are only touched while holding the workspace lock.
+type cachedDoc struct {
+	workspaceID string
+	doc         sync.Document
+	strategy    sync.SyncStrategy
+	// bound is set once the document metadata recording strategy is stored.
+	bound bool
+	// nextSeq is the sequence number of the next change log entry.
+	nextSeq uint64
+	// logLen counts change log entries written since the last snapshot.
+	logLen int
+	// schemaHash identifies the workspace schema read with the document, "" if none.
+	schemaHash string
+	// opIDs caches the recent op ID windows of clients, loaded on first use.
+	opIDs map[string]*opWindow
+	// opIDsSwept is when expired op ID windows were last dropped.
+	opIDsSwept time.Time
+}
+
+// docCache is an LRU of live documents for the most recently used workspaces.
+//
+// The cache only manages residency; callers serialize access to an individual
+// entry through the engine's per-workspace locks. An entry evicted while in use
+// stays valid for its current holder and is simply reloaded on the next miss.
+type docCache struct {
+	mu       gosync.Mutex
+	capacity int
+	order    *list.List // front = most recently used
+	entries  map[string]*list.Element
+}
+
+func newDocCache(capacity int) *docCache {
+	return &docCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached document for workspaceID and marks it as recently used.
+func (c *docCache) get(workspaceID string) (*cachedDoc, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[workspaceID]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cachedDoc), true
+}
+
+// put inserts or replaces an entry, evicting the least recently used ones over capacity.
+func (c *docCache) put(entry *cachedDoc) {
+	if c.capacity <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[entry.workspaceID]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[entry.workspaceID] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedDoc).workspaceID)
+	}
+}
+
+// remove drops the entry for workspaceID, if any.
+func (c *docCache) remove(workspaceID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[workspaceID]; ok {
+		c.order.Remove(el)
+		delete(c.entries, workspaceID)
+	}
+}
+
+// len returns the number of resident documents.
+func (c *docCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
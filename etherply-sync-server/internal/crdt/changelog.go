@@ -0,0 +1,186 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+)
+
+// Storage layout per workspace namespace ("ws:<id>"):
+//
+//	sync_doc            base snapshot (full document)
+//	sync_doc_meta       DocumentMeta: the strategy the document is bound to
+//	sync_log_meta       logMeta: first live log sequence and the snapshot's heads
+//	sync_log:<seq>      logEntry: one incremental chunk and the heads after applying it
+//	sync_ops:<client>   recently applied op IDs of one client, for deduplication
+//
+// A document is the snapshot followed by every log entry from BaseSeq upwards.
+// Entries are read until the first missing sequence number, so a chunk that
+// was written just before a crash is still recovered.
+const (
+	logMetaKey   = "sync_log_meta"
+	logKeyPrefix = "sync_log:"
+)
+
+// logMeta records where the change log starts relative to the snapshot.
+type logMeta struct {
+	BaseSeq       uint64   `json:"base_seq"`
+	SnapshotHeads []string `json:"snapshot_heads"`
+}
+
+// logEntry is one persisted incremental chunk.
+type logEntry struct {
+	Heads []string `json:"heads"`
+	Chunk []byte   `json:"chunk"`
+}
+
+// logKey formats a sequence number so that keys sort in log order.
+func logKey(seq uint64) string {
+	return fmt.Sprintf("%s%020d", logKeyPrefix, seq)
+}
+
+// loadLogMeta reads the log metadata, returning zero values for new documents.
+func (e *Engine) loadLogMeta(workspaceID string) (logMeta, error) {
+	var meta logMeta
+	val, exists, err := e.store.Get("ws:"+workspaceID, logMetaKey)
+	if err != nil || !exists {
+		return meta, err
+	}
+	data, ok := val.([]byte)
+	if !ok {
+		return meta, fmt.Errorf("unexpected log metadata type %T", val)
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, fmt.Errorf("failed to decode log metadata: %w", err)
+	}
+	return meta, nil
+}
+
+// loadLog reads consecutive log entries starting at seq.
+func (e *Engine) loadLog(workspaceID string, seq uint64) ([]logEntry, error) {
+	var entries []logEntry
+	for ; ; seq++ {
+		val, exists, err := e.store.Get("ws:"+workspaceID, logKey(seq))
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return entries, nil
+		}
+		data, ok := val.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("unexpected log entry type %T at seq %d", val, seq)
+		}
+		var entry logEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode log entry %d: %w", seq, err)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// appendLog persists one incremental chunk at seq.
+func (e *Engine) appendLog(workspaceID string, seq uint64, chunk []byte, heads []string) error {
+	data, err := json.Marshal(logEntry{Heads: heads, Chunk: chunk})
+	if err != nil {
+		return err
+	}
+	return e.store.Set("ws:"+workspaceID, logKey(seq), data)
+}
+
+// compact folds the change log of entry into a new snapshot.
+//
+// The snapshot is written before the metadata that retires the old entries, so
+// a crash in between only replays chunks already contained in the snapshot,
+// which is harmless for Automerge. The caller must hold the workspace lock.
+func (e *Engine) compact(entry *cachedDoc) error {
+	if entry.logLen == 0 {
+		return nil
+	}
+
+	heads, err := entry.doc.Heads()
+	if err != nil {
+		return err
+	}
+	meta, err := json.Marshal(logMeta{BaseSeq: entry.nextSeq, SnapshotHeads: heads})
+	if err != nil {
+		return err
+	}
+
+	ns := "ws:" + entry.workspaceID
+	if err := e.store.Set(ns, docKey, entry.doc.Save()); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := e.store.Set(ns, logMetaKey, meta); err != nil {
+		return fmt.Errorf("failed to write log metadata: %w", err)
+	}
+
+	for seq := entry.nextSeq - uint64(entry.logLen); seq < entry.nextSeq; seq++ {
+		if err := e.store.Delete(ns, logKey(seq)); err != nil {
+			// The snapshot is already authoritative; leftovers are never read again.
+			e.logger.Warn("log_cleanup_failed",
+				slog.String("workspace_id", entry.workspaceID),
+				slog.Uint64("seq", seq),
+				slog.Any("error", err),
+			)
+		}
+	}
+
+	e.logger.Debug("change_log_compacted",
+		slog.String("workspace_id", entry.workspaceID),
+		slog.Int("entries", entry.logLen),
+	)
+	entry.logLen = 0
+	return nil
+}
+
+// changesFromLog serves a delta straight from the stored change log, without
+// materializing the document. It reports false if since does not match the
+// snapshot or any log entry, in which case the caller must fall back to the document.
+func (e *Engine) changesFromLog(workspaceID string, since []string) ([]byte, bool, error) {
+	meta, err := e.loadLogMeta(workspaceID)
+	if err != nil {
+		return nil, false, err
+	}
+	entries, err := e.loadLog(workspaceID, meta.BaseSeq)
+	if err != nil {
+		return nil, false, err
+	}
+
+	start := -1
+	if len(meta.SnapshotHeads) > 0 && sameHeads(meta.SnapshotHeads, since) {
+		start = 0
+	}
+	for i, entry := range entries {
+		if sameHeads(entry.Heads, since) {
+			start = i + 1
+		}
+	}
+	if start < 0 {
+		return nil, false, nil
+	}
+
+	delta := []byte{}
+	for _, entry := range entries[start:] {
+		delta = append(delta, entry.Chunk...)
+	}
+	return delta, true, nil
+}
+
+// sameHeads compares two head sets regardless of order.
+func sameHeads(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	as := append([]string(nil), a...)
+	bs := append([]string(nil), b...)
+	sort.Strings(as)
+	sort.Strings(bs)
+	for i := range as {
+		if as[i] != bs[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package crdt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrConflict is returned when a conditional write's precondition does not
+// match the current document.
+var ErrConflict = errors.New("precondition failed")
+
+// ConflictError reports a failed if_heads/if_version precondition together
+// with the current document version, so the client can retry its
+// read-modify-write without another round trip.
+type ConflictError struct {
+	WorkspaceID string   `json:"workspace_id"`
+	Heads       []string `json:"heads"`
+	Version     string   `json:"version"`
+	Key         string   `json:"key,omitempty"`
+	// Current is the value at Key, or the whole state for batches.
+	Current interface{} `json:"current"`
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s: workspace %s is at version %s", ErrConflict, e.WorkspaceID, e.Version)
+}
+
+func (e *ConflictError) Unwrap() error { return ErrConflict }
+
+// Precondition makes a write conditional on the document being unchanged
+// since the client read it. Empty fields are not checked.
+type Precondition struct {
+	// IfHeads must equal the current heads, in any order. Only strategies
+	// with heads (automerge, lww) can satisfy a non-empty IfHeads.
+	IfHeads []string `json:"if_heads,omitempty"`
+	// IfVersion must equal the current Snapshot.Version.
+	IfVersion string `json:"if_version,omitempty"`
+}
+
+func (p Precondition) empty() bool {
+	return p.IfHeads == nil && p.IfVersion == ""
+}
+
+// stateVersion is a content hash of the materialized state. Unlike heads it
+// exists for every strategy; encoding/json sorts map keys, so equal states
+// always hash alike.
+func stateVersion(state map[string]interface{}) (string, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode state: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// checkPrecondition compares p against the document in entry and returns a
+// *ConflictError if it does not hold. key selects the value reported back;
+// an empty key reports the whole state. The caller must hold the workspace lock.
+func (e *Engine) checkPrecondition(entry *cachedDoc, p Precondition, key string) error {
+	if p.empty() {
+		return nil
+	}
+	heads, err := entry.doc.Heads()
+	if err != nil {
+		return fmt.Errorf("failed to read heads: %w", err)
+	}
+	state, err := entry.doc.State()
+	if err != nil {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+	version, err := stateVersion(state)
+	if err != nil {
+		return err
+	}
+
+	headsMatch := p.IfHeads == nil || sameHeads(p.IfHeads, heads)
+	versionMatch := p.IfVersion == "" || p.IfVersion == version
+	if headsMatch && versionMatch {
+		return nil
+	}
+
+	conflict := &ConflictError{WorkspaceID: entry.workspaceID, Heads: heads, Version: version, Key: key}
+	if key != "" {
+		conflict.Current = state[key]
+	} else {
+		conflict.Current = state
+	}
+	return conflict
+}
@@ -0,0 +1,75 @@
+package crdt_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+func TestEngine_ConditionalWriteVersion(t *testing.T) {
+	for _, st := range []sync.StrategyType{sync.StrategyAutomerge, sync.StrategyLWW, sync.StrategyServerAuthoritative} {
+		engine, ms := setupMockEngine()
+		ws := "ws-cas-" + string(st)
+		engine.CreateWorkspace(ws, st)
+		engine.ProcessOperation(crdt.Operation{WorkspaceID: ws, Key: "stock", Value: float64(5)})
+
+		read, _ := engine.GetFullState(ws)
+		if read.Version == "" {
+			t.Fatalf("%s: expected a version in the snapshot", st)
+		}
+
+		// Two clients read the same version; only the first write succeeds.
+		first := crdt.Operation{WorkspaceID: ws, Key: "stock", Value: float64(4), Precondition: crdt.Precondition{IfVersion: read.Version}}
+		if err := engine.ProcessOperation(first); err != nil {
+			t.Fatalf("%s: conditional write failed: %v", st, err)
+		}
+		second := crdt.Operation{WorkspaceID: ws, Key: "stock", Value: float64(3), Precondition: crdt.Precondition{IfVersion: read.Version}}
+		err := engine.ProcessOperation(second)
+
+		var conflict *crdt.ConflictError
+		if !errors.As(err, &conflict) || !errors.Is(err, crdt.ErrConflict) {
+			t.Fatalf("%s: expected ConflictError, got %v", st, err)
+		}
+		if conflict.Current != float64(4) {
+			t.Errorf("%s: expected current value 4, got %v", st, conflict.Current)
+		}
+		after, _ := engine.GetFullState(ws)
+		if conflict.Version != after.Version || after.Data["stock"] != float64(4) {
+			t.Errorf("%s: expected stock 4 at version %s, got %v at %s", st, conflict.Version, after.Data["stock"], after.Version)
+		}
+		ms.Close()
+	}
+}
+
+func TestEngine_ConditionalWriteHeads(t *testing.T) {
+	engine, ms := setupMockEngine()
+	defer ms.Close()
+
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-cas", Key: "votes", Value: "a"})
+	read, _ := engine.GetFullState("ws-cas")
+
+	stale := crdt.Operation{WorkspaceID: "ws-cas", Key: "votes", Value: "b", Precondition: crdt.Precondition{IfHeads: []string{"deadbeef"}}}
+	if err := engine.ProcessOperation(stale); !errors.Is(err, crdt.ErrConflict) {
+		t.Errorf("Expected ErrConflict for unknown heads, got %v", err)
+	}
+	fresh := crdt.Operation{WorkspaceID: "ws-cas", Key: "votes", Value: "c", Precondition: crdt.Precondition{IfHeads: read.Heads}}
+	if err := engine.ProcessOperation(fresh); err != nil {
+		t.Errorf("Expected write at current heads to succeed, got %v", err)
+	}
+
+	// A batch precondition guards the whole batch and reports the full state.
+	err := engine.ProcessBatch(crdt.Batch{
+		WorkspaceID:  "ws-cas",
+		Ops:          []crdt.Operation{{Key: "votes", Value: "d"}},
+		Precondition: crdt.Precondition{IfHeads: read.Heads},
+	})
+	var conflict *crdt.ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected ConflictError for stale batch, got %v", err)
+	}
+	if state, _ := conflict.Current.(map[string]interface{}); state["votes"] != "c" {
+		t.Errorf("Expected current state with votes 'c', got %v", conflict.Current)
+	}
+}
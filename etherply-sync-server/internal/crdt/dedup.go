@@ -0,0 +1,248 @@
+package crdt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+)
+
+const (
+	// DefaultDedupWindow is how many recent op IDs are remembered per client.
+	DefaultDedupWindow = 128
+
+	// DefaultDedupTTL is how long the op IDs of a client that stopped writing
+	// are kept.
+	DefaultDedupTTL = 24 * time.Hour
+
+	// opWindowSweepInterval is how often a document looks for expired op ID
+	// windows, at most.
+	opWindowSweepInterval = time.Hour
+)
+
+// opIDKeyPrefix prefixes the per-client op ID window in a workspace namespace:
+//
+//	sync_ops:<client_id>   JSON opWindowRecord of the client's most recent op IDs
+const opIDKeyPrefix = "sync_ops:"
+
+// opWindowRecord is the stored form of an op ID window. Windows written
+// before expiry existed are a bare JSON array of IDs.
+type opWindowRecord struct {
+	IDs      []string `json:"ids"`                 // Oldest first
+	LastUsed int64    `json:"last_used,omitempty"` // Unix milliseconds of the last recorded op
+}
+
+// ErrDuplicateOp is returned for an operation whose op ID was already applied
+// for the same client. The operation is not applied again.
+var ErrDuplicateOp = errors.New("operation already applied")
+
+// opWindow holds the most recently applied op IDs of one client.
+type opWindow struct {
+	ids      []string
+	seen     map[string]struct{}
+	lastUsed time.Time
+}
+
+func newOpWindow(ids []string, lastUsed time.Time) *opWindow {
+	w := &opWindow{ids: ids, seen: make(map[string]struct{}, len(ids)), lastUsed: lastUsed}
+	for _, id := range ids {
+		w.seen[id] = struct{}{}
+	}
+	return w
+}
+
+// add records id, forgetting the oldest IDs beyond size.
+func (w *opWindow) add(id string, size int, now time.Time) {
+	w.lastUsed = now
+	w.ids = append(w.ids, id)
+	w.seen[id] = struct{}{}
+	for len(w.ids) > size {
+		delete(w.seen, w.ids[0])
+		w.ids = w.ids[1:]
+	}
+}
+
+// WithDedupWindow sets how many recent op IDs are remembered per client to
+// drop replayed operations. A size of 0 disables deduplication.
+func WithDedupWindow(n int) EngineOption {
+	return func(cfg *EngineConfig) {
+		cfg.DedupWindow = n
+	}
+}
+
+// WithDedupTTL sets how long the op IDs of an idle client are kept before
+// they are dropped from memory and the store. A replay older than that is
+// applied again.
+func WithDedupTTL(d time.Duration) EngineOption {
+	return func(cfg *EngineConfig) {
+		cfg.DedupTTL = d
+	}
+}
+
+// opWindowFor returns the op ID window of clientID, loading it from the store
+// on first use. Expired windows come back empty. The caller must hold the
+// workspace lock.
+func (e *Engine) opWindowFor(entry *cachedDoc, clientID string) (*opWindow, error) {
+	now := time.Now()
+	if w, ok := entry.opIDs[clientID]; ok && !e.opWindowExpired(w.lastUsed, now) {
+		return w, nil
+	}
+	record, exists, err := e.loadOpWindow(entry.workspaceID, clientID)
+	if err != nil {
+		return nil, err
+	}
+	lastUsed := time.UnixMilli(record.LastUsed)
+	if !exists || e.opWindowExpired(lastUsed, now) {
+		record.IDs, lastUsed = nil, now
+	}
+	if entry.opIDs == nil {
+		entry.opIDs = make(map[string]*opWindow)
+	}
+	w := newOpWindow(record.IDs, lastUsed)
+	entry.opIDs[clientID] = w
+	return w, nil
+}
+
+// loadOpWindow reads the stored op ID window of clientID.
+func (e *Engine) loadOpWindow(workspaceID, clientID string) (opWindowRecord, bool, error) {
+	var record opWindowRecord
+	val, exists, err := e.store.Get("ws:"+workspaceID, opIDKeyPrefix+clientID)
+	if err != nil || !exists {
+		return record, false, err
+	}
+	data, ok := val.([]byte)
+	if !ok {
+		return record, false, fmt.Errorf("unexpected op ID window type %T", val)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		// Legacy window without a timestamp; its TTL starts now.
+		err = json.Unmarshal(data, &record.IDs)
+		record.LastUsed = time.Now().UnixMilli()
+	} else {
+		err = json.Unmarshal(data, &record)
+	}
+	if err != nil {
+		return record, false, fmt.Errorf("failed to decode op IDs: %w", err)
+	}
+	return record, true, nil
+}
+
+// opWindowExpired reports whether a window last used at lastUsed has expired.
+func (e *Engine) opWindowExpired(lastUsed, now time.Time) bool {
+	return e.dedupTTL > 0 && now.Sub(lastUsed) >= e.dedupTTL
+}
+
+// expireOpWindows drops the op ID windows of clients idle for longer than the
+// dedup TTL from entry and from the store. It runs at most once per sweep
+// interval per document. The caller must hold the workspace lock.
+func (e *Engine) expireOpWindows(entry *cachedDoc, now time.Time) {
+	interval := opWindowSweepInterval
+	if e.dedupTTL < interval {
+		interval = e.dedupTTL
+	}
+	if e.dedupTTL <= 0 || now.Sub(entry.opIDsSwept) < interval {
+		return
+	}
+	entry.opIDsSwept = now
+
+	for clientID, w := range entry.opIDs {
+		if e.opWindowExpired(w.lastUsed, now) {
+			delete(entry.opIDs, clientID)
+		}
+	}
+
+	lister, ok := e.store.(store.KeyLister)
+	if !ok {
+		return
+	}
+	ns := "ws:" + entry.workspaceID
+	keys, err := lister.Keys(ns + ":" + opIDKeyPrefix)
+	if err != nil {
+		e.logger.Warn("op_id_expiry_failed", slog.String("workspace_id", entry.workspaceID), slog.Any("error", err))
+		return
+	}
+	expired := 0
+	for _, key := range keys {
+		clientID := strings.TrimPrefix(key, ns+":"+opIDKeyPrefix)
+		if _, live := entry.opIDs[clientID]; live {
+			continue
+		}
+		record, exists, err := e.loadOpWindow(entry.workspaceID, clientID)
+		if err != nil || !exists || !e.opWindowExpired(time.UnixMilli(record.LastUsed), now) {
+			continue
+		}
+		if err := e.store.Delete(ns, opIDKeyPrefix+clientID); err == nil {
+			expired++
+		}
+	}
+	if expired > 0 {
+		e.logger.Debug("op_ids_expired",
+			slog.String("workspace_id", entry.workspaceID),
+			slog.Int("clients", expired),
+		)
+	}
+}
+
+// dedupable reports whether op takes part in deduplication.
+func (e *Engine) dedupable(clientID, opID string) bool {
+	return e.dedupWindow > 0 && clientID != "" && opID != ""
+}
+
+// checkDuplicate returns ErrDuplicateOp if opID was already applied for clientID.
+func (e *Engine) checkDuplicate(entry *cachedDoc, clientID, opID string) error {
+	if !e.dedupable(clientID, opID) {
+		return nil
+	}
+	w, err := e.opWindowFor(entry, clientID)
+	if err != nil {
+		return err
+	}
+	if _, ok := w.seen[opID]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateOp, opID)
+	}
+	return nil
+}
+
+// duplicateResult turns a checkDuplicate error into the return values of
+// ApplyOperation and ApplyBatch: duplicates come with the current heads.
+func duplicateResult(entry *cachedDoc, err error) ([]string, error) {
+	if !errors.Is(err, ErrDuplicateOp) {
+		return nil, err
+	}
+	heads, headsErr := entry.doc.Heads()
+	if headsErr != nil {
+		return nil, headsErr
+	}
+	return heads, err
+}
+
+// recordOp remembers opID after its operation was persisted. A failure only
+// weakens deduplication, so it is logged rather than failing the write.
+func (e *Engine) recordOp(entry *cachedDoc, clientID, opID string) {
+	if !e.dedupable(clientID, opID) {
+		return
+	}
+	now := time.Now()
+	w, err := e.opWindowFor(entry, clientID)
+	if err == nil {
+		w.add(opID, e.dedupWindow, now)
+		var data []byte
+		data, err = json.Marshal(opWindowRecord{IDs: w.ids, LastUsed: now.UnixMilli()})
+		if err == nil {
+			err = e.store.Set("ws:"+entry.workspaceID, opIDKeyPrefix+clientID, data)
+		}
+	}
+	if err != nil {
+		e.logger.Warn("op_id_record_failed",
+			slog.String("workspace_id", entry.workspaceID),
+			slog.String("op_id", opID),
+			slog.Any("error", err),
+		)
+	}
+	e.expireOpWindows(entry, now)
+}
@@ -0,0 +1,98 @@
+package crdt_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+func TestEngine_DropsReplayedOpIDs(t *testing.T) {
+	ms := store.NewMemoryStore()
+	defer ms.Close()
+	engine := crdt.NewEngine(ms, crdt.WithCacheSize(0))
+	engine.CreateWorkspace("ws-dedup", sync.StrategyLWW)
+
+	op := crdt.Operation{WorkspaceID: "ws-dedup", Key: "k", Value: "old", OpID: "op-1", ClientID: "c1"}
+	if err := engine.ProcessOperation(op); err != nil {
+		t.Fatalf("ProcessOperation failed: %v", err)
+	}
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-dedup", Key: "k", Value: "new", OpID: "op-2", ClientID: "c1"})
+
+	// The replay of op-1 after a reconnect must not overwrite the newer value,
+	// even though the document was reloaded from the store (no cache).
+	heads, err := engine.ApplyOperation(op)
+	if !errors.Is(err, crdt.ErrDuplicateOp) {
+		t.Fatalf("Expected ErrDuplicateOp, got %v", err)
+	}
+	snapshot, _ := engine.GetFullState("ws-dedup")
+	if snapshot.Data["k"] != "new" {
+		t.Errorf("Expected k='new', got %v", snapshot.Data["k"])
+	}
+	if len(heads) != len(snapshot.Heads) || heads[0] != snapshot.Heads[0] {
+		t.Errorf("Expected current heads %v with the duplicate, got %v", snapshot.Heads, heads)
+	}
+
+	// The same op ID from another client is a different op.
+	other := crdt.Operation{WorkspaceID: "ws-dedup", Key: "k", Value: "other", OpID: "op-1", ClientID: "c2"}
+	if err := engine.ProcessOperation(other); err != nil {
+		t.Errorf("Expected op-1 from c2 to apply, got %v", err)
+	}
+}
+
+func TestEngine_DedupWindowIsBounded(t *testing.T) {
+	ms := store.NewMemoryStore()
+	defer ms.Close()
+	engine := crdt.NewEngine(ms, crdt.WithDedupWindow(2))
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := engine.ProcessBatch(crdt.Batch{
+			WorkspaceID: "ws-window",
+			Ops:         []crdt.Operation{{Key: id, Value: id}},
+			OpID:        id,
+			ClientID:    "c1",
+		}); err != nil {
+			t.Fatalf("ProcessBatch(%s) failed: %v", id, err)
+		}
+	}
+
+	// "a" fell out of the window; "c" is still remembered.
+	if err := engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-window", Key: "a", Value: "again", OpID: "a", ClientID: "c1"}); err != nil {
+		t.Errorf("Expected op outside the window to apply, got %v", err)
+	}
+	if err := engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-window", Key: "c", Value: "again", OpID: "c", ClientID: "c1"}); !errors.Is(err, crdt.ErrDuplicateOp) {
+		t.Errorf("Expected ErrDuplicateOp for recent op, got %v", err)
+	}
+}
+
+func TestEngine_DedupWindowsExpire(t *testing.T) {
+	ms := store.NewMemoryStore()
+	defer ms.Close()
+	engine := crdt.NewEngine(ms, crdt.WithDedupTTL(50*time.Millisecond))
+
+	op := crdt.Operation{WorkspaceID: "ws-ttl", Key: "k", Value: "v", OpID: "op-1", ClientID: "gone"}
+	if err := engine.ProcessOperation(op); err != nil {
+		t.Fatalf("ProcessOperation failed: %v", err)
+	}
+	if _, exists, _ := ms.Get("ws:ws-ttl", "sync_ops:gone"); !exists {
+		t.Fatal("Expected the op ID window to be stored")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	// Another client's write sweeps the idle client's window from the store.
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-ttl", Key: "k", Value: "w", OpID: "op-1", ClientID: "active"})
+	if _, exists, _ := ms.Get("ws:ws-ttl", "sync_ops:gone"); exists {
+		t.Error("Expected the idle client's op ID window to be deleted")
+	}
+	if _, exists, _ := ms.Get("ws:ws-ttl", "sync_ops:active"); !exists {
+		t.Error("Expected the active client's op ID window to be kept")
+	}
+
+	// A replay after the TTL is applied again.
+	if err := engine.ProcessOperation(op); err != nil {
+		t.Errorf("Expected op replayed after the TTL to apply, got %v", err)
+	}
+}
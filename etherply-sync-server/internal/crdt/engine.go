@@ -5,39 +5,119 @@
 //   - LWW: Last-Write-Wins, simpler semantics for non-collaborative data
 //   - Server-Authoritative: Server state always wins
 //
-// Strategy selection is done via EngineOption at construction time.
+// Each workspace is bound to a strategy when it is created (see
+// CreateWorkspace) or on its first write; the strategy set via EngineOption
+// is the default for workspaces created implicitly.
 package crdt
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"reflect"
+	"sort"
 	gosync "sync"
 	"time"
 
 	"github.com/bneb/etherply/etherply-sync-server/internal/replication"
 	"github.com/bneb/etherply/etherply-sync-server/internal/store"
 	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+	"github.com/google/uuid"
 )
 
-// docKey is the reserved storage key for document blobs.
+// docKey is the reserved storage key for document snapshots.
 const docKey = "sync_doc"
 
+const (
+	// DefaultCacheSize is the number of live documents kept in memory.
+	DefaultCacheSize = 1024
+
+	// DefaultCompactionThreshold is the number of change log entries a
+	// document may accumulate before it is folded into a new snapshot.
+	DefaultCompactionThreshold = 100
+)
+
+// ErrInvalidOperation is returned for operations that fail validation.
+var ErrInvalidOperation = errors.New("invalid operation")
+
 // Operation represents a verified request to mutate the document state.
 // Timestamp is strictly ordered by the server (Unix Microseconds).
+//
+// The zero Op is a set of Value at Key. Other ops address a nested location
+// through Path, e.g. ["board", "cards", 3, "title"]; Key may then be omitted
+// and defaults to the first path element.
 type Operation struct {
-	WorkspaceID string      `json:"workspace_id"`
-	Key         string      `json:"key"`
-	Value       interface{} `json:"value"`
-	Timestamp   int64       `json:"timestamp"` // Unix Microseconds
+	WorkspaceID string        `json:"workspace_id"`
+	Op          sync.OpKind   `json:"op,omitempty"`
+	Key         string        `json:"key"`
+	Path        []interface{} `json:"path,omitempty"`
+	Value       interface{}   `json:"value"`
+	Index       int           `json:"index,omitempty"`        // splice: start position
+	DeleteCount int           `json:"delete_count,omitempty"` // splice: characters to remove
+	Delta       int64         `json:"delta,omitempty"`        // increment: amount to add
+	Timestamp   int64         `json:"timestamp"`              // Unix Microseconds
+	HLC         string        `json:"hlc,omitempty"`          // Hybrid logical clock, "<physical>:<logical>:<node>"
+	// Precondition optionally turns the op into a compare-and-set.
+	Precondition
+	// OpID and ClientID identify the op for deduplication of replays; they
+	// are set by the transport, not decoded from the payload.
+	OpID     string `json:"-"`
+	ClientID string `json:"-"`
+}
+
+// Mutation converts the operation into the strategy-level mutation.
+func (op Operation) Mutation() sync.Mutation {
+	kind := op.Op
+	if kind == "" {
+		kind = sync.OpSet
+	}
+	path := op.Path
+	if len(path) == 0 && op.Key != "" {
+		path = []interface{}{op.Key}
+	}
+	return sync.Mutation{
+		Kind:        kind,
+		Path:        path,
+		Value:       op.Value,
+		Index:       op.Index,
+		DeleteCount: op.DeleteCount,
+		Delta:       op.Delta,
+	}
+}
+
+// validMutation converts the operation into a validated mutation.
+func (op Operation) validMutation() (sync.Mutation, error) {
+	if op.Key == "" && len(op.Path) == 0 {
+		return sync.Mutation{}, fmt.Errorf("%w: key is missing", ErrInvalidOperation)
+	}
+	m := op.Mutation()
+	if err := m.Validate(); err != nil {
+		return m, fmt.Errorf("%w: %v", ErrInvalidOperation, err)
+	}
+	if op.Key != "" && op.Key != m.Key() {
+		return m, fmt.Errorf("%w: key %q does not match path", ErrInvalidOperation, op.Key)
+	}
+	return m, nil
 }
 
 // Snapshot represents a point-in-time view of the document state including version heads.
+// Version identifies the state for if_version preconditions.
 type Snapshot struct {
-	Data  map[string]interface{} `json:"data"`
-	Heads []string               `json:"heads"`
+	Data    map[string]interface{} `json:"data"`
+	Heads   []string               `json:"heads"`
+	Version string                 `json:"version"`
+}
+
+// Delta is what a client holding an older version is missing, expressed on
+// top-level keys: the keys whose values changed and the keys that were removed.
+type Delta struct {
+	Data    map[string]interface{} `json:"data"`
+	Deleted []string               `json:"deleted"`
+	Heads   []string               `json:"heads"`
+	Version string                 `json:"version"`
 }
 
 // Change represents a single commit in history (re-exported from sync package).
@@ -51,10 +131,27 @@ type Engine struct {
 	store    store.Store
 	strategy sync.SyncStrategy
 	logger   *slog.Logger
-	// mu protects the engine state.
-	// Note: Granular locking per-workspace is planned for future optimization
-	// when contention on the global lock becomes a bottleneck (>100k OPS).
-	mu         gosync.Mutex
+	// locks serializes access to each workspace's document. Operations on
+	// different workspaces never contend with each other.
+	locks *workspaceLocks
+	// cache keeps live documents of active workspaces in memory.
+	cache               *docCache
+	compactionThreshold int
+	// compactor holds workspaces waiting for background compaction. It is nil
+	// until RunCompactor starts, in which case compaction happens inline.
+	compactMu gosync.Mutex
+	compactor map[string]struct{}
+	// clock stamps operations that arrive without a client HLC.
+	clock           *sync.Clock
+	timestampPolicy TimestampPolicy
+	// schemas caches compiled JSON Schemas by hash.
+	schemas *schemaCache
+	// dedupWindow is how many recent op IDs are kept per client, for at
+	// most dedupTTL after the client's last op.
+	dedupWindow int
+	dedupTTL    time.Duration
+	// mu protects the replication settings below.
+	mu         gosync.RWMutex
 	replicator replication.Replicator
 	region     string
 	serverID   string
@@ -62,8 +159,14 @@ type Engine struct {
 
 // EngineConfig holds engine configuration.
 type EngineConfig struct {
-	Strategy sync.SyncStrategy
-	Logger   *slog.Logger
+	Strategy            sync.SyncStrategy
+	Logger              *slog.Logger
+	CacheSize           int
+	CompactionThreshold int
+	NodeID              string
+	TimestampPolicy     TimestampPolicy
+	DedupWindow         int
+	DedupTTL            time.Duration
 }
 
 // EngineOption configures the engine.
@@ -83,30 +186,79 @@ func WithLogger(l *slog.Logger) EngineOption {
 	}
 }
 
+// WithCacheSize sets how many live documents are kept in memory.
+// A size of 0 disables caching; every operation then loads from the store.
+func WithCacheSize(n int) EngineOption {
+	return func(cfg *EngineConfig) {
+		cfg.CacheSize = n
+	}
+}
+
+// WithCompactionThreshold sets how many change log entries a document may
+// accumulate before it is compacted into a new snapshot.
+func WithCompactionThreshold(n int) EngineOption {
+	return func(cfg *EngineConfig) {
+		cfg.CompactionThreshold = n
+	}
+}
+
+// WithNodeID sets the node ID used in this engine's HLC timestamps.
+// Defaults to a random ID; it only needs to be unique among servers.
+func WithNodeID(id string) EngineOption {
+	return func(cfg *EngineConfig) {
+		cfg.NodeID = id
+	}
+}
+
+// WithTimestampPolicy sets how client timestamps outside the allowed clock
+// skew are handled. Defaults to DefaultTimestampPolicy.
+func WithTimestampPolicy(p TimestampPolicy) EngineOption {
+	return func(cfg *EngineConfig) {
+		cfg.TimestampPolicy = p
+	}
+}
+
 // NewEngine creates a new sync engine with the given store and options.
 // Defaults to Automerge strategy for backward compatibility.
 func NewEngine(s store.Store, opts ...EngineOption) *Engine {
 	cfg := &EngineConfig{
-		Strategy: sync.NewAutomergeStrategy(),
-		Logger:   slog.New(slog.NewJSONHandler(os.Stderr, nil)),
+		Strategy:            sync.NewAutomergeStrategy(),
+		Logger:              slog.New(slog.NewJSONHandler(os.Stderr, nil)),
+		CacheSize:           DefaultCacheSize,
+		CompactionThreshold: DefaultCompactionThreshold,
+		TimestampPolicy:     DefaultTimestampPolicy(),
+		DedupWindow:         DefaultDedupWindow,
+		DedupTTL:            DefaultDedupTTL,
 	}
 
 	for _, opt := range opts {
 		opt(cfg)
 	}
+	if cfg.NodeID == "" {
+		cfg.NodeID = uuid.NewString()[:8]
+	}
 
 	cfg.Logger.Info("engine_initialized",
 		slog.String("strategy", cfg.Strategy.Name()),
+		slog.Int("cache_size", cfg.CacheSize),
 	)
 
 	return &Engine{
-		store:    s,
-		strategy: cfg.Strategy,
-		logger:   cfg.Logger,
+		store:               s,
+		strategy:            cfg.Strategy,
+		logger:              cfg.Logger,
+		locks:               newWorkspaceLocks(),
+		cache:               newDocCache(cfg.CacheSize),
+		compactionThreshold: cfg.CompactionThreshold,
+		clock:               sync.NewClock(cfg.NodeID),
+		timestampPolicy:     cfg.TimestampPolicy,
+		schemas:             newSchemaCache(schemaCacheSize),
+		dedupWindow:         cfg.DedupWindow,
+		dedupTTL:            cfg.DedupTTL,
 	}
 }
 
-// Strategy returns the current sync strategy name.
+// Strategy returns the default sync strategy name.
 func (e *Engine) Strategy() string {
 	return e.strategy.Name()
 }
@@ -126,151 +278,305 @@ func (e *Engine) SetReplicator(r replication.Replicator, region, serverID string
 
 // HasReplicator returns true if multi-region replication is configured.
 func (e *Engine) HasReplicator() bool {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	return e.replicator != nil
 }
 
+// replicationTarget returns the configured replicator and this node's identity.
+func (e *Engine) replicationTarget() (replication.Replicator, string, string) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.replicator, e.region, e.serverID
+}
+
 // fireSyncOperationMetric tracks operation metrics.
-func (e *Engine) fireSyncOperationMetric(op Operation, latencyMs int64) {
+func (e *Engine) fireSyncOperationMetric(op Operation, strategy string, latencyMs int64) {
 	e.logger.Info("sync_metric",
 		slog.String("event", "sync_operation_count"),
 		slog.String("workspace_id", op.WorkspaceID),
 		slog.Int64("latency_ms", latencyMs),
-		slog.String("strategy", e.strategy.Name()),
+		slog.String("strategy", strategy),
 	)
 }
 
+// stamp returns the HLC of an operation: the client's HLC if it sent one,
+// its legacy wall-clock Timestamp otherwise, or a fresh server timestamp.
+// Client timestamps are bounded by the TimestampPolicy and then advance the
+// server clock so later server stamps order after them.
+func (e *Engine) stamp(op Operation) (sync.HLC, error) {
+	var stamp sync.HLC
+	switch {
+	case op.HLC != "":
+		parsed, err := sync.ParseHLC(op.HLC)
+		if err != nil {
+			return stamp, fmt.Errorf("%w: %v", ErrInvalidOperation, err)
+		}
+		stamp = parsed
+	case op.Timestamp > 0:
+		stamp = sync.HLC{Physical: op.Timestamp}
+	default:
+		return e.clock.Now(), nil
+	}
+
+	stamp, ok, err := e.checkTimestamp(op.WorkspaceID, stamp)
+	if err != nil {
+		return stamp, err
+	}
+	if !ok {
+		return e.clock.Now(), nil
+	}
+	e.clock.Observe(stamp)
+	return stamp, nil
+}
+
 // ProcessOperation handles an incoming mutation using the configured strategy.
 func (e *Engine) ProcessOperation(op Operation) error {
+	_, err := e.ApplyOperation(op)
+	return err
+}
+
+// ApplyOperation is ProcessOperation returning the document heads right
+// after the operation, for acknowledging it to the client.
+//
+// An op whose OpID was already applied for its ClientID is not applied again;
+// ApplyOperation then returns ErrDuplicateOp together with the current heads.
+func (e *Engine) ApplyOperation(op Operation) ([]string, error) {
 	start := time.Now()
 
 	// Strict Validation
 	if op.WorkspaceID == "" {
-		return fmt.Errorf("invalid operation: workspace_id is missing")
+		return nil, fmt.Errorf("%w: workspace_id is missing", ErrInvalidOperation)
 	}
-	if op.Key == "" {
-		return fmt.Errorf("invalid operation: key is missing")
+	m, err := op.validMutation()
+	if err != nil {
+		return nil, err
 	}
 
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	unlock := e.locks.lock(op.WorkspaceID)
+	defer unlock()
 
 	// 1. Load current document
-	current, err := e.loadDoc(op.WorkspaceID)
+	entry, err := e.openDoc(op.WorkspaceID)
 	if err != nil {
-		return fmt.Errorf("failed to load state: %w", err)
+		return nil, fmt.Errorf("failed to load state: %w", err)
 	}
 
-	// 2. Determine timestamp
-	ts := time.Now()
-	if op.Timestamp > 0 {
-		ts = time.UnixMicro(op.Timestamp)
+	// 2. Drop replays, then check the precondition of conditional writes
+	if err := e.checkDuplicate(entry, op.ClientID, op.OpID); err != nil {
+		return duplicateResult(entry, err)
+	}
+	if err := e.checkPrecondition(entry, op.Precondition, m.Key()); err != nil {
+		return nil, err
 	}
 
-	// 3. Apply mutation via strategy
-	newDoc, err := e.strategy.ProcessWrite(current, op.Key, op.Value, ts)
+	// 3. Determine timestamp
+	stamp, err := e.stamp(op)
 	if err != nil {
-		return fmt.Errorf("failed to apply operation: %w", err)
+		return nil, err
+	}
+	m.HLC = stamp
+	ts := stamp.Time()
+
+	// 4. Apply mutation via strategy
+	if err := entry.doc.Apply(m, ts); err != nil {
+		if !errors.Is(err, sync.ErrUnsupportedOp) && !errors.Is(err, sync.ErrInvalidMutation) {
+			// The live document may hold a partial mutation; drop it and reload next time.
+			e.cache.remove(op.WorkspaceID)
+		}
+		return nil, fmt.Errorf("failed to apply operation: %w", err)
 	}
 
-	// 4. Persist
-	if err := e.saveDoc(op.WorkspaceID, newDoc); err != nil {
-		return fmt.Errorf("failed to persist state: %w", err)
+	// 5. Validate the resulting state against the workspace schema, if any
+	if err := e.checkSchema(entry); err != nil {
+		// The rejected mutation is only in memory; reload from the store.
+		e.cache.remove(op.WorkspaceID)
+		return nil, err
 	}
 
-	// 5. Broadcast to peer regions (if replication enabled)
-	if e.replicator != nil {
-		event := replication.ChangeEvent{
-			WorkspaceID:    op.WorkspaceID,
-			Changes:        newDoc,
-			OriginRegion:   e.region,
-			OriginServerID: e.serverID,
-			Timestamp:      time.Now(),
-		}
-		if err := e.replicator.Broadcast(context.Background(), event); err != nil {
-			e.logger.Error("replication_broadcast_failed",
-				slog.String("workspace_id", op.WorkspaceID),
-				slog.Any("error", err),
-			)
-		}
+	// 6. Persist
+	if err := e.persist(entry); err != nil {
+		e.cache.remove(op.WorkspaceID)
+		return nil, fmt.Errorf("failed to persist state: %w", err)
 	}
+	e.recordOp(entry, op.ClientID, op.OpID)
+
+	// 7. Broadcast to peer regions (if replication enabled)
+	e.replicate(entry)
 
 	latency := time.Since(start).Milliseconds()
-	e.fireSyncOperationMetric(op, latency)
+	e.fireSyncOperationMetric(op, entry.strategy.Name(), latency)
 
-	return nil
+	return entry.doc.Heads()
+}
+
+// checkSchema validates the state of entry against its workspace schema.
+// The caller must hold the workspace lock.
+func (e *Engine) checkSchema(entry *cachedDoc) error {
+	return e.checkDocSchema(entry, entry.doc)
+}
+
+// checkDocSchema validates the state of doc, e.g. a replica holding changes
+// that were not merged into entry yet, against the workspace schema of entry.
+// The caller must hold the workspace lock.
+func (e *Engine) checkDocSchema(entry *cachedDoc, doc sync.Document) error {
+	sch, err := e.schemaFor(entry)
+	if err != nil || sch == nil {
+		return err
+	}
+	state, err := doc.State()
+	if err != nil {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+	return validateState(entry.workspaceID, sch, state)
 }
 
 // GetFullState returns the materialized view of the document and its current heads.
 func (e *Engine) GetFullState(workspaceID string) (*Snapshot, error) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	unlock := e.locks.lock(workspaceID)
+	defer unlock()
+
+	entry, err := e.openDoc(workspaceID)
+	if err != nil {
+		return nil, err
+	}
 
-	doc, err := e.loadDoc(workspaceID)
+	data, err := entry.doc.State()
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := e.strategy.GetState(doc)
+	heads, err := entry.doc.Heads()
 	if err != nil {
 		return nil, err
 	}
 
-	heads, err := e.strategy.GetHeads(doc)
+	version, err := stateVersion(data)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Snapshot{
-		Data:  data,
-		Heads: heads,
+		Data:    data,
+		Heads:   heads,
+		Version: version,
 	}, nil
 }
 
+// GetDelta computes what changed since the given heads. ok is false when the
+// client must bootstrap from a full snapshot instead: since is empty or
+// unknown (e.g. the document was recreated), or the strategy keeps no history.
+func (e *Engine) GetDelta(workspaceID string, since []string) (*Delta, bool, error) {
+	if len(since) == 0 {
+		return nil, false, nil
+	}
+
+	unlock := e.locks.lock(workspaceID)
+	defer unlock()
+
+	entry, err := e.openDoc(workspaceID)
+	if err != nil {
+		return nil, false, err
+	}
+	vd, ok := entry.doc.(sync.VersionedDocument)
+	if !ok {
+		return nil, false, nil
+	}
+
+	past, err := vd.StateAt(since)
+	if errors.Is(err, sync.ErrUnknownHeads) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	current, err := vd.State()
+	if err != nil {
+		return nil, false, err
+	}
+	heads, err := vd.Heads()
+	if err != nil {
+		return nil, false, err
+	}
+
+	version, err := stateVersion(current)
+	if err != nil {
+		return nil, false, err
+	}
+
+	delta := &Delta{Data: map[string]interface{}{}, Deleted: []string{}, Heads: heads, Version: version}
+	for key, value := range current {
+		if old, exists := past[key]; !exists || !reflect.DeepEqual(old, value) {
+			delta.Data[key] = value
+		}
+	}
+	for key := range past {
+		if _, exists := current[key]; !exists {
+			delta.Deleted = append(delta.Deleted, key)
+		}
+	}
+	sort.Strings(delta.Deleted)
+	return delta, true, nil
+}
+
 // GetChanges returns the delta since a specific version vector.
 func (e *Engine) GetChanges(workspaceID string, since []string) ([]byte, error) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	unlock := e.locks.lock(workspaceID)
+	defer unlock()
+
+	// Resident documents answer directly. Otherwise try the stored change log
+	// before paying for a full load.
+	if entry, ok := e.cache.get(workspaceID); ok {
+		return entry.doc.Changes(since)
+	}
+	if len(since) > 0 {
+		delta, ok, err := e.changesFromLog(workspaceID, since)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return delta, nil
+		}
+	}
 
-	doc, err := e.loadDoc(workspaceID)
+	entry, err := e.openDoc(workspaceID)
 	if err != nil {
 		return nil, err
 	}
 
-	return e.strategy.GetChanges(doc, since)
+	return entry.doc.Changes(since)
 }
 
 // GetHistory returns the list of changes for the document.
 func (e *Engine) GetHistory(workspaceID string) ([]Change, error) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	unlock := e.locks.lock(workspaceID)
+	defer unlock()
 
-	doc, err := e.loadDoc(workspaceID)
+	entry, err := e.openDoc(workspaceID)
 	if err != nil {
 		return nil, err
 	}
 
-	return e.strategy.GetHistory(doc)
+	return entry.doc.History()
 }
 
 // Stats returns aggregated metrics from the engine and store.
 func (e *Engine) Stats() (map[string]interface{}, error) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-
 	storeStats, err := e.store.Stats()
 	if err != nil {
 		return nil, err
 	}
 
 	return map[string]interface{}{
-		"store":    storeStats,
-		"strategy": e.strategy.Name(),
+		"store":            storeStats,
+		"strategy":         e.strategy.Name(),
+		"cached_documents": e.cache.len(),
 	}, nil
 }
 
-// ApplyRemoteChanges merges changes received from peer replicas.
+// ApplyRemoteChanges merges changes received from peer replicas. Changes
+// that would leave the document violating the workspace schema are rejected
+// with a *SchemaError and not persisted.
 func (e *Engine) ApplyRemoteChanges(workspaceID string, remoteDoc []byte) error {
 	if workspaceID == "" {
 		return fmt.Errorf("workspace_id is required")
@@ -279,27 +585,33 @@ func (e *Engine) ApplyRemoteChanges(workspaceID string, remoteDoc []byte) error
 		return nil
 	}
 
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	unlock := e.locks.lock(workspaceID)
+	defer unlock()
 
-	local, err := e.loadDoc(workspaceID)
+	entry, err := e.openDoc(workspaceID)
 	if err != nil {
 		return fmt.Errorf("failed to load local state: %w", err)
 	}
 
-	merged, err := e.strategy.Merge(local, remoteDoc)
-	if err != nil {
+	if err := entry.doc.Merge(remoteDoc); err != nil {
+		e.cache.remove(workspaceID)
 		return fmt.Errorf("failed to merge: %w", err)
 	}
+	if err := e.checkSchema(entry); err != nil {
+		// The merge is only in memory; reload from the store.
+		e.cache.remove(workspaceID)
+		return err
+	}
 
-	if err := e.saveDoc(workspaceID, merged); err != nil {
+	if err := e.persist(entry); err != nil {
+		e.cache.remove(workspaceID)
 		return fmt.Errorf("failed to persist merged state: %w", err)
 	}
 
 	e.logger.Debug("remote_changes_applied",
 		slog.String("workspace_id", workspaceID),
-		slog.Int("merged_size_bytes", len(merged)),
-		slog.String("strategy", e.strategy.Name()),
+		slog.Int("log_length", entry.logLen),
+		slog.String("strategy", entry.strategy.Name()),
 	)
 
 	return nil
@@ -317,19 +629,230 @@ func (e *Engine) loadDoc(workspaceID string) ([]byte, error) {
 
 	data, ok := val.([]byte)
 	if !ok {
-		e.logger.Warn("store_type_mismatch",
-			slog.String("workspace_id", workspaceID),
-			slog.String("expected", "[]byte"),
-			slog.String("got", fmt.Sprintf("%T", val)),
-		)
-		return nil, nil // Treat as empty, strategy will initialize
+		return nil, fmt.Errorf("%w: %s snapshot has type %T", ErrFormatMismatch, workspaceID, val)
 	}
 	return data, nil
 }
 
-// saveDoc persists document bytes to the store.
-func (e *Engine) saveDoc(workspaceID string, doc []byte) error {
-	return e.store.Set("ws:"+workspaceID, docKey, doc)
+// openDoc returns the live document for workspaceID, loading the snapshot and
+// replaying its change log on a cache miss. The caller must hold the workspace lock.
+func (e *Engine) openDoc(workspaceID string) (*cachedDoc, error) {
+	if entry, ok := e.cache.get(workspaceID); ok {
+		return entry, nil
+	}
+
+	stored, err := e.loadStored(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	strategy := e.strategy
+	if stored.bound {
+		if strategy, err = e.strategyFor(stored.meta.Strategy); err != nil {
+			return nil, err
+		}
+	}
+	// Refuse to read bytes written by another strategy; they would load as
+	// an empty document and the next write would overwrite them. The stored
+	// tag is trusted over detection, which cannot tell the JSON formats apart
+	// reliably; untagged legacy documents are all detection has to go on.
+	if stored.bound && stored.meta.MigratingTo != "" {
+		return nil, fmt.Errorf("%w: %s was being migrated to %s; run the migration again",
+			ErrFormatMismatch, workspaceID, stored.meta.MigratingTo)
+	}
+	if !sync.FormatMatches(stored.data, sync.StrategyType(strategy.Name())) {
+		return nil, fmt.Errorf("%w: %s holds %s data but is opened as %s",
+			ErrFormatMismatch, workspaceID, sync.DetectFormat(stored.data), strategy.Name())
+	}
+	if format := sync.DetectFormat(stored.data); !stored.bound && format != "" && string(format) != strategy.Name() {
+		return nil, fmt.Errorf("%w: %s holds %s data but is opened as %s",
+			ErrFormatMismatch, workspaceID, format, strategy.Name())
+	}
+
+	doc, err := sync.OpenDocument(strategy, stored.data)
+	if err != nil {
+		return nil, err
+	}
+	// The schema is loaded with the document so that a cached document is
+	// validated against the schema that was current when it was read.
+	rawSchema, _, err := e.Schema(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &cachedDoc{
+		workspaceID: workspaceID,
+		doc:         doc,
+		strategy:    strategy,
+		bound:       stored.bound,
+		nextSeq:     stored.logMeta.BaseSeq + uint64(len(stored.entries)),
+		logLen:      len(stored.entries),
+		schemaHash:  schemaHash(rawSchema),
+	}
+	e.cache.put(entry)
+	return entry, nil
+}
+
+// storedDoc is everything persisted for one workspace.
+type storedDoc struct {
+	meta    DocumentMeta
+	bound   bool
+	data    []byte // snapshot followed by the live change log chunks
+	logMeta logMeta
+	entries []logEntry
+}
+
+// loadStored reads the document metadata, snapshot and change log of workspaceID.
+func (e *Engine) loadStored(workspaceID string) (*storedDoc, error) {
+	var (
+		stored storedDoc
+		err    error
+	)
+	if stored.meta, stored.bound, err = e.loadDocMeta(workspaceID); err != nil {
+		return nil, err
+	}
+	if stored.data, err = e.loadDoc(workspaceID); err != nil {
+		return nil, err
+	}
+	if stored.logMeta, err = e.loadLogMeta(workspaceID); err != nil {
+		return nil, err
+	}
+	if stored.entries, err = e.loadLog(workspaceID, stored.logMeta.BaseSeq); err != nil {
+		return nil, err
+	}
+
+	// Incremental chunks are encoded to be appended to the snapshot.
+	if len(stored.entries) > 0 {
+		stored.data = append([]byte(nil), stored.data...)
+		for _, le := range stored.entries {
+			stored.data = append(stored.data, le.Chunk...)
+		}
+	}
+	return &stored, nil
+}
+
+// replicate broadcasts the document to peer regions if replication is enabled.
+// The caller must hold the workspace lock.
+func (e *Engine) replicate(entry *cachedDoc) {
+	replicator, region, serverID := e.replicationTarget()
+	if replicator == nil {
+		return
+	}
+	event := replication.ChangeEvent{
+		WorkspaceID:    entry.workspaceID,
+		Changes:        entry.doc.Save(),
+		OriginRegion:   region,
+		OriginServerID: serverID,
+		Timestamp:      time.Now(),
+	}
+	if err := replicator.Broadcast(context.Background(), event); err != nil {
+		e.logger.Error("replication_broadcast_failed",
+			slog.String("workspace_id", entry.workspaceID),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// persist writes the changes of a live document to the store.
+//
+// When the strategy supports it, only the incremental chunk is appended to the
+// change log, so the cost of a write scales with the size of the change. Formats
+// without incremental encoding rewrite the snapshot. The first write of an
+// unbound document binds it to its strategy. The caller must hold the
+// workspace lock.
+func (e *Engine) persist(entry *cachedDoc) error {
+	if !entry.bound {
+		meta := DocumentMeta{Strategy: sync.StrategyType(entry.strategy.Name()), CreatedAt: time.Now().UTC()}
+		if err := e.saveDocMeta(entry.workspaceID, meta); err != nil {
+			return fmt.Errorf("failed to save document metadata: %w", err)
+		}
+		entry.bound = true
+	}
+
+	incremental := entry.doc.SaveIncremental()
+	if incremental == nil {
+		return e.store.Set("ws:"+entry.workspaceID, docKey, entry.doc.Save())
+	}
+	if len(incremental) == 0 {
+		return nil // Nothing new to write
+	}
+
+	heads, err := entry.doc.Heads()
+	if err != nil {
+		return err
+	}
+	if err := e.appendLog(entry.workspaceID, entry.nextSeq, incremental, heads); err != nil {
+		return err
+	}
+	entry.nextSeq++
+	entry.logLen++
+
+	if entry.logLen >= e.compactionThreshold && !e.scheduleCompaction(entry.workspaceID) {
+		return e.compact(entry)
+	}
+	return nil
+}
+
+// scheduleCompaction queues workspaceID for the background compactor.
+// It reports false if no compactor is running.
+func (e *Engine) scheduleCompaction(workspaceID string) bool {
+	e.compactMu.Lock()
+	defer e.compactMu.Unlock()
+
+	if e.compactor == nil {
+		return false
+	}
+	e.compactor[workspaceID] = struct{}{}
+	return true
+}
+
+// RunCompactor folds change logs that exceeded the compaction threshold into new
+// snapshots every interval, keeping compaction off the write path. It blocks
+// until ctx is cancelled; without it, compaction runs inline on the write that
+// crosses the threshold.
+func (e *Engine) RunCompactor(ctx context.Context, interval time.Duration) {
+	e.compactMu.Lock()
+	e.compactor = make(map[string]struct{})
+	e.compactMu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			e.compactMu.Lock()
+			pending := e.compactor
+			e.compactor = nil
+			e.compactMu.Unlock()
+			e.compactAll(pending)
+			return
+		case <-ticker.C:
+			e.compactMu.Lock()
+			pending := e.compactor
+			e.compactor = make(map[string]struct{})
+			e.compactMu.Unlock()
+			e.compactAll(pending)
+		}
+	}
+}
+
+// compactAll compacts each of the given workspaces under its own lock.
+func (e *Engine) compactAll(workspaces map[string]struct{}) {
+	for workspaceID := range workspaces {
+		unlock := e.locks.lock(workspaceID)
+		entry, err := e.openDoc(workspaceID)
+		if err == nil {
+			err = e.compact(entry)
+		}
+		unlock()
+
+		if err != nil {
+			e.logger.Error("compaction_failed",
+				slog.String("workspace_id", workspaceID),
+				slog.Any("error", err),
+			)
+		}
+	}
 }
 
 // String helper for Operation debugging.
@@ -0,0 +1,206 @@
+package crdt_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/automerge/automerge-go"
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+)
+
+// countingStore wraps a MemoryStore and records document reads.
+type countingStore struct {
+	*store.MemoryStore
+	gets atomic.Int64
+
+	mu   sync.Mutex
+	keys []string
+}
+
+func (s *countingStore) Get(namespace, key string) (interface{}, bool, error) {
+	s.gets.Add(1)
+	s.mu.Lock()
+	s.keys = append(s.keys, key)
+	s.mu.Unlock()
+	return s.MemoryStore.Get(namespace, key)
+}
+
+// reads returns the keys read since the last call.
+func (s *countingStore) reads() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := s.keys
+	s.keys = nil
+	return keys
+}
+
+// TestEngine_HotCache_NoReloadPerOp verifies that a resident document is not
+// reloaded from the store on every operation.
+func TestEngine_HotCache_NoReloadPerOp(t *testing.T) {
+	cs := &countingStore{MemoryStore: store.NewMemoryStore()}
+	engine := crdt.NewEngine(cs)
+
+	if err := engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-hot", Key: "k0", Value: 0}); err != nil {
+		t.Fatal(err)
+	}
+	afterFirstLoad := cs.gets.Load()
+	// The first load reads the snapshot, the log and its metadata once each.
+	seen := make(map[string]bool)
+	for _, key := range cs.reads() {
+		if seen[key] {
+			t.Errorf("Expected each key to be read once on the first load, %s was read again", key)
+		}
+		seen[key] = true
+	}
+	if !seen["sync_doc"] || !seen["sync_log_meta"] {
+		t.Errorf("Expected the first load to read the snapshot and log metadata, got %v", seen)
+	}
+
+	for i := 1; i < 20; i++ {
+		op := crdt.Operation{WorkspaceID: "ws-hot", Key: fmt.Sprintf("k%d", i), Value: i}
+		if err := engine.ProcessOperation(op); err != nil {
+			t.Fatalf("op %d failed: %v", i, err)
+		}
+	}
+	if _, err := engine.GetFullState("ws-hot"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := cs.gets.Load(); n != afterFirstLoad {
+		t.Errorf("Expected no store reads after the first load, got %d", n-afterFirstLoad)
+	}
+}
+
+// TestEngine_HotCache_LRUEviction verifies that least recently used documents are
+// evicted and transparently reloaded with their persisted state.
+func TestEngine_HotCache_LRUEviction(t *testing.T) {
+	cs := &countingStore{MemoryStore: store.NewMemoryStore()}
+	engine := crdt.NewEngine(cs, crdt.WithCacheSize(1))
+
+	if err := engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-a", Key: "k", Value: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-b", Key: "k", Value: "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A cold engine shows what loading ws-a from the store costs.
+	cs.reads()
+	if _, err := crdt.NewEngine(cs).GetFullState("ws-a"); err != nil {
+		t.Fatal(err)
+	}
+	coldLoad := cs.reads()
+
+	snapshot, err := engine.GetFullState("ws-a") // evicted by ws-b
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reload := cs.reads(); strings.Join(reload, ",") != strings.Join(coldLoad, ",") {
+		t.Errorf("Expected evicted document to be reloaded like a cold load %v, got %v", coldLoad, reload)
+	}
+	if snapshot.Data["k"] != "a" {
+		t.Errorf("Expected 'a' after reload, got %v", snapshot.Data["k"])
+	}
+
+	stats, err := engine.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats["cached_documents"] != 1 {
+		t.Errorf("Expected 1 cached document, got %v", stats["cached_documents"])
+	}
+}
+
+// TestEngine_IncrementalPersistence_Compaction verifies that writes append
+// incremental chunks to the change log and that compaction folds the log
+// into a single snapshot that a fresh engine loads without replaying entries.
+func TestEngine_IncrementalPersistence_Compaction(t *testing.T) {
+	cs := &countingStore{MemoryStore: store.NewMemoryStore()}
+	engine := crdt.NewEngine(cs, crdt.WithCompactionThreshold(3))
+	workspaceID := "ws-compact"
+	ns := "ws:" + workspaceID
+
+	write := func(i int) {
+		op := crdt.Operation{WorkspaceID: workspaceID, Key: fmt.Sprintf("k%d", i), Value: i}
+		if err := engine.ProcessOperation(op); err != nil {
+			t.Fatalf("op %d failed: %v", i, err)
+		}
+	}
+	logChunks := func() [][]byte {
+		all, _ := cs.GetAll(ns)
+		var chunks [][]byte
+		for seq := 0; ; seq++ {
+			val, ok := all[fmt.Sprintf("sync_log:%020d", seq)]
+			if !ok {
+				return chunks
+			}
+			var entry struct {
+				Chunk []byte `json:"chunk"`
+			}
+			if err := json.Unmarshal(val.([]byte), &entry); err != nil {
+				t.Fatalf("log entry %d must decode: %v", seq, err)
+			}
+			chunks = append(chunks, entry.Chunk)
+		}
+	}
+
+	// Below the threshold every write is one incremental chunk in the log.
+	write(0)
+	write(1)
+	chunks := logChunks()
+	if len(chunks) != 2 {
+		t.Fatalf("Expected 2 log entries, got %d", len(chunks))
+	}
+	doc, err := automerge.Load(bytes.Join(chunks, nil))
+	if err != nil {
+		t.Fatalf("log chunks must load as a document: %v", err)
+	}
+	if changes, _ := doc.Changes(); len(changes) != 2 {
+		t.Errorf("Expected 2 changes in the log, got %d", len(changes))
+	}
+
+	// Reaching the threshold folds the log into one compacted snapshot.
+	write(2)
+	if chunks := logChunks(); len(chunks) != 0 {
+		t.Errorf("Expected the log to be folded away, got %d entries", len(chunks))
+	}
+	val, exists, _ := cs.MemoryStore.Get(ns, "sync_doc")
+	if !exists {
+		t.Fatal("Expected a snapshot after compaction")
+	}
+	doc, err = automerge.Load(val.([]byte))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(val.([]byte), doc.Save()) {
+		t.Error("Expected the snapshot to be compacted into a single chunk")
+	}
+	if changes, _ := doc.Changes(); len(changes) != 3 {
+		t.Errorf("Expected 3 changes in the snapshot, got %d", len(changes))
+	}
+
+	// A fresh engine recovers the full state from the snapshot alone.
+	cs.reads()
+	snapshot, err := crdt.NewEngine(cs).GetFullState(workspaceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Data) != 3 {
+		t.Errorf("Expected 3 keys after reload, got %v", snapshot.Data)
+	}
+	logReads := 0
+	for _, key := range cs.reads() {
+		if strings.HasPrefix(key, "sync_log:") {
+			logReads++
+		}
+	}
+	if logReads != 1 { // Only the probe for the first, missing entry
+		t.Errorf("Expected no log entries to be replayed, got %d log reads", logReads)
+	}
+}
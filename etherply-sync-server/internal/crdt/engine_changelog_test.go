@@ -0,0 +1,161 @@
+package crdt_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/automerge/automerge-go"
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+)
+
+func writeKeys(t *testing.T, engine *crdt.Engine, workspaceID string, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		op := crdt.Operation{WorkspaceID: workspaceID, Key: fmt.Sprintf("k%d", i), Value: int64(i)}
+		if err := engine.ProcessOperation(op); err != nil {
+			t.Fatalf("op %d failed: %v", i, err)
+		}
+	}
+}
+
+// TestChangeLog_AppendAndCompact verifies that writes append to the change log and
+// that the log is folded into a snapshot once it reaches the threshold.
+func TestChangeLog_AppendAndCompact(t *testing.T) {
+	ms := store.NewMemoryStore()
+	engine := crdt.NewEngine(ms, crdt.WithCompactionThreshold(3))
+	workspaceID := "ws-log"
+
+	writeKeys(t, engine, workspaceID, 0, 2)
+
+	if _, exists, _ := ms.Get("ws:"+workspaceID, "sync_doc"); exists {
+		t.Error("Expected no snapshot before the threshold is reached")
+	}
+	all, _ := ms.GetAll("ws:" + workspaceID)
+	if len(all) != 3 { // document metadata + 2 log entries
+		t.Errorf("Expected 2 log entries, got %d keys: %v", len(all), all)
+	}
+
+	// The third entry reaches the threshold and triggers inline compaction.
+	writeKeys(t, engine, workspaceID, 2, 3)
+
+	val, exists, _ := ms.Get("ws:"+workspaceID, "sync_doc")
+	if !exists {
+		t.Fatal("Expected a snapshot after compaction")
+	}
+	doc, err := automerge.Load(val.([]byte))
+	if err != nil {
+		t.Fatalf("snapshot must load: %v", err)
+	}
+	if changes, _ := doc.Changes(); len(changes) != 3 {
+		t.Errorf("Expected 3 changes in snapshot, got %d", len(changes))
+	}
+	all, _ = ms.GetAll("ws:" + workspaceID)
+	if len(all) != 3 { // snapshot + document and log metadata
+		t.Errorf("Expected compacted log entries to be deleted, got keys: %v", all)
+	}
+}
+
+// TestChangeLog_Recovery verifies that a restarted engine rebuilds the document
+// from the snapshot plus every log entry written after it.
+func TestChangeLog_Recovery(t *testing.T) {
+	ms := store.NewMemoryStore()
+	engine := crdt.NewEngine(ms, crdt.WithCompactionThreshold(4))
+	workspaceID := "ws-recover"
+
+	// 4 entries are compacted, the following 2 only exist in the log.
+	writeKeys(t, engine, workspaceID, 0, 6)
+
+	snapshot, err := crdt.NewEngine(ms).GetFullState(workspaceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Data) != 6 {
+		t.Errorf("Expected 6 keys after recovery, got %v", snapshot.Data)
+	}
+	if snapshot.Data["k5"] != int64(5) {
+		t.Errorf("Expected k5=5, got %v", snapshot.Data["k5"])
+	}
+}
+
+// TestChangeLog_DeltaWithoutLoading verifies that GetChanges is served from the log
+// on a cold engine, and that the delta applies on top of the client's copy.
+func TestChangeLog_DeltaWithoutLoading(t *testing.T) {
+	ms := store.NewMemoryStore()
+	// The client syncs right at a compaction point; later writes stay in the log.
+	engine := crdt.NewEngine(ms, crdt.WithCompactionThreshold(3))
+	workspaceID := "ws-delta-log"
+
+	writeKeys(t, engine, workspaceID, 0, 3)
+	clientBytes, err := engine.GetChanges(workspaceID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := automerge.Load(clientBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var since []string
+	for _, h := range client.Heads() {
+		since = append(since, h.String())
+	}
+
+	writeKeys(t, engine, workspaceID, 3, 5)
+
+	cold := crdt.NewEngine(ms)
+	delta, err := cold.GetChanges(workspaceID, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, _ := cold.Stats()
+	if stats["cached_documents"] != 0 {
+		t.Error("Expected delta to be served without loading the document")
+	}
+
+	if err := client.LoadIncremental(delta); err != nil {
+		t.Fatalf("failed to apply delta: %v", err)
+	}
+	if len(client.Heads()) != 1 {
+		t.Fatalf("Expected client to converge to one head, got %d", len(client.Heads()))
+	}
+	v, _ := client.Path("k4").Get()
+	if n, _ := automerge.As[int64](v); n != 4 {
+		t.Errorf("Expected k4=4 on client after delta, got %v", v)
+	}
+}
+
+// TestChangeLog_BackgroundCompactor verifies that a running compactor takes over
+// compaction from the write path.
+func TestChangeLog_BackgroundCompactor(t *testing.T) {
+	ms := store.NewMemoryStore()
+	engine := crdt.NewEngine(ms, crdt.WithCompactionThreshold(2))
+	workspaceID := "ws-bg"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go engine.RunCompactor(ctx, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond) // let the compactor register
+
+	writeKeys(t, engine, workspaceID, 0, 3)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, exists, _ := ms.Get("ws:"+workspaceID, "sync_doc"); exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background compactor never wrote a snapshot")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	snapshot, err := crdt.NewEngine(ms).GetFullState(workspaceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Data) != 3 {
+		t.Errorf("Expected 3 keys after background compaction, got %v", snapshot.Data)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
 )
 
 // TestEngine_Concurrency verifies that ProcessOperation is atomic and thread-safe.
@@ -88,3 +89,112 @@ func TestEngine_Concurrency(t *testing.T) {
 		t.Errorf("Unexpected value format: %s", valStr)
 	}
 }
+
+// gatedStore wraps a MemoryStore and blocks writes to one namespace until released.
+// It lets a test hold a workspace "mid-operation" for as long as it likes.
+type gatedStore struct {
+	*store.MemoryStore
+	namespace string
+	entered   chan struct{}
+	release   chan struct{}
+}
+
+func (s *gatedStore) Set(namespace, key string, value interface{}) error {
+	if namespace == s.namespace {
+		select {
+		case s.entered <- struct{}{}:
+		default:
+		}
+		<-s.release
+	}
+	return s.MemoryStore.Set(namespace, key, value)
+}
+
+// TestEngine_IndependentWorkspacesDoNotSerialize verifies that a slow operation on one
+// workspace does not block operations on unrelated workspaces.
+func TestEngine_IndependentWorkspacesDoNotSerialize(t *testing.T) {
+	gs := &gatedStore{
+		MemoryStore: store.NewMemoryStore(),
+		namespace:   "ws:ws-slow",
+		entered:     make(chan struct{}, 1),
+		release:     make(chan struct{}),
+	}
+	engine := crdt.NewEngine(gs)
+
+	slowDone := make(chan error, 1)
+	go func() {
+		slowDone <- engine.ProcessOperation(crdt.Operation{
+			WorkspaceID: "ws-slow",
+			Key:         "k",
+			Value:       "slow",
+		})
+	}()
+
+	// Wait until the slow workspace is holding its lock inside the store write.
+	select {
+	case <-gs.entered:
+	case <-time.After(2 * time.Second):
+		t.Fatal("slow operation never reached the store")
+	}
+
+	// Operations on other workspaces must complete while ws-slow is stuck.
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			wsID := fmt.Sprintf("ws-fast-%d", n)
+			if err := engine.ProcessOperation(crdt.Operation{WorkspaceID: wsID, Key: "k", Value: n}); err != nil {
+				errs <- err
+				return
+			}
+			if _, err := engine.GetFullState(wsID); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+
+	fastDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(fastDone)
+	}()
+
+	select {
+	case <-fastDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("operations on independent workspaces were serialized behind ws-slow")
+	}
+	close(errs)
+	for err := range errs {
+		t.Errorf("fast workspace operation failed: %v", err)
+	}
+
+	// A second operation on the same workspace must still wait for the first.
+	sameDone := make(chan error, 1)
+	go func() {
+		sameDone <- engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-slow", Key: "k", Value: "second"})
+	}()
+	select {
+	case <-sameDone:
+		t.Fatal("operation on the same workspace did not wait for the lock holder")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(gs.release)
+	if err := <-slowDone; err != nil {
+		t.Fatalf("slow operation failed: %v", err)
+	}
+	if err := <-sameDone; err != nil {
+		t.Fatalf("second operation failed: %v", err)
+	}
+
+	snapshot, err := engine.GetFullState("ws-slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Data["k"] != "second" {
+		t.Errorf("Expected 'second', got %v", snapshot.Data["k"])
+	}
+}
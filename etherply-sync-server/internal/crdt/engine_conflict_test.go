@@ -36,15 +36,13 @@ func TestOfflineEdit_TimestampRespect(t *testing.T) {
 		t.Fatalf("Failed to process offline op: %v", err)
 	}
 
-	// Verify history directly via internal store inspection to check commit timestamp
+	// Verify history by loading the full document to check the commit timestamp.
 	// This is a bit "white-box" but necessary to verify the requirement.
-	// Normally we'd use a History() method on the engine if it exposed one.
 
-	val, exists, _ := ms.Get("ws:"+workspaceID, "sync_doc")
-	if !exists {
+	data, err := engine.GetChanges(workspaceID, nil)
+	if err != nil || len(data) == 0 {
 		t.Fatal("Store empty")
 	}
-	data := val.([]byte)
 	doc, _ := automerge.Load(data)
 
 	// Get changes
@@ -108,11 +106,11 @@ func TestSequentialWrites_History(t *testing.T) {
 		Timestamp:   time.Now().Add(time.Second).UnixMicro(),
 	})
 
-	val, exists, _ := ms.Get("ws:"+workspaceID, "sync_doc")
-	if !exists {
+	data, err := engine.GetChanges(workspaceID, nil)
+	if err != nil || len(data) == 0 {
 		t.Fatal("Store empty")
 	}
-	doc, _ := automerge.Load(val.([]byte))
+	doc, _ := automerge.Load(data)
 	// We should see history growing
 	changes, _ := doc.Changes()
 	if len(changes) != 2 {
@@ -7,6 +7,8 @@ import (
 
 	"github.com/automerge/automerge-go"
 	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
 )
 
 func TestDeltaSync_API(t *testing.T) {
@@ -146,3 +148,54 @@ func TestDeltaSync_EmptyDocument(t *testing.T) {
 		t.Errorf("Expected empty bytes for non-existent workspace, got %d bytes", len(bytes))
 	}
 }
+
+func TestGetDelta_ChangedKeysSinceHeads(t *testing.T) {
+	engine, ms := setupMockEngine()
+	defer ms.Close()
+	ws := "ws-get-delta"
+
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: ws, Key: "a", Value: "1"})
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: ws, Key: "b", Value: "1"})
+	snap, _ := engine.GetFullState(ws)
+
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: ws, Key: "a", Value: "2"})
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: ws, Op: sync.OpDelete, Key: "b"})
+
+	delta, ok, err := engine.GetDelta(ws, snap.Heads)
+	if err != nil || !ok {
+		t.Fatalf("Expected delta, got ok=%v err=%v", ok, err)
+	}
+	if len(delta.Data) != 1 || delta.Data["a"] != "2" {
+		t.Errorf("Expected data {a:2}, got %v", delta.Data)
+	}
+	if len(delta.Deleted) != 1 || delta.Deleted[0] != "b" {
+		t.Errorf("Expected deleted [b], got %v", delta.Deleted)
+	}
+
+	// Nothing changed since the current heads.
+	delta, ok, _ = engine.GetDelta(ws, delta.Heads)
+	if !ok || len(delta.Data) != 0 || len(delta.Deleted) != 0 {
+		t.Errorf("Expected empty delta at current heads, got ok=%v %+v", ok, delta)
+	}
+
+	// Unknown heads and empty heads require a snapshot.
+	if _, ok, err := engine.GetDelta(ws, []string{"not-a-hash"}); ok || err != nil {
+		t.Errorf("Expected fallback for unknown heads, got ok=%v err=%v", ok, err)
+	}
+	if _, ok, _ := engine.GetDelta(ws, nil); ok {
+		t.Error("Expected fallback for empty heads")
+	}
+}
+
+func TestGetDelta_StrategyWithoutHistory(t *testing.T) {
+	ms := store.NewMemoryStore()
+	defer ms.Close()
+	engine := crdt.NewEngine(ms, crdt.WithStrategy(sync.NewLWWStrategy()))
+
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-lww", Key: "a", Value: "1"})
+	snap, _ := engine.GetFullState("ws-lww")
+
+	if _, ok, err := engine.GetDelta("ws-lww", snap.Heads); ok || err != nil {
+		t.Errorf("Expected LWW to fall back to snapshots, got ok=%v err=%v", ok, err)
+	}
+}
@@ -0,0 +1,146 @@
+package crdt_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+// decodeOp parses an operation the way the WebSocket handler does.
+func decodeOp(t *testing.T, raw string) crdt.Operation {
+	t.Helper()
+	var op crdt.Operation
+	if err := json.Unmarshal([]byte(raw), &op); err != nil {
+		t.Fatalf("Failed to decode op %s: %v", raw, err)
+	}
+	op.WorkspaceID = "ws-ops"
+	return op
+}
+
+func TestEngine_NestedPathOps(t *testing.T) {
+	engine, ms := setupMockEngine()
+	defer ms.Close()
+
+	ops := []string{
+		`{"key":"board","value":{"title":"Sprint"}}`,
+		`{"op":"insert","path":["board","cards",0],"value":{"title":"first"}}`,
+		`{"op":"set","path":["board","cards",0,"title"],"value":"renamed"}`,
+		`{"op":"increment","path":["board","votes"],"delta":4}`,
+		`{"op":"splice","path":["board","notes"],"value":"hi"}`,
+	}
+	for _, raw := range ops {
+		if err := engine.ProcessOperation(decodeOp(t, raw)); err != nil {
+			t.Fatalf("ProcessOperation(%s) failed: %v", raw, err)
+		}
+	}
+
+	snapshot, err := engine.GetFullState("ws-ops")
+	if err != nil {
+		t.Fatalf("Failed to get state: %v", err)
+	}
+	board, _ := snapshot.Data["board"].(map[string]interface{})
+	want := []interface{}{map[string]interface{}{"title": "renamed"}}
+	if !reflect.DeepEqual(board["cards"], want) {
+		t.Errorf("Expected cards %v, got %v", want, board["cards"])
+	}
+	if board["votes"] != int64(4) {
+		t.Errorf("Expected votes 4, got %v", board["votes"])
+	}
+	if board["notes"] != "hi" {
+		t.Errorf("Expected notes 'hi', got %v", board["notes"])
+	}
+}
+
+func TestEngine_InvalidAndUnsupportedOps(t *testing.T) {
+	engine, ms := setupMockEngine()
+	defer ms.Close()
+
+	invalid := []string{
+		`{"op":"move","key":"a"}`,
+		`{"op":"insert","path":["list","x"],"value":1}`,
+		`{"key":"other","path":["a"],"value":1}`,
+		`{"path":[0],"value":1}`,
+	}
+	for _, raw := range invalid {
+		err := engine.ProcessOperation(decodeOp(t, raw))
+		if !errors.Is(err, crdt.ErrInvalidOperation) {
+			t.Errorf("Expected ErrInvalidOperation for %s, got %v", raw, err)
+		}
+	}
+
+	lwwStore := store.NewMemoryStore()
+	defer lwwStore.Close()
+	lww := crdt.NewEngine(lwwStore, crdt.WithStrategy(sync.NewLWWStrategy()))
+
+	err := lww.ProcessOperation(decodeOp(t, `{"op":"increment","path":["n"],"delta":1}`))
+	if !errors.Is(err, sync.ErrUnsupportedOp) {
+		t.Errorf("Expected ErrUnsupportedOp from LWW engine, got %v", err)
+	}
+}
+
+func TestEngine_DeleteKey_AllStrategies(t *testing.T) {
+	for _, st := range []sync.StrategyType{sync.StrategyAutomerge, sync.StrategyLWW, sync.StrategyServerAuthoritative} {
+		ms := store.NewMemoryStore()
+		engine := crdt.NewEngine(ms, crdt.WithStrategy(sync.NewStrategy(st)))
+
+		ops := []string{
+			`{"key":"title","value":"draft","timestamp":1000}`,
+			`{"op":"delete","key":"title","timestamp":2000}`,
+		}
+		for _, raw := range ops {
+			if err := engine.ProcessOperation(decodeOp(t, raw)); err != nil {
+				t.Fatalf("%s: ProcessOperation(%s) failed: %v", st, raw, err)
+			}
+		}
+
+		snapshot, err := engine.GetFullState("ws-ops")
+		if err != nil {
+			t.Fatalf("%s: Failed to get state: %v", st, err)
+		}
+		if _, exists := snapshot.Data["title"]; exists {
+			t.Errorf("%s: expected 'title' to be deleted, got %v", st, snapshot.Data)
+		}
+		ms.Close()
+	}
+}
+
+func TestEngine_HLCOrdering(t *testing.T) {
+	ms := store.NewMemoryStore()
+	defer ms.Close()
+	engine := crdt.NewEngine(ms, crdt.WithStrategy(sync.NewLWWStrategy()), crdt.WithNodeID("server-1"))
+
+	// Same physical time from two clients: the node ID decides, whatever the arrival order.
+	ops := []string{
+		`{"key":"k","value":"from-b","hlc":"5000:0:client-b"}`,
+		`{"key":"k","value":"from-a","hlc":"5000:0:client-a"}`,
+	}
+	for _, raw := range ops {
+		if err := engine.ProcessOperation(decodeOp(t, raw)); err != nil {
+			t.Fatalf("ProcessOperation(%s) failed: %v", raw, err)
+		}
+	}
+	snapshot, _ := engine.GetFullState("ws-ops")
+	if snapshot.Data["k"] != "from-b" {
+		t.Errorf("Expected 'from-b' to win the tiebreak, got %v", snapshot.Data["k"])
+	}
+
+	// Ops without a timestamp are stamped by the server clock and always order
+	// after everything it has observed.
+	if err := engine.ProcessOperation(decodeOp(t, `{"key":"k","value":"server"}`)); err != nil {
+		t.Fatalf("ProcessOperation failed: %v", err)
+	}
+	snapshot, _ = engine.GetFullState("ws-ops")
+	if snapshot.Data["k"] != "server" {
+		t.Errorf("Expected server-stamped write to win, got %v", snapshot.Data["k"])
+	}
+
+	err := engine.ProcessOperation(decodeOp(t, `{"key":"k","value":"x","hlc":"garbage"}`))
+	if !errors.Is(err, crdt.ErrInvalidOperation) {
+		t.Errorf("Expected ErrInvalidOperation for malformed hlc, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package crdt_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/automerge/automerge-go"
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+// runSync exchanges messages between a session and a client until both are idle.
+func runSync(t *testing.T, session *crdt.SyncSession, client *automerge.SyncState) {
+	t.Helper()
+	for i := 0; i < 20; i++ {
+		progressed := false
+		if msg, ok, err := session.Generate(); err != nil {
+			t.Fatalf("Generate failed: %v", err)
+		} else if ok {
+			progressed = true
+			if _, err := client.ReceiveMessage(msg); err != nil {
+				t.Fatalf("Client failed to receive: %v", err)
+			}
+		}
+		if msg, ok := client.GenerateMessage(); ok {
+			progressed = true
+			if _, err := session.Receive(msg.Bytes()); err != nil {
+				t.Fatalf("Receive failed: %v", err)
+			}
+		}
+		if !progressed {
+			return
+		}
+	}
+	t.Fatal("Sync did not settle")
+}
+
+func TestSyncSession_SurvivesEviction(t *testing.T) {
+	ms := store.NewMemoryStore()
+	defer ms.Close()
+	// No cache: every call reloads the live document; the session keeps its sync state on its replica.
+	engine := crdt.NewEngine(ms, crdt.WithCacheSize(0))
+
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-sync", Key: "server", Value: "s"})
+
+	session, err := engine.NewSyncSession("ws-sync")
+	if err != nil {
+		t.Fatalf("NewSyncSession failed: %v", err)
+	}
+
+	clientDoc := automerge.New()
+	clientDoc.Path("client").Set("c")
+	clientDoc.Commit("client edit")
+	runSync(t, session, automerge.NewSyncState(clientDoc))
+
+	snapshot, _ := engine.GetFullState("ws-sync")
+	if snapshot.Data["client"] != "c" || snapshot.Data["server"] != "s" {
+		t.Errorf("Expected server to have both keys, got %v", snapshot.Data)
+	}
+	if v, _ := clientDoc.Path("server").Get(); v.Kind() != automerge.KindStr {
+		t.Errorf("Expected client to receive 'server', got %v", v)
+	}
+}
+
+func TestSyncSession_UnsupportedStrategy(t *testing.T) {
+	ms := store.NewMemoryStore()
+	defer ms.Close()
+	engine := crdt.NewEngine(ms, crdt.WithStrategy(sync.NewLWWStrategy()))
+
+	if _, err := engine.NewSyncSession("ws-lww"); !errors.Is(err, crdt.ErrSyncUnsupported) {
+		t.Errorf("Expected ErrSyncUnsupported, got %v", err)
+	}
+}
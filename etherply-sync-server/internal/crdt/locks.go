@@ -0,0 +1,63 @@
+package crdt
+
+import (
+	gosync "sync"
+)
+
+// workspaceLock is a reference-counted mutex guarding a single workspace.
+type workspaceLock struct {
+	mu   gosync.Mutex
+	refs int
+}
+
+// workspaceLocks hands out one mutex per workspace so that operations on
+// unrelated documents can proceed in parallel.
+//
+// Entries are reference counted and evicted as soon as the last holder
+// releases them, so the table only grows with the number of workspaces that
+// are concurrently active, not with every workspace ever touched.
+type workspaceLocks struct {
+	mu    gosync.Mutex
+	locks map[string]*workspaceLock
+}
+
+func newWorkspaceLocks() *workspaceLocks {
+	return &workspaceLocks{
+		locks: make(map[string]*workspaceLock),
+	}
+}
+
+// lock acquires the mutex for workspaceID and returns the function that
+// releases it. The release function must be called exactly once.
+func (l *workspaceLocks) lock(workspaceID string) func() {
+	l.mu.Lock()
+	wl, ok := l.locks[workspaceID]
+	if !ok {
+		wl = &workspaceLock{}
+		l.locks[workspaceID] = wl
+	}
+	// Take the reference before blocking on the workspace mutex so the entry
+	// cannot be evicted while we wait for it.
+	wl.refs++
+	l.mu.Unlock()
+
+	wl.mu.Lock()
+
+	return func() {
+		wl.mu.Unlock()
+
+		l.mu.Lock()
+		wl.refs--
+		if wl.refs == 0 {
+			delete(l.locks, workspaceID)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// active returns the number of workspaces currently holding or waiting on a lock.
+func (l *workspaceLocks) active() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.locks)
+}
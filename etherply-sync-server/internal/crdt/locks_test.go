@@ -0,0 +1,45 @@
+package crdt
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestWorkspaceLocks_Eviction verifies that lock entries are dropped once released,
+// so the table does not grow with every workspace ever touched.
+func TestWorkspaceLocks_Eviction(t *testing.T) {
+	l := newWorkspaceLocks()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			ws := "ws-a"
+			if n%2 == 0 {
+				ws = "ws-b"
+			}
+			unlock := l.lock(ws)
+			unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	if n := l.active(); n != 0 {
+		t.Errorf("Expected 0 lock entries after release, got %d", n)
+	}
+}
+
+// TestWorkspaceLocks_HeldEntrySurvives verifies an entry is kept while it is held.
+func TestWorkspaceLocks_HeldEntrySurvives(t *testing.T) {
+	l := newWorkspaceLocks()
+
+	unlock := l.lock("ws-held")
+	if n := l.active(); n != 1 {
+		t.Fatalf("Expected 1 lock entry while held, got %d", n)
+	}
+	unlock()
+	if n := l.active(); n != 0 {
+		t.Errorf("Expected 0 lock entries after release, got %d", n)
+	}
+}
@@ -0,0 +1,286 @@
+package crdt
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+// MigrationReport describes the conversion of one workspace to another strategy.
+type MigrationReport struct {
+	WorkspaceID string            `json:"workspace_id"`
+	From        sync.StrategyType `json:"from"`
+	To          sync.StrategyType `json:"to"`
+	Keys        int               `json:"keys"`
+	DryRun      bool              `json:"dry_run"`
+	// Unchanged is set when the document already is in the target format.
+	Unchanged bool     `json:"unchanged,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+// MigrateWorkspace rewrites the document of workspaceID in the format of the
+// target strategy and rebinds the workspace to it.
+//
+// The document is read with the strategy it was written with: the bound one,
+// or for documents written before strategies were bound, the one detected
+// from the stored bytes (see storedFormat). Its materialized state is then written key by key
+// into a fresh target document and compared with the original before
+// anything is stored. Strategy-specific metadata (Automerge history, LWW
+// timestamps, CRDT counter and text types) does not survive the conversion
+// and is listed in the report's warnings.
+//
+// With dryRun set the conversion is performed and verified but not stored.
+// Clients connected during a migration should reconnect afterwards.
+func (e *Engine) MigrateWorkspace(workspaceID string, to sync.StrategyType, dryRun bool) (*MigrationReport, error) {
+	target, err := e.strategyFor(to)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := e.locks.lock(workspaceID)
+	defer unlock()
+
+	stored, err := e.loadStored(workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", workspaceID, err)
+	}
+	from := e.storedFormat(stored)
+	source, err := e.strategyFor(from)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &MigrationReport{WorkspaceID: workspaceID, From: from, To: to, DryRun: dryRun}
+	if stored.bound && stored.meta.Strategy != from {
+		report.Warnings = append(report.Warnings,
+			fmt.Sprintf("bound to %s but stored as %s; reading as %s", stored.meta.Strategy, from, from))
+	}
+
+	doc, err := sync.OpenDocument(source, stored.data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s as %s: %w", workspaceID, from, err)
+	}
+	state, err := doc.State()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", workspaceID, err)
+	}
+	report.Keys = len(state)
+
+	if from == to {
+		report.Unchanged = true
+		// Bind legacy documents, or finish an interrupted migration.
+		if !dryRun && (!stored.bound || stored.meta.Strategy != to || stored.meta.MigratingTo != "") {
+			if err := e.saveDocMeta(workspaceID, DocumentMeta{Strategy: to, CreatedAt: time.Now().UTC()}); err != nil {
+				return nil, fmt.Errorf("failed to save document metadata: %w", err)
+			}
+			e.cache.remove(workspaceID)
+		}
+		return report, nil
+	}
+	report.Warnings = append(report.Warnings, migrationWarnings(from, to, doc)...)
+
+	converted, err := e.convert(target, state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert %s to %s: %w", workspaceID, to, err)
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	if err := e.storeMigrated(workspaceID, stored, doc, converted, from, to); err != nil {
+		return nil, err
+	}
+	e.cache.remove(workspaceID)
+
+	e.logger.Info("workspace_migrated",
+		slog.String("workspace_id", workspaceID),
+		slog.String("from", string(from)),
+		slog.String("to", string(to)),
+		slog.Int("keys", report.Keys),
+	)
+	return report, nil
+}
+
+// convert builds a target document holding state and verifies that it
+// materializes to the same JSON.
+func (e *Engine) convert(target sync.SyncStrategy, state map[string]interface{}) (sync.Document, error) {
+	doc, err := sync.OpenDocument(target, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(state))
+	for key := range state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		m := sync.SetMutation(key, state[key])
+		m.HLC = e.clock.Now()
+		if err := doc.Apply(m, m.HLC.Time()); err != nil {
+			return nil, fmt.Errorf("key %q: %w", key, err)
+		}
+	}
+
+	converted, err := doc.State()
+	if err != nil {
+		return nil, err
+	}
+	if !sameJSON(state, converted) {
+		return nil, fmt.Errorf("converted state does not match the original")
+	}
+	return doc, nil
+}
+
+// storeMigrated replaces the stored document with converted and rebinds it.
+//
+// Pending change log entries are first folded into a snapshot in the source
+// format, exactly as compaction does. The workspace is then marked as
+// migrating, the converted snapshot replaces the old one, and the workspace
+// is rebound last. A crash in between leaves the marker: the engine refuses
+// to open the document, and running the migration again completes it.
+func (e *Engine) storeMigrated(workspaceID string, stored *storedDoc, source, converted sync.Document, from, to sync.StrategyType) error {
+	ns := "ws:" + workspaceID
+	nextSeq := stored.logMeta.BaseSeq + uint64(len(stored.entries))
+
+	if len(stored.entries) > 0 {
+		if err := e.writeSnapshot(ns, source, nextSeq); err != nil {
+			return err
+		}
+		for seq := stored.logMeta.BaseSeq; seq < nextSeq; seq++ {
+			if err := e.store.Delete(ns, logKey(seq)); err != nil {
+				e.logger.Warn("log_cleanup_failed",
+					slog.String("workspace_id", workspaceID),
+					slog.Uint64("seq", seq),
+					slog.Any("error", err),
+				)
+			}
+		}
+	}
+
+	createdAt := stored.meta.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now().UTC()
+	}
+	if err := e.saveDocMeta(workspaceID, DocumentMeta{Strategy: from, CreatedAt: createdAt, MigratingTo: to}); err != nil {
+		return fmt.Errorf("failed to save document metadata: %w", err)
+	}
+	if err := e.writeSnapshot(ns, converted, nextSeq); err != nil {
+		return err
+	}
+	if err := e.saveDocMeta(workspaceID, DocumentMeta{Strategy: to, CreatedAt: createdAt}); err != nil {
+		return fmt.Errorf("failed to save document metadata: %w", err)
+	}
+	return nil
+}
+
+// writeSnapshot stores doc as the snapshot of namespace ns, followed by log
+// metadata starting the change log at baseSeq.
+func (e *Engine) writeSnapshot(ns string, doc sync.Document, baseSeq uint64) error {
+	heads, err := doc.Heads()
+	if err != nil {
+		return err
+	}
+	meta, err := json.Marshal(logMeta{BaseSeq: baseSeq, SnapshotHeads: heads})
+	if err != nil {
+		return err
+	}
+	if err := e.store.Set(ns, docKey, doc.Save()); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := e.store.Set(ns, logMetaKey, meta); err != nil {
+		return fmt.Errorf("failed to write log metadata: %w", err)
+	}
+	return nil
+}
+
+// storedFormat returns the strategy the stored document was written with. The
+// bound strategy is authoritative. Detection is used for untagged legacy
+// documents, for snapshots that are plainly not in the bound format (binary
+// Automerge vs JSON), and to tell which side of an interrupted migration the
+// snapshot is on.
+func (e *Engine) storedFormat(stored *storedDoc) sync.StrategyType {
+	detected := sync.DetectFormat(stored.data)
+	if !stored.bound {
+		if detected != "" {
+			return detected
+		}
+		return sync.StrategyType(e.strategy.Name())
+	}
+	if to := stored.meta.MigratingTo; to != "" && detected == to {
+		return to
+	}
+	if !sync.FormatMatches(stored.data, stored.meta.Strategy) {
+		return detected
+	}
+	return stored.meta.Strategy
+}
+
+// migrationWarnings lists what a conversion of doc from one strategy to
+// another loses.
+func migrationWarnings(from, to sync.StrategyType, doc sync.Document) []string {
+	var warnings []string
+	if from == sync.StrategyAutomerge {
+		warnings = append(warnings, "automerge change history is discarded")
+		if counters, texts := sync.CRDTTypes(doc); counters > 0 || texts > 0 {
+			warnings = append(warnings, fmt.Sprintf(
+				"%d counters and %d text objects become plain values without merge semantics", counters, texts))
+		}
+	}
+	if from == sync.StrategyLWW {
+		warnings = append(warnings, "lww timestamps and tombstones are discarded")
+	}
+	if to == sync.StrategyLWW {
+		warnings = append(warnings, "lww supports only top-level set and delete; nested ops on migrated keys will be rejected")
+	}
+	return warnings
+}
+
+// sameJSON compares two states by their JSON encoding, which erases the
+// numeric type differences between strategies (int64 counters vs float64).
+func sameJSON(a, b map[string]interface{}) bool {
+	normalize := func(v map[string]interface{}) interface{} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil
+		}
+		var out interface{}
+		json.Unmarshal(data, &out)
+		return out
+	}
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+// Workspaces lists the IDs of all workspaces with a stored document. It
+// requires a store that can list keys.
+func (e *Engine) Workspaces() ([]string, error) {
+	lister, ok := e.store.(store.KeyLister)
+	if !ok {
+		return nil, fmt.Errorf("store %T cannot list workspaces", e.store)
+	}
+	keys, err := lister.Keys("ws:")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var ids []string
+	for _, key := range keys {
+		rest := strings.TrimPrefix(key, "ws:")
+		i := strings.LastIndex(rest, ":sync_")
+		if i <= 0 || seen[rest[:i]] {
+			continue
+		}
+		seen[rest[:i]] = true
+		ids = append(ids, rest[:i])
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
@@ -0,0 +1,207 @@
+package crdt_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+func TestMigrateWorkspace_LegacyDocument(t *testing.T) {
+	ms := store.NewMemoryStore()
+	lww := crdt.NewEngine(ms, crdt.WithStrategy(sync.NewLWWStrategy()))
+	lww.ProcessOperation(crdt.Operation{WorkspaceID: "ws-legacy", Key: "title", Value: "kept"})
+	lww.ProcessOperation(crdt.Operation{WorkspaceID: "ws-legacy", Key: "tags", Value: []interface{}{"a", "b"}})
+	// Documents written before strategies were bound have no metadata.
+	ms.Delete("ws:ws-legacy", "sync_doc_meta")
+
+	// Switching the default strategy must not read the LWW bytes as empty.
+	engine := crdt.NewEngine(ms)
+	if _, err := engine.GetFullState("ws-legacy"); !errors.Is(err, crdt.ErrFormatMismatch) {
+		t.Fatalf("Expected ErrFormatMismatch, got %v", err)
+	}
+
+	report, err := engine.MigrateWorkspace("ws-legacy", sync.StrategyAutomerge, true)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if report.From != sync.StrategyLWW || report.Keys != 2 || !report.DryRun {
+		t.Errorf("Unexpected dry-run report: %+v", report)
+	}
+	if _, err := engine.GetFullState("ws-legacy"); !errors.Is(err, crdt.ErrFormatMismatch) {
+		t.Errorf("Expected dry run to leave the document untouched, got %v", err)
+	}
+
+	if _, err := engine.MigrateWorkspace("ws-legacy", sync.StrategyAutomerge, false); err != nil {
+		t.Fatalf("Migration failed: %v", err)
+	}
+	snapshot, err := engine.GetFullState("ws-legacy")
+	if err != nil {
+		t.Fatalf("GetFullState failed after migration: %v", err)
+	}
+	if snapshot.Data["title"] != "kept" || !reflect.DeepEqual(snapshot.Data["tags"], []interface{}{"a", "b"}) {
+		t.Errorf("Expected data to survive migration, got %v", snapshot.Data)
+	}
+	if meta, ok, _ := engine.WorkspaceMeta("ws-legacy"); !ok || meta.Strategy != sync.StrategyAutomerge {
+		t.Errorf("Expected workspace to be rebound to automerge, got %+v", meta)
+	}
+}
+
+func TestMigrateWorkspace_FoldsChangeLog(t *testing.T) {
+	ms := store.NewMemoryStore()
+	engine := crdt.NewEngine(ms)
+	for _, op := range []crdt.Operation{
+		{WorkspaceID: "ws-am", Key: "title", Value: "doc"},
+		{WorkspaceID: "ws-am", Op: sync.OpIncrement, Key: "votes", Delta: 3},
+		{WorkspaceID: "ws-am", Op: sync.OpSet, Path: []interface{}{"board", "name"}, Value: "b"},
+	} {
+		if err := engine.ProcessOperation(op); err != nil {
+			t.Fatalf("ProcessOperation failed: %v", err)
+		}
+	}
+
+	report, err := engine.MigrateWorkspace("ws-am", sync.StrategyServerAuthoritative, false)
+	if err != nil {
+		t.Fatalf("Migration failed: %v", err)
+	}
+	if len(report.Warnings) == 0 {
+		t.Error("Expected warnings about discarded automerge history")
+	}
+
+	all, _ := ms.GetAll("ws:ws-am")
+	for key := range all {
+		if key != "sync_doc" && key != "sync_doc_meta" && key != "sync_log_meta" {
+			t.Errorf("Expected change log to be retired, found %s", key)
+		}
+	}
+
+	// The migrated workspace keeps working with its new strategy after a restart.
+	restarted := crdt.NewEngine(ms)
+	if err := restarted.ProcessOperation(crdt.Operation{WorkspaceID: "ws-am", Op: sync.OpIncrement, Key: "votes", Delta: 1}); err != nil {
+		t.Fatalf("ProcessOperation after migration failed: %v", err)
+	}
+	snapshot, _ := restarted.GetFullState("ws-am")
+	board, _ := snapshot.Data["board"].(map[string]interface{})
+	if snapshot.Data["votes"] != float64(4) || board["name"] != "b" || snapshot.Data["title"] != "doc" {
+		t.Errorf("Unexpected state after migration: %v", snapshot.Data)
+	}
+
+	if ids, err := restarted.Workspaces(); err != nil || !reflect.DeepEqual(ids, []string{"ws-am"}) {
+		t.Errorf("Expected workspaces [ws-am], got %v (err %v)", ids, err)
+	}
+}
+
+func TestMigrateWorkspace_CompletesInterruptedMigration(t *testing.T) {
+	ms := store.NewMemoryStore()
+	engine := crdt.NewEngine(ms)
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-half", Key: "k", Value: "v"})
+	engine.MigrateWorkspace("ws-half", sync.StrategyLWW, false)
+
+	// Simulate a crash after the snapshot was written but before the rebind.
+	ms.Set("ws:ws-half", "sync_doc_meta", []byte(`{"strategy":"automerge"}`))
+	restarted := crdt.NewEngine(ms)
+	if _, err := restarted.GetFullState("ws-half"); !errors.Is(err, crdt.ErrFormatMismatch) {
+		t.Fatalf("Expected ErrFormatMismatch, got %v", err)
+	}
+
+	report, err := restarted.MigrateWorkspace("ws-half", sync.StrategyLWW, false)
+	if err != nil || !report.Unchanged {
+		t.Fatalf("Expected re-run to complete the migration, got %+v (err %v)", report, err)
+	}
+	snapshot, err := restarted.GetFullState("ws-half")
+	if err != nil || snapshot.Data["k"] != "v" {
+		t.Errorf("Expected k=v after completing the migration, got %v (err %v)", snapshot, err)
+	}
+}
+
+func TestMigrateWorkspace_TrustsFormatTag(t *testing.T) {
+	ms := store.NewMemoryStore()
+	engine := crdt.NewEngine(ms, crdt.WithStrategy(sync.NewServerAuthStrategy()))
+	// A server-auth document that looks exactly like an LWW document.
+	entries := map[string]interface{}{"x": map[string]interface{}{"ts": 1}}
+	if err := engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-tagged", Key: "entries", Value: entries}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.GetFullState("ws-tagged"); err != nil {
+		t.Fatalf("Expected the tagged document to open, got %v", err)
+	}
+
+	report, err := engine.MigrateWorkspace("ws-tagged", sync.StrategyAutomerge, false)
+	if err != nil {
+		t.Fatalf("Migration failed: %v", err)
+	}
+	if report.From != sync.StrategyServerAuthoritative || report.Keys != 1 {
+		t.Errorf("Expected a server-auth document with 1 key, got %+v", report)
+	}
+	snapshot, err := engine.GetFullState("ws-tagged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(snapshot.Data["entries"], map[string]interface{}{"x": map[string]interface{}{"ts": float64(1)}}) {
+		t.Errorf("Expected entries to survive as data, got %v", snapshot.Data)
+	}
+}
+
+func TestMigrateWorkspace_CompletesInterruptedJSONMigration(t *testing.T) {
+	ms := store.NewMemoryStore()
+	engine := crdt.NewEngine(ms, crdt.WithStrategy(sync.NewServerAuthStrategy()))
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-json", Key: "k", Value: "v"})
+	engine.MigrateWorkspace("ws-json", sync.StrategyLWW, false)
+
+	// Simulate a crash after the LWW snapshot was written but before the
+	// rebind; both formats are JSON, so only the marker tells them apart.
+	ms.Set("ws:ws-json", "sync_doc_meta", []byte(`{"strategy":"server-auth","migrating_to":"lww"}`))
+	restarted := crdt.NewEngine(ms)
+	if _, err := restarted.GetFullState("ws-json"); !errors.Is(err, crdt.ErrFormatMismatch) {
+		t.Fatalf("Expected ErrFormatMismatch, got %v", err)
+	}
+
+	report, err := restarted.MigrateWorkspace("ws-json", sync.StrategyLWW, false)
+	if err != nil || !report.Unchanged {
+		t.Fatalf("Expected re-run to complete the migration, got %+v (err %v)", report, err)
+	}
+	snapshot, err := restarted.GetFullState("ws-json")
+	if err != nil || snapshot.Data["k"] != "v" {
+		t.Errorf("Expected k=v after completing the migration, got %v (err %v)", snapshot, err)
+	}
+}
+
+func TestMigrateWorkspace_Warnings(t *testing.T) {
+	hasWarning := func(warnings []string, substr string) bool {
+		for _, w := range warnings {
+			if strings.Contains(w, substr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Converting into Automerge loses no merge semantics.
+	lww := crdt.NewEngine(store.NewMemoryStore(), crdt.WithStrategy(sync.NewLWWStrategy()))
+	lww.ProcessOperation(crdt.Operation{WorkspaceID: "ws-lww", Key: "n", Value: 1})
+	report, err := lww.MigrateWorkspace("ws-lww", sync.StrategyAutomerge, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hasWarning(report.Warnings, "without merge semantics") {
+		t.Errorf("Expected no merge semantics warning into automerge, got %v", report.Warnings)
+	}
+
+	// Converting out of Automerge only warns about counters and text it holds.
+	am := crdt.NewEngine(store.NewMemoryStore())
+	am.ProcessOperation(crdt.Operation{WorkspaceID: "ws-plain", Key: "title", Value: "x"})
+	report, _ = am.MigrateWorkspace("ws-plain", sync.StrategyServerAuthoritative, true)
+	if hasWarning(report.Warnings, "without merge semantics") {
+		t.Errorf("Expected no merge semantics warning for plain values, got %v", report.Warnings)
+	}
+
+	am.ProcessOperation(crdt.Operation{WorkspaceID: "ws-rich", Op: sync.OpIncrement, Key: "votes", Delta: 2})
+	report, _ = am.MigrateWorkspace("ws-rich", sync.StrategyServerAuthoritative, true)
+	if !hasWarning(report.Warnings, "1 counters and 0 text objects") {
+		t.Errorf("Expected a warning about the counter, got %v", report.Warnings)
+	}
+}
@@ -4,6 +4,7 @@
 package crdt
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/bneb/etherply/etherply-sync-server/internal/store"
@@ -52,13 +53,13 @@ func TestApplyRemoteChanges_Integration(t *testing.T) {
 	}
 
 	// Get engine1's state as "remote" changes
-	state1, exists, err := memStore1.Get("ws:"+workspaceID, "sync_doc")
-	if err != nil || !exists {
-		t.Fatalf("failed to get engine1 state: %v (exists=%v)", err, exists)
+	state1, err := engine1.GetChanges(workspaceID, nil)
+	if err != nil || len(state1) == 0 {
+		t.Fatalf("failed to get engine1 state: %v (size=%d)", err, len(state1))
 	}
 
 	// Engine 2 applies the remote changes
-	err = engine2.ApplyRemoteChanges(workspaceID, state1.([]byte))
+	err = engine2.ApplyRemoteChanges(workspaceID, state1)
 	if err != nil {
 		t.Fatalf("engine2 failed to apply remote changes: %v", err)
 	}
@@ -104,20 +105,20 @@ func TestApplyRemoteChanges_BidirectionalMerge(t *testing.T) {
 	}
 
 	// Cross-merge
-	state1, exists1, err := memStore1.Get("ws:"+workspaceID, "sync_doc")
-	if err != nil || !exists1 {
+	state1, err := engine1.GetChanges(workspaceID, nil)
+	if err != nil || len(state1) == 0 {
 		t.Fatalf("failed to get engine1 state: %v", err)
 	}
 
-	state2, exists2, err := memStore2.Get("ws:"+workspaceID, "sync_doc")
-	if err != nil || !exists2 {
+	state2, err := engine2.GetChanges(workspaceID, nil)
+	if err != nil || len(state2) == 0 {
 		t.Fatalf("failed to get engine2 state: %v", err)
 	}
 
-	if err := engine1.ApplyRemoteChanges(workspaceID, state2.([]byte)); err != nil {
+	if err := engine1.ApplyRemoteChanges(workspaceID, state2); err != nil {
 		t.Fatalf("engine1 merge failed: %v", err)
 	}
-	if err := engine2.ApplyRemoteChanges(workspaceID, state1.([]byte)); err != nil {
+	if err := engine2.ApplyRemoteChanges(workspaceID, state1); err != nil {
 		t.Fatalf("engine2 merge failed: %v", err)
 	}
 
@@ -138,3 +139,29 @@ func TestApplyRemoteChanges_BidirectionalMerge(t *testing.T) {
 		t.Errorf("engine2 missing field_b")
 	}
 }
+
+// TestApplyRemoteChanges_SchemaViolation verifies that replicated changes are
+// validated like local ones.
+func TestApplyRemoteChanges_SchemaViolation(t *testing.T) {
+	engine1 := NewEngine(store.NewMemoryStore())
+	memStore2 := store.NewMemoryStore()
+	engine2 := NewEngine(memStore2)
+	workspaceID := "repl-schema-ws"
+
+	if err := engine2.SetSchema(workspaceID, []byte(`{"type":"object","properties":{"title":{"type":"string"}}}`)); err != nil {
+		t.Fatal(err)
+	}
+	engine1.ProcessOperation(Operation{WorkspaceID: workspaceID, Key: "title", Value: 42})
+	changes, _ := engine1.GetChanges(workspaceID, nil)
+
+	err := engine2.ApplyRemoteChanges(workspaceID, changes)
+	if !errors.Is(err, ErrSchemaViolation) {
+		t.Fatalf("expected ErrSchemaViolation, got %v", err)
+	}
+	for _, e := range []*Engine{engine2, NewEngine(memStore2)} {
+		snapshot, _ := e.GetFullState(workspaceID)
+		if _, exists := snapshot.Data["title"]; exists {
+			t.Errorf("expected invalid change to be dropped, got %v", snapshot.Data)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package crdt
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	gosync "sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// schemaKey is the reserved storage key for a workspace's JSON Schema.
+const schemaKey = "sync_schema"
+
+var (
+	// ErrInvalidSchema is returned when a schema document does not compile.
+	ErrInvalidSchema = errors.New("invalid schema")
+
+	// ErrSchemaViolation is wrapped by SchemaError.
+	ErrSchemaViolation = errors.New("schema violation")
+)
+
+// SchemaViolation is one reason a document failed validation.
+type SchemaViolation struct {
+	Path    string `json:"path"` // JSON pointer into the document, "" for the root
+	Message string `json:"message"`
+}
+
+// SchemaError is returned by ProcessOperation when the document resulting
+// from an operation does not satisfy the workspace's JSON Schema.
+type SchemaError struct {
+	WorkspaceID string
+	Violations  []SchemaViolation
+}
+
+func (e *SchemaError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Path + ": " + v.Message
+		if v.Path == "" {
+			msgs[i] = v.Message
+		}
+	}
+	return fmt.Sprintf("%s: %s", ErrSchemaViolation, strings.Join(msgs, "; "))
+}
+
+func (e *SchemaError) Unwrap() error { return ErrSchemaViolation }
+
+// schemaCacheSize bounds how many compiled schemas are kept in memory.
+const schemaCacheSize = 256
+
+// schemaCache is an LRU of compiled schemas keyed by the hash of their raw
+// document. Workspaces sharing a schema share its compiled form, and a
+// changed schema gets a new key, so entries never go stale.
+type schemaCache struct {
+	mu       gosync.Mutex
+	capacity int
+	order    *list.List // front = most recently used
+	entries  map[string]*list.Element
+}
+
+type schemaCacheEntry struct {
+	hash   string
+	schema *jsonschema.Schema
+}
+
+func newSchemaCache(capacity int) *schemaCache {
+	return &schemaCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *schemaCache) get(hash string) (*jsonschema.Schema, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[hash]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*schemaCacheEntry).schema, true
+}
+
+func (c *schemaCache) put(hash string, sch *jsonschema.Schema) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[hash]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[hash] = c.order.PushFront(&schemaCacheEntry{hash: hash, schema: sch})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*schemaCacheEntry).hash)
+	}
+}
+
+// schemaHash identifies a raw schema document; "" means no schema.
+func schemaHash(raw []byte) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// compileSchema parses and compiles a schema document. $refs are resolved
+// only within the document; the loader has no schemes registered, so
+// nothing is fetched from files or the network.
+func compileSchema(raw []byte) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	c := jsonschema.NewCompiler()
+	c.UseLoader(jsonschema.SchemeURLLoader{})
+	if err := c.AddResource("workspace.json", doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	sch, err := c.Compile("workspace.json")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	return sch, nil
+}
+
+// SetSchema registers the JSON Schema that every document state of
+// workspaceID must satisfy after an operation. An empty schema removes it.
+// The current document is not checked; the schema applies to later writes.
+//
+// The schema is read together with the document, so other engines sharing
+// the store apply it once they (re)load the document.
+func (e *Engine) SetSchema(workspaceID string, raw []byte) error {
+	if workspaceID == "" {
+		return fmt.Errorf("workspace_id is required")
+	}
+
+	var sch *jsonschema.Schema
+	if len(bytes.TrimSpace(raw)) > 0 {
+		var err error
+		if sch, err = compileSchema(raw); err != nil {
+			return err
+		}
+		raw = append([]byte(nil), raw...)
+	} else {
+		raw = nil
+	}
+
+	unlock := e.locks.lock(workspaceID)
+	defer unlock()
+
+	var err error
+	if sch == nil {
+		err = e.store.Delete("ws:"+workspaceID, schemaKey)
+	} else {
+		err = e.store.Set("ws:"+workspaceID, schemaKey, raw)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store schema: %w", err)
+	}
+
+	hash := schemaHash(raw)
+	if sch != nil {
+		e.schemas.put(hash, sch)
+	}
+	if entry, ok := e.cache.get(workspaceID); ok {
+		entry.schemaHash = hash
+	}
+
+	e.logger.Info("workspace_schema_updated",
+		slog.String("workspace_id", workspaceID),
+		slog.Bool("removed", sch == nil),
+	)
+	return nil
+}
+
+// Schema returns the raw JSON Schema registered for workspaceID.
+func (e *Engine) Schema(workspaceID string) ([]byte, bool, error) {
+	val, exists, err := e.store.Get("ws:"+workspaceID, schemaKey)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	raw, ok := val.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("unexpected schema type %T", val)
+	}
+	return raw, true, nil
+}
+
+// schemaFor returns the compiled schema of entry, or nil if it has none.
+// The caller must hold the workspace lock.
+func (e *Engine) schemaFor(entry *cachedDoc) (*jsonschema.Schema, error) {
+	if entry.schemaHash == "" {
+		return nil, nil
+	}
+	if sch, ok := e.schemas.get(entry.schemaHash); ok {
+		return sch, nil
+	}
+
+	// Compiled schemas are evicted independently of documents.
+	raw, _, err := e.Schema(entry.workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	entry.schemaHash = schemaHash(raw)
+	if entry.schemaHash == "" {
+		return nil, nil
+	}
+	sch, err := compileSchema(raw)
+	if err != nil {
+		return nil, err
+	}
+	e.schemas.put(entry.schemaHash, sch)
+	return sch, nil
+}
+
+// validateState checks the document state against the workspace schema.
+// It returns a *SchemaError listing every violation.
+func validateState(workspaceID string, sch *jsonschema.Schema, state map[string]interface{}) error {
+	// Round-trip through JSON so that strategy-specific Go types (int64
+	// counters, typed slices) are seen as plain JSON values.
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	err = sch.Validate(inst)
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return err
+	}
+
+	schemaErr := &SchemaError{WorkspaceID: workspaceID}
+	for _, unit := range verr.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		schemaErr.Violations = append(schemaErr.Violations, SchemaViolation{
+			Path:    unit.InstanceLocation,
+			Message: unit.Error.String(),
+		})
+	}
+	if len(schemaErr.Violations) == 0 {
+		schemaErr.Violations = []SchemaViolation{{Message: verr.Error()}}
+	}
+	sort.SliceStable(schemaErr.Violations, func(i, j int) bool {
+		return schemaErr.Violations[i].Path < schemaErr.Violations[j].Path
+	})
+	return schemaErr
+}
@@ -0,0 +1,140 @@
+package crdt_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+const kanbanSchema = `{
+	"type": "object",
+	"properties": {
+		"columns": {
+			"type": "array",
+			"items": {"type": "string"},
+			"maxItems": 3
+		},
+		"votes": {"type": "integer", "minimum": 0}
+	}
+}`
+
+func TestEngine_SchemaValidation(t *testing.T) {
+	ms := store.NewMemoryStore()
+	engine := crdt.NewEngine(ms)
+
+	if err := engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-kanban", Key: "columns", Value: "anything"}); err != nil {
+		t.Fatalf("Expected writes without a schema to succeed, got %v", err)
+	}
+	if err := engine.SetSchema("ws-kanban", []byte(kanbanSchema)); err != nil {
+		t.Fatalf("SetSchema failed: %v", err)
+	}
+
+	valid := []crdt.Operation{
+		{Op: sync.OpSet, Key: "columns", Value: []interface{}{}},
+		{Op: sync.OpIncrement, Key: "votes", Delta: 2},
+		{Op: sync.OpInsert, Path: []interface{}{"columns", 0}, Value: "todo"},
+	}
+	for _, op := range valid {
+		op.WorkspaceID = "ws-kanban"
+		if err := engine.ProcessOperation(op); err != nil {
+			t.Fatalf("Expected %s to be valid, got %v", op, err)
+		}
+	}
+
+	invalid := []crdt.Operation{
+		{Op: sync.OpIncrement, Key: "votes", Delta: -5},
+		{Op: sync.OpInsert, Path: []interface{}{"columns", 1}, Value: 42},
+		{Op: sync.OpSet, Key: "columns", Value: []interface{}{"a", "b", "c", "d"}},
+	}
+	for _, op := range invalid {
+		op.WorkspaceID = "ws-kanban"
+		err := engine.ProcessOperation(op)
+		var schemaErr *crdt.SchemaError
+		if !errors.As(err, &schemaErr) || !errors.Is(err, crdt.ErrSchemaViolation) {
+			t.Fatalf("Expected SchemaError for %s, got %v", op, err)
+		}
+		if len(schemaErr.Violations) == 0 || schemaErr.Violations[0].Path == "" {
+			t.Errorf("Expected violations with a path for %s, got %+v", op, schemaErr.Violations)
+		}
+	}
+
+	// Rejected ops never reach the document, in memory or in the store.
+	for _, e := range []*crdt.Engine{engine, crdt.NewEngine(ms)} {
+		snapshot, _ := e.GetFullState("ws-kanban")
+		columns, _ := snapshot.Data["columns"].([]interface{})
+		if votes, _ := snapshot.Data["votes"].(int64); votes != 2 || len(columns) != 1 {
+			t.Errorf("Expected votes=2 and one column, got %v", snapshot.Data)
+		}
+	}
+
+	// Removing the schema lifts validation.
+	if err := engine.SetSchema("ws-kanban", nil); err != nil {
+		t.Fatalf("SetSchema(nil) failed: %v", err)
+	}
+	if err := engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-kanban", Key: "votes", Value: -1}); err != nil {
+		t.Errorf("Expected write to succeed without schema, got %v", err)
+	}
+}
+
+func TestEngine_SetSchema_Invalid(t *testing.T) {
+	engine := crdt.NewEngine(store.NewMemoryStore())
+	for _, raw := range []string{`{"type": 12}`, `not json`, `{"$ref": "https://example.com/schema.json"}`} {
+		if err := engine.SetSchema("ws", []byte(raw)); !errors.Is(err, crdt.ErrInvalidSchema) {
+			t.Errorf("Expected ErrInvalidSchema for %s, got %v", raw, err)
+		}
+	}
+	if _, exists, _ := engine.Schema("ws"); exists {
+		t.Error("Expected invalid schemas not to be stored")
+	}
+}
+
+func TestEngine_SetSchema_NoFileRefs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shadow.json")
+	if err := os.WriteFile(path, []byte("top-secret-contents"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := crdt.NewEngine(store.NewMemoryStore())
+	err := engine.SetSchema("ws", []byte(`{"$ref": "file://`+filepath.ToSlash(path)+`"}`))
+	if !errors.Is(err, crdt.ErrInvalidSchema) {
+		t.Fatalf("Expected ErrInvalidSchema for a file $ref, got %v", err)
+	}
+	if strings.Contains(err.Error(), "top-secret") || strings.Contains(err.Error(), "invalid character") {
+		t.Errorf("Expected the error not to reflect the file contents, got %v", err)
+	}
+}
+
+func TestEngine_SchemaSharedStore(t *testing.T) {
+	ms := store.NewMemoryStore()
+	admin := crdt.NewEngine(ms)
+	// A second engine on the same store that keeps one document in memory.
+	other := crdt.NewEngine(ms, crdt.WithCacheSize(1))
+	votes := crdt.Operation{WorkspaceID: "ws-shared", Key: "votes", Value: -1}
+
+	if err := other.ProcessOperation(votes); err != nil {
+		t.Fatalf("Expected write without a schema to succeed, got %v", err)
+	}
+	if err := admin.SetSchema("ws-shared", []byte(kanbanSchema)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Loading another document evicts ws-shared; its reload reads the schema.
+	other.GetFullState("ws-elsewhere")
+	if err := other.ProcessOperation(votes); !errors.Is(err, crdt.ErrSchemaViolation) {
+		t.Fatalf("Expected the schema set by another engine to apply, got %v", err)
+	}
+
+	if err := admin.SetSchema("ws-shared", nil); err != nil {
+		t.Fatal(err)
+	}
+	other.GetFullState("ws-elsewhere")
+	if err := other.ProcessOperation(votes); err != nil {
+		t.Errorf("Expected the schema removed by another engine to be lifted, got %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package crdt
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+// ErrSyncUnsupported is returned when the workspace document cannot speak the
+// binary Automerge sync protocol (e.g. LWW or server-auth documents).
+var ErrSyncUnsupported = errors.New("binary sync is not supported by this strategy")
+
+// SyncSession is the server side of one binary Automerge sync connection.
+//
+// The per-connection sync state records what the peer already has, so that
+// only missing changes are sent. It is bound to a private replica of the
+// workspace document rather than to the cached one: the live document may be
+// evicted and reloaded at any time, which would otherwise reset the state.
+// The replica is refreshed from the live document before every step and its
+// new changes are merged back after receiving.
+//
+// Methods take the workspace lock, which also serializes access to the session.
+type SyncSession struct {
+	engine      *Engine
+	workspaceID string
+	replica     sync.SyncDocument
+	peer        sync.SyncPeer
+}
+
+// NewSyncSession starts a sync session for workspaceID.
+func (e *Engine) NewSyncSession(workspaceID string) (*SyncSession, error) {
+	if workspaceID == "" {
+		return nil, fmt.Errorf("%w: workspace_id is missing", ErrInvalidOperation)
+	}
+
+	unlock := e.locks.lock(workspaceID)
+	defer unlock()
+
+	_, live, err := e.openSyncDoc(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	replica, err := live.Fork()
+	if err != nil {
+		return nil, err
+	}
+	return &SyncSession{
+		engine:      e,
+		workspaceID: workspaceID,
+		replica:     replica,
+		peer:        replica.NewSyncPeer(),
+	}, nil
+}
+
+// Receive applies a sync message from the peer and reports whether the
+// document changed, in which case other connections should be notified.
+// Changes that would violate the workspace schema are discarded and reported
+// as a *SchemaError; the session then starts over from the live document.
+func (s *SyncSession) Receive(msg []byte) (bool, error) {
+	e := s.engine
+	unlock := e.locks.lock(s.workspaceID)
+	defer unlock()
+
+	entry, live, err := s.refresh()
+	if err != nil {
+		return false, err
+	}
+	if err := s.peer.Receive(msg); err != nil {
+		return false, err
+	}
+
+	before, err := live.Heads()
+	if err != nil {
+		return false, err
+	}
+	after, err := s.replica.Heads()
+	if err != nil {
+		return false, err
+	}
+	if sameHeads(before, after) {
+		return false, nil
+	}
+
+	// The replica now holds the live document plus the peer's changes, i.e.
+	// the merged state. Reject it before anything reaches the live document.
+	if err := e.checkDocSchema(entry, s.replica); err != nil {
+		if resetErr := s.reset(live); resetErr != nil {
+			return false, resetErr
+		}
+		return false, err
+	}
+
+	if err := live.MergeFrom(s.replica); err != nil {
+		e.cache.remove(s.workspaceID)
+		return false, err
+	}
+	if err := e.persist(entry); err != nil {
+		e.cache.remove(s.workspaceID)
+		return false, fmt.Errorf("failed to persist synced changes: %w", err)
+	}
+	e.replicate(entry)
+
+	e.logger.Debug("sync_changes_received",
+		slog.String("workspace_id", s.workspaceID),
+		slog.Int("heads", len(after)),
+	)
+	return true, nil
+}
+
+// Generate returns the next message for the peer, or false if it is up to date.
+func (s *SyncSession) Generate() ([]byte, bool, error) {
+	unlock := s.engine.locks.lock(s.workspaceID)
+	defer unlock()
+
+	if _, _, err := s.refresh(); err != nil {
+		return nil, false, err
+	}
+	msg, ok := s.peer.Generate()
+	return msg, ok, nil
+}
+
+// reset replaces the replica with a fresh fork of live, dropping changes
+// received from the peer, and restarts the sync state so that the peer learns
+// what the server really has. The caller must hold the workspace lock.
+func (s *SyncSession) reset(live sync.SyncDocument) error {
+	replica, err := live.Fork()
+	if err != nil {
+		return err
+	}
+	s.replica = replica
+	s.peer = replica.NewSyncPeer()
+	return nil
+}
+
+// refresh brings the replica up to date with the live document.
+// The caller must hold the workspace lock.
+func (s *SyncSession) refresh() (*cachedDoc, sync.SyncDocument, error) {
+	entry, live, err := s.engine.openSyncDoc(s.workspaceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.replica.MergeFrom(live); err != nil {
+		return nil, nil, err
+	}
+	return entry, live, nil
+}
+
+// openSyncDoc opens the live document of a workspace that supports binary sync.
+func (e *Engine) openSyncDoc(workspaceID string) (*cachedDoc, sync.SyncDocument, error) {
+	entry, err := e.openDoc(workspaceID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load state: %w", err)
+	}
+	live, ok := entry.doc.(sync.SyncDocument)
+	if !ok {
+		return nil, nil, ErrSyncUnsupported
+	}
+	return entry, live, nil
+}
@@ -0,0 +1,88 @@
+package crdt
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/metrics"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+// ErrTimestampOutOfRange is returned when a client timestamp is rejected by
+// the engine's TimestampPolicy.
+var ErrTimestampOutOfRange = errors.New("timestamp out of range")
+
+// TimestampAction is what the engine does with a client timestamp outside the
+// allowed window.
+type TimestampAction string
+
+const (
+	// TimestampReject fails the operation with ErrTimestampOutOfRange.
+	TimestampReject TimestampAction = "reject"
+
+	// TimestampClamp moves the timestamp to the nearest edge of the window.
+	TimestampClamp TimestampAction = "clamp"
+
+	// TimestampIgnore discards the timestamp and stamps the op with server time.
+	TimestampIgnore TimestampAction = "ignore"
+)
+
+// DefaultMaxClockSkew is how far ahead of server time a client timestamp may be.
+const DefaultMaxClockSkew = time.Minute
+
+// TimestampPolicy bounds client-supplied timestamps. A client with a clock in
+// the future would otherwise win every LWW conflict and drag the server's
+// HLC along with it.
+//
+// Timestamps in the past are only bounded when MaxAge is set: offline clients
+// legitimately send old timestamps, and an old timestamp can only lose.
+type TimestampPolicy struct {
+	Action  TimestampAction
+	MaxSkew time.Duration // allowed distance ahead of server time
+	MaxAge  time.Duration // allowed distance behind server time; 0 = unlimited
+}
+
+// DefaultTimestampPolicy clamps timestamps more than DefaultMaxClockSkew ahead.
+func DefaultTimestampPolicy() TimestampPolicy {
+	return TimestampPolicy{Action: TimestampClamp, MaxSkew: DefaultMaxClockSkew}
+}
+
+// checkTimestamp applies the policy to a client stamp. ok is false if the
+// stamp must be replaced with a server timestamp.
+func (e *Engine) checkTimestamp(workspaceID string, stamp sync.HLC) (sync.HLC, bool, error) {
+	now := time.Now()
+	latest := now.Add(e.timestampPolicy.MaxSkew).UnixMicro()
+	earliest := int64(0)
+	if e.timestampPolicy.MaxAge > 0 {
+		earliest = now.Add(-e.timestampPolicy.MaxAge).UnixMicro()
+	}
+	if stamp.Physical <= latest && stamp.Physical >= earliest {
+		return stamp, true, nil
+	}
+
+	action := e.timestampPolicy.Action
+	metrics.TimestampsOutOfRange.WithLabelValues(string(action)).Inc()
+	e.logger.Warn("op_timestamp_out_of_range",
+		slog.String("workspace_id", workspaceID),
+		slog.String("hlc", stamp.String()),
+		slog.Duration("offset", stamp.Time().Sub(now)),
+		slog.String("action", string(action)),
+	)
+
+	switch action {
+	case TimestampReject:
+		return stamp, false, fmt.Errorf("%w: %s is %s from server time",
+			ErrTimestampOutOfRange, stamp.Time().UTC().Format(time.RFC3339Nano), stamp.Time().Sub(now).Round(time.Millisecond))
+	case TimestampIgnore:
+		return stamp, false, nil
+	default:
+		if stamp.Physical > latest {
+			stamp.Physical = latest
+		} else {
+			stamp.Physical = earliest
+		}
+		return stamp, true, nil
+	}
+}
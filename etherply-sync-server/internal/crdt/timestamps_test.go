@@ -0,0 +1,74 @@
+package crdt_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+func TestEngine_TimestampPolicy(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour).UnixMicro()
+	ancient := time.Now().Add(-24 * time.Hour).UnixMicro()
+
+	newEngine := func(action crdt.TimestampAction) *crdt.Engine {
+		return crdt.NewEngine(store.NewMemoryStore(),
+			crdt.WithStrategy(sync.NewLWWStrategy()),
+			crdt.WithTimestampPolicy(crdt.TimestampPolicy{Action: action, MaxSkew: time.Minute, MaxAge: time.Hour}),
+		)
+	}
+
+	// reject: the op fails and the document is untouched.
+	engine := newEngine(crdt.TimestampReject)
+	err := engine.ProcessOperation(decodeOp(t, fmt.Sprintf(`{"key":"k","value":"v","hlc":"%d:0:c"}`, future)))
+	if !errors.Is(err, crdt.ErrTimestampOutOfRange) {
+		t.Fatalf("Expected ErrTimestampOutOfRange, got %v", err)
+	}
+	err = engine.ProcessOperation(decodeOp(t, fmt.Sprintf(`{"key":"k","value":"v","timestamp":%d}`, ancient)))
+	if !errors.Is(err, crdt.ErrTimestampOutOfRange) {
+		t.Fatalf("Expected ErrTimestampOutOfRange for old timestamp, got %v", err)
+	}
+	if snapshot, _ := engine.GetFullState("ws-ops"); len(snapshot.Data) != 0 {
+		t.Errorf("Expected rejected ops to leave the document empty, got %v", snapshot.Data)
+	}
+
+	// clamp and ignore: a client clock a day ahead no longer beats a later
+	// server-stamped write.
+	for _, action := range []crdt.TimestampAction{crdt.TimestampClamp, crdt.TimestampIgnore} {
+		engine := newEngine(action)
+		ops := []string{
+			fmt.Sprintf(`{"key":"k","value":"from-future","hlc":"%d:0:c"}`, future),
+			`{"key":"k","value":"server"}`,
+		}
+		if err := engine.ProcessOperation(decodeOp(t, ops[0])); err != nil {
+			t.Fatalf("%s: ProcessOperation failed: %v", action, err)
+		}
+		snapshot, _ := engine.GetFullState("ws-ops")
+		heads, _ := sync.ParseHLC(snapshot.Heads[0])
+		if limit := time.Now().Add(time.Minute).UnixMicro(); heads.Physical > limit {
+			t.Errorf("%s: expected stamp within the skew window, got %v", action, heads)
+		}
+		if err := engine.ProcessOperation(decodeOp(t, ops[1])); err != nil {
+			t.Fatalf("%s: ProcessOperation failed: %v", action, err)
+		}
+		snapshot, _ = engine.GetFullState("ws-ops")
+		if snapshot.Data["k"] != "server" {
+			t.Errorf("%s: expected server write to win, got %v", action, snapshot.Data["k"])
+		}
+	}
+
+	// Timestamps inside the window pass through unchanged.
+	engine = newEngine(crdt.TimestampReject)
+	recent := time.Now().Add(-time.Minute).UnixMicro()
+	if err := engine.ProcessOperation(decodeOp(t, fmt.Sprintf(`{"key":"k","value":"v","hlc":"%d:3:c"}`, recent))); err != nil {
+		t.Fatalf("ProcessOperation failed: %v", err)
+	}
+	snapshot, _ := engine.GetFullState("ws-ops")
+	if want := fmt.Sprintf("%d:3:c", recent); snapshot.Heads[0] != want {
+		t.Errorf("Expected heads %s, got %v", want, snapshot.Heads)
+	}
+}
@@ -0,0 +1,147 @@
+package crdt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+// docMetaKey is the reserved storage key for document metadata.
+const docMetaKey = "sync_doc_meta"
+
+var (
+	// ErrUnknownStrategy is returned for strategy names the engine cannot open.
+	ErrUnknownStrategy = errors.New("unknown sync strategy")
+
+	// ErrStrategyMismatch is returned when a workspace is already bound to a
+	// different strategy than the one requested.
+	ErrStrategyMismatch = errors.New("workspace is bound to a different strategy")
+
+	// ErrFormatMismatch is returned when a stored document is not in the
+	// format of the strategy it would be opened with. MigrateWorkspace
+	// converts it.
+	ErrFormatMismatch = errors.New("document format does not match strategy")
+)
+
+// DocumentMeta is persisted next to each document. Strategy doubles as the
+// format tag of the stored bytes: it is bound when the workspace is created,
+// or on its first write, and only changes through MigrateWorkspace.
+type DocumentMeta struct {
+	Strategy  sync.StrategyType `json:"strategy"`
+	CreatedAt time.Time         `json:"created_at"`
+	// MigratingTo is set while MigrateWorkspace replaces the snapshot; the
+	// snapshot is then in either format until the migration completes.
+	MigratingTo sync.StrategyType `json:"migrating_to,omitempty"`
+}
+
+// strategyFor returns the strategy implementation for t.
+func (e *Engine) strategyFor(t sync.StrategyType) (sync.SyncStrategy, error) {
+	if string(t) == e.strategy.Name() {
+		return e.strategy, nil
+	}
+	switch t {
+	case sync.StrategyAutomerge, sync.StrategyLWW, sync.StrategyServerAuthoritative:
+		return sync.NewStrategy(t), nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownStrategy, t)
+}
+
+// loadDocMeta reads the document metadata. ok is false for workspaces that
+// were never written, or were written before strategies were bound.
+func (e *Engine) loadDocMeta(workspaceID string) (meta DocumentMeta, ok bool, err error) {
+	val, exists, err := e.store.Get("ws:"+workspaceID, docMetaKey)
+	if err != nil || !exists {
+		return meta, false, err
+	}
+	data, isBytes := val.([]byte)
+	if !isBytes {
+		return meta, false, fmt.Errorf("unexpected document metadata type %T", val)
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, false, fmt.Errorf("failed to decode document metadata: %w", err)
+	}
+	return meta, true, nil
+}
+
+// saveDocMeta persists the document metadata.
+func (e *Engine) saveDocMeta(workspaceID string, meta DocumentMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return e.store.Set("ws:"+workspaceID, docMetaKey, data)
+}
+
+// hasStoredDoc reports whether any snapshot or change log exists for workspaceID.
+func (e *Engine) hasStoredDoc(workspaceID string) (bool, error) {
+	stored, err := e.loadStored(workspaceID)
+	if err != nil {
+		return false, err
+	}
+	return len(stored.data) > 0, nil
+}
+
+// CreateWorkspace binds workspaceID to a strategy. It is idempotent for the
+// same strategy and fails with ErrStrategyMismatch if the workspace is already
+// bound to another one. Documents written before strategies were bound are
+// in the engine's default format and can only be bound to it.
+func (e *Engine) CreateWorkspace(workspaceID string, strategy sync.StrategyType) (*DocumentMeta, error) {
+	if workspaceID == "" {
+		return nil, fmt.Errorf("workspace_id is required")
+	}
+	if _, err := e.strategyFor(strategy); err != nil {
+		return nil, err
+	}
+
+	unlock := e.locks.lock(workspaceID)
+	defer unlock()
+
+	meta, bound, err := e.loadDocMeta(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	if bound {
+		if meta.Strategy != strategy {
+			return &meta, fmt.Errorf("%w: %s is %s", ErrStrategyMismatch, workspaceID, meta.Strategy)
+		}
+		return &meta, nil
+	}
+
+	exists, err := e.hasStoredDoc(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	if exists && string(strategy) != e.strategy.Name() {
+		return nil, fmt.Errorf("%w: %s already holds %s data", ErrStrategyMismatch, workspaceID, e.strategy.Name())
+	}
+
+	meta = DocumentMeta{Strategy: strategy, CreatedAt: time.Now().UTC()}
+	if err := e.saveDocMeta(workspaceID, meta); err != nil {
+		return nil, fmt.Errorf("failed to save document metadata: %w", err)
+	}
+	// A resident document was opened with the default strategy; reopen it bound.
+	e.cache.remove(workspaceID)
+
+	e.logger.Info("workspace_created",
+		slog.String("workspace_id", workspaceID),
+		slog.String("strategy", string(strategy)),
+	)
+	return &meta, nil
+}
+
+// WorkspaceMeta returns the metadata of workspaceID. ok is false if the
+// workspace is not bound yet; its first write binds it to the default strategy.
+func (e *Engine) WorkspaceMeta(workspaceID string) (meta *DocumentMeta, ok bool, err error) {
+	unlock := e.locks.lock(workspaceID)
+	defer unlock()
+
+	m, ok, err := e.loadDocMeta(workspaceID)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return &m, true, nil
+}
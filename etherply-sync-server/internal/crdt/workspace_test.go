@@ -0,0 +1,87 @@
+package crdt_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+func TestEngine_PerWorkspaceStrategy(t *testing.T) {
+	ms := store.NewMemoryStore()
+	defer ms.Close()
+	engine := crdt.NewEngine(ms)
+
+	// A resident document opened with the default strategy is reopened bound.
+	engine.GetFullState("ws-settings")
+	if _, err := engine.CreateWorkspace("ws-settings", sync.StrategyLWW); err != nil {
+		t.Fatalf("CreateWorkspace failed: %v", err)
+	}
+	if _, err := engine.CreateWorkspace("ws-game", sync.StrategyServerAuthoritative); err != nil {
+		t.Fatalf("CreateWorkspace failed: %v", err)
+	}
+
+	incr := crdt.Operation{Op: sync.OpIncrement, Key: "score", Delta: 2}
+	for _, ws := range []string{"ws-doc", "ws-game"} {
+		incr.WorkspaceID = ws
+		if err := engine.ProcessOperation(incr); err != nil {
+			t.Fatalf("%s: increment failed: %v", ws, err)
+		}
+	}
+	incr.WorkspaceID = "ws-settings"
+	if err := engine.ProcessOperation(incr); !errors.Is(err, sync.ErrUnsupportedOp) {
+		t.Errorf("Expected LWW workspace to reject increment, got %v", err)
+	}
+
+	// Restart with a different default: bound documents keep their strategy,
+	// including the one bound implicitly by its first write.
+	restarted := crdt.NewEngine(ms, crdt.WithStrategy(sync.NewLWWStrategy()))
+	doc, _ := restarted.GetFullState("ws-doc")
+	if score, _ := doc.Data["score"].(int64); score != 2 {
+		t.Errorf("Expected automerge counter 2 in ws-doc, got %v (%T)", doc.Data["score"], doc.Data["score"])
+	}
+	game, _ := restarted.GetFullState("ws-game")
+	if game.Data["score"] != float64(2) {
+		t.Errorf("Expected server-auth number 2 in ws-game, got %v (%T)", game.Data["score"], game.Data["score"])
+	}
+
+	for ws, want := range map[string]sync.StrategyType{
+		"ws-doc":      sync.StrategyAutomerge,
+		"ws-game":     sync.StrategyServerAuthoritative,
+		"ws-settings": sync.StrategyLWW,
+	} {
+		meta, ok, err := restarted.WorkspaceMeta(ws)
+		if err != nil || !ok || meta.Strategy != want {
+			t.Errorf("%s: expected strategy %s, got %+v (ok=%v, err=%v)", ws, want, meta, ok, err)
+		}
+	}
+	if _, ok, _ := restarted.WorkspaceMeta("ws-unknown"); ok {
+		t.Error("Expected never-written workspace to be unbound")
+	}
+}
+
+func TestEngine_CreateWorkspace_Conflicts(t *testing.T) {
+	engine := crdt.NewEngine(store.NewMemoryStore())
+
+	if _, err := engine.CreateWorkspace("ws-a", sync.StrategyLWW); err != nil {
+		t.Fatalf("CreateWorkspace failed: %v", err)
+	}
+	if _, err := engine.CreateWorkspace("ws-a", sync.StrategyLWW); err != nil {
+		t.Errorf("Expected re-creating with the same strategy to succeed, got %v", err)
+	}
+	if _, err := engine.CreateWorkspace("ws-a", sync.StrategyAutomerge); !errors.Is(err, crdt.ErrStrategyMismatch) {
+		t.Errorf("Expected ErrStrategyMismatch, got %v", err)
+	}
+
+	// Existing data pins the workspace to the format it was written in.
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-b", Key: "k", Value: "v"})
+	if _, err := engine.CreateWorkspace("ws-b", sync.StrategyLWW); !errors.Is(err, crdt.ErrStrategyMismatch) {
+		t.Errorf("Expected ErrStrategyMismatch for written workspace, got %v", err)
+	}
+
+	if _, err := engine.CreateWorkspace("ws-c", "crdt-of-the-month"); !errors.Is(err, crdt.ErrUnknownStrategy) {
+		t.Errorf("Expected ErrUnknownStrategy, got %v", err)
+	}
+}
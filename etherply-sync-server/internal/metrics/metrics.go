@@ -37,6 +37,27 @@ var (
 		Buckets: prometheus.DefBuckets,
 	})
 
+	// TimestampsOutOfRange counts client op timestamps outside the allowed
+	// clock skew, by the action taken (reject, clamp, ignore).
+	TimestampsOutOfRange = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "etherply_op_timestamps_out_of_range_total",
+		Help: "The total number of operations whose client timestamp was outside the allowed clock skew",
+	}, []string{"action"})
+
+	// PubSubDropped counts messages not delivered to slow subscribers, by
+	// workspace and overflow policy.
+	PubSubDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "etherply_pubsub_messages_dropped_total",
+		Help: "The total number of broadcast messages dropped for slow subscribers",
+	}, []string{"workspace_id", "policy"})
+
+	// PubSubSpilled counts messages queued beyond a subscriber's buffer under
+	// the spill overflow policy, by workspace.
+	PubSubSpilled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "etherply_pubsub_messages_spilled_total",
+		Help: "The total number of broadcast messages spilled past a subscriber's buffer",
+	}, []string{"workspace_id"})
+
 	// SyncLatency tracks the time taken to process sync messages (generic).
 	SyncLatency = promauto.NewHistogram(prometheus.HistogramOpts{
 		Name:    "etherply_sync_latency_seconds",
@@ -3,12 +3,18 @@
 // and their current status (online, idle, etc.). This data is not persisted
 // and is rebuilt as connections are established and terminated.
 //
+// Presence is tracked per session (one connection: a tab, a device) and
+// aggregated per user, so a user stays present while any session is connected.
+//
 // The Manager is thread-safe and can be accessed concurrently from multiple
 // WebSocket handler goroutines.
 package presence
 
 import (
+	"encoding/json"
+	"sort"
 	"sync"
+	"time"
 )
 
 
@@ -19,50 +25,247 @@ const (
 	StatusUnknown = "unknown"
 )
 
+// statusRank orders statuses for aggregation: a user is as present as their
+// most present session.
+var statusRank = map[string]int{
+	StatusOnline:  3,
+	StatusIdle:    2,
+	StatusUnknown: 1,
+	StatusOffline: 0,
+}
+
+// User is the presence of one user, aggregated over their sessions.
 type User struct {
 	UserID string `json:"user_id"`
-	Status string `json:"status"` // e.g., "online", "idle"
+	Name   string `json:"name,omitempty"`   // Display name from the user's token
+	Avatar string `json:"avatar,omitempty"` // Avatar URL from the user's token
+	Status string `json:"status"`           // e.g., "online", "idle"
+	// State is the user-defined presence payload (cursor, selection, ...) of
+	// the most recently seen session that has one.
+	// It is ephemeral and never written to the document.
+	State        json.RawMessage `json:"state,omitempty"`
+	LastSeen     int64           `json:"last_seen"`   // Unix milliseconds of the last message or heartbeat of any session
+	LastActive   int64           `json:"last_active"` // Unix milliseconds of the last connect, op or presence update of any session
+	SessionCount int             `json:"session_count"`
+	Sessions     []Session       `json:"sessions,omitempty"`
+}
+
+// Session is one connection of a user.
+//
+// LastSeen tracks liveness: any message, including heartbeats, refreshes it,
+// and sessions silent for too long are removed. LastActive tracks activity:
+// only connects, ops and presence updates refresh it, and sessions inactive
+// for too long turn idle.
+type Session struct {
+	SessionID  string          `json:"session_id"`
+	UserID     string          `json:"user_id"`
+	Name       string          `json:"name,omitempty"`
+	Avatar     string          `json:"avatar,omitempty"`
+	Status     string          `json:"status"`
+	State      json.RawMessage `json:"state,omitempty"`
+	LastSeen   int64           `json:"last_seen"`
+	LastActive int64           `json:"last_active"`
+	Device     Device          `json:"device"`
+}
+
+// Device describes where a session is connected from.
+type Device struct {
+	Name      string `json:"name,omitempty"` // Chosen by the client, e.g. "Firefox on laptop"
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// Presence event types, broadcast to the workspace and dispatched to webhooks.
+const (
+	EventJoined        = "presence.joined"
+	EventLeft          = "presence.left"
+	EventStatusChanged = "presence.status_changed"
+)
+
+// Event reports that a user joined, left, or changed status. Sessions that
+// come and go while their user stays present cause no event.
+type Event struct {
+	Type        string `json:"type"`
+	WorkspaceID string `json:"workspace_id"`
+	User        User   `json:"user"`
+	PrevStatus  string `json:"prev_status,omitempty"`
+}
+
+// Backend stores which sessions are connected to each workspace. Methods that
+// change presence return the resulting event, or nil if nothing visible changed.
+//
+// Manager keeps this in memory for a single server; RedisBackend shares it
+// across a cluster of servers.
+type Backend interface {
+	AddSession(workspaceID string, session Session) *Event
+	RemoveSession(workspaceID, userID, sessionID string) *Event
+	GetUsers(workspaceID string) []User
+	// UpdateSession records activity of a connected session: it replaces the
+	// presence state and marks the session as seen, active and online. A nil
+	// state keeps the current one. It reports false if the session is not
+	// connected.
+	UpdateSession(workspaceID, userID, sessionID string, state json.RawMessage) (Session, *Event, bool)
+	// TouchSession marks a connected session as seen without counting as
+	// activity, e.g. for a heartbeat. It reports false if the session is not
+	// connected.
+	TouchSession(workspaceID, userID, sessionID string) bool
+	// Sweep marks sessions not active for idleAfter as idle and removes those
+	// not seen for offlineAfter, e.g. behind a connection that died silently.
+	// A zero duration disables that transition.
+	Sweep(now time.Time, idleAfter, offlineAfter time.Duration) []Event
 }
 
+var _ Backend = (*Manager)(nil)
+
 type Manager struct {
 	mu         sync.RWMutex
-	workspaces map[string]map[string]User // workspaceID -> userID -> User
+	workspaces map[string]map[string]map[string]Session // workspaceID -> userID -> sessionID -> Session
 }
 
 func NewManager() *Manager {
 	return &Manager{
-		workspaces: make(map[string]map[string]User),
+		workspaces: make(map[string]map[string]map[string]Session),
 	}
 }
 
-func (m *Manager) AddUser(workspaceID string, user User) {
+func (m *Manager) AddSession(workspaceID string, session Session) *Event {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	// Defensive Coding: Enforce known status
-	switch user.Status {
+	switch session.Status {
 	case StatusOnline, StatusIdle, StatusOffline:
 		// valid
 	default:
-		user.Status = StatusUnknown
+		session.Status = StatusUnknown
 	}
 
+	if session.LastSeen == 0 {
+		session.LastSeen = time.Now().UnixMilli()
+	}
+	if session.LastActive == 0 {
+		session.LastActive = session.LastSeen
+	}
+
+	before := m.userLocked(workspaceID, session.UserID)
 	if _, ok := m.workspaces[workspaceID]; !ok {
-		m.workspaces[workspaceID] = make(map[string]User)
+		m.workspaces[workspaceID] = make(map[string]map[string]Session)
 	}
-	m.workspaces[workspaceID][user.UserID] = user
+	if _, ok := m.workspaces[workspaceID][session.UserID]; !ok {
+		m.workspaces[workspaceID][session.UserID] = make(map[string]Session)
+	}
+	m.workspaces[workspaceID][session.UserID][session.SessionID] = session
+	return diff(workspaceID, before, m.userLocked(workspaceID, session.UserID))
+}
+
+func (m *Manager) UpdateSession(workspaceID, userID, sessionID string, state json.RawMessage) (Session, *Event, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.workspaces[workspaceID][userID][sessionID]
+	if !ok {
+		return Session{}, nil, false
+	}
+	before := m.userLocked(workspaceID, userID)
+	if state != nil {
+		session.State = state
+	}
+	session.Status = StatusOnline
+	session.LastSeen = time.Now().UnixMilli()
+	session.LastActive = session.LastSeen
+	m.workspaces[workspaceID][userID][sessionID] = session
+	return session, diff(workspaceID, before, m.userLocked(workspaceID, userID)), true
+}
+
+func (m *Manager) TouchSession(workspaceID, userID, sessionID string) bool {
+	_, ok := m.touch(workspaceID, userID, sessionID)
+	return ok
+}
+
+// touch marks a session as seen and returns it.
+func (m *Manager) touch(workspaceID, userID, sessionID string) (Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.workspaces[workspaceID][userID][sessionID]
+	if !ok {
+		return Session{}, false
+	}
+	session.LastSeen = time.Now().UnixMilli()
+	m.workspaces[workspaceID][userID][sessionID] = session
+	return session, true
+}
+
+func (m *Manager) RemoveSession(workspaceID, userID, sessionID string) *Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	before := m.userLocked(workspaceID, userID)
+	m.removeLocked(workspaceID, userID, sessionID)
+	return diff(workspaceID, before, m.userLocked(workspaceID, userID))
+}
+
+// AddUser adds user as a single session whose ID is the user ID.
+func (m *Manager) AddUser(workspaceID string, user User) *Event {
+	return m.AddSession(workspaceID, Session{
+		SessionID: user.UserID,
+		UserID:    user.UserID,
+		Status:    user.Status,
+		State:     user.State,
+		LastSeen:  user.LastSeen,
+	})
 }
 
-func (m *Manager) RemoveUser(workspaceID string, userID string) {
+// RemoveUser removes every session of userID.
+func (m *Manager) RemoveUser(workspaceID string, userID string) *Event {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if workspace, ok := m.workspaces[workspaceID]; ok {
-		delete(workspace, userID)
-		if len(workspace) == 0 {
-			delete(m.workspaces, workspaceID)
+	before := m.userLocked(workspaceID, userID)
+	for sessionID := range m.workspaces[workspaceID][userID] {
+		m.removeLocked(workspaceID, userID, sessionID)
+	}
+	return diff(workspaceID, before, nil)
+}
+
+func (m *Manager) removeLocked(workspaceID, userID, sessionID string) {
+	sessions, ok := m.workspaces[workspaceID][userID]
+	if !ok {
+		return
+	}
+	delete(sessions, sessionID)
+	if len(sessions) == 0 {
+		delete(m.workspaces[workspaceID], userID)
+	}
+	if len(m.workspaces[workspaceID]) == 0 {
+		delete(m.workspaces, workspaceID)
+	}
+}
+
+func (m *Manager) Sweep(now time.Time, idleAfter, offlineAfter time.Duration) []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []Event
+	for workspaceID, users := range m.workspaces {
+		for userID, sessions := range users {
+			before := m.userLocked(workspaceID, userID)
+			for sessionID, session := range sessions {
+				silence := now.Sub(time.UnixMilli(session.LastSeen))
+				inactivity := now.Sub(time.UnixMilli(session.LastActive))
+				switch {
+				case offlineAfter > 0 && silence >= offlineAfter:
+					m.removeLocked(workspaceID, userID, sessionID)
+				case idleAfter > 0 && inactivity >= idleAfter && session.Status == StatusOnline:
+					session.Status = StatusIdle
+					sessions[sessionID] = session
+				}
+			}
+			if e := diff(workspaceID, before, m.userLocked(workspaceID, userID)); e != nil {
+				events = append(events, *e)
+			}
 		}
 	}
+	return events
 }
 
 func (m *Manager) GetUsers(workspaceID string) []User {
@@ -70,10 +273,95 @@ func (m *Manager) GetUsers(workspaceID string) []User {
 	defer m.mu.RUnlock()
 
 	var users []User
-	if workspace, ok := m.workspaces[workspaceID]; ok {
-		for _, u := range workspace {
-			users = append(users, u)
-		}
+	for userID := range m.workspaces[workspaceID] {
+		users = append(users, *m.userLocked(workspaceID, userID))
 	}
 	return users
 }
+
+// sessions lists the sessions connected to a workspace.
+func (m *Manager) sessions(workspaceID string) []Session {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var list []Session
+	for _, sessions := range m.workspaces[workspaceID] {
+		for _, s := range sessions {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// userLocked aggregates the sessions of userID, or returns nil if it has none.
+func (m *Manager) userLocked(workspaceID, userID string) *User {
+	sessions := m.workspaces[workspaceID][userID]
+	if len(sessions) == 0 {
+		return nil
+	}
+	list := make([]Session, 0, len(sessions))
+	for _, s := range sessions {
+		list = append(list, s)
+	}
+	return aggregate(userID, list)
+}
+
+// aggregate builds the user view of a non-empty set of sessions: the most
+// present status, the latest activity and the state of the most recently
+// seen session that has one. Name and avatar come from the same token
+// subject in every session.
+func aggregate(userID string, sessions []Session) *User {
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].SessionID < sessions[j].SessionID })
+
+	user := &User{UserID: userID, Status: StatusOffline, SessionCount: len(sessions), Sessions: sessions}
+	var stateSeen int64
+	for _, s := range sessions {
+		if statusRank[s.Status] > statusRank[user.Status] {
+			user.Status = s.Status
+		}
+		if s.LastSeen > user.LastSeen {
+			user.LastSeen = s.LastSeen
+		}
+		if s.LastActive > user.LastActive {
+			user.LastActive = s.LastActive
+		}
+		if s.Name != "" {
+			user.Name = s.Name
+		}
+		if s.Avatar != "" {
+			user.Avatar = s.Avatar
+		}
+		if s.State != nil && s.LastSeen >= stateSeen {
+			user.State, stateSeen = s.State, s.LastSeen
+		}
+	}
+	return user
+}
+
+// diff describes the change from before to after; either is nil when the
+// user has no sessions.
+func diff(workspaceID string, before, after *User) *Event {
+	switch {
+	case before == nil && after != nil:
+		return &Event{Type: EventJoined, WorkspaceID: workspaceID, User: *after}
+	case before != nil && after == nil:
+		left := *before
+		left.Status, left.SessionCount, left.Sessions = StatusOffline, 0, nil
+		return &Event{Type: EventLeft, WorkspaceID: workspaceID, User: left, PrevStatus: before.Status}
+	case before != nil && before.Status != after.Status:
+		return &Event{Type: EventStatusChanged, WorkspaceID: workspaceID, User: *after, PrevStatus: before.Status}
+	}
+	return nil
+}
+
+// workspaceIDs lists the workspaces that have at least one session.
+func (m *Manager) workspaceIDs() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids := make([]string, 0, len(m.workspaces))
+	for id := range m.workspaces {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -3,6 +3,7 @@ package presence
 import (
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestManager_AddRemoveUser(t *testing.T) {
@@ -117,3 +118,136 @@ func TestManager_Concurrency(t *testing.T) {
 		t.Errorf("Expected 0 or 1 user after concurrent ops, got %d", len(users))
 	}
 }
+
+func TestManager_Events(t *testing.T) {
+	m := NewManager()
+
+	if e := m.AddUser("ws", User{UserID: "user-1", Status: StatusOnline}); e == nil || e.Type != EventJoined {
+		t.Errorf("Expected %s, got %+v", EventJoined, e)
+	}
+	// A second connection of a present user changes nothing visible.
+	if e := m.AddUser("ws", User{UserID: "user-1", Status: StatusOnline}); e != nil {
+		t.Errorf("Expected no event, got %+v", e)
+	}
+	if _, e, _ := m.UpdateSession("ws", "user-1", "user-1", nil); e != nil {
+		t.Errorf("Expected no event for an online heartbeat, got %+v", e)
+	}
+	e := m.RemoveUser("ws", "user-1")
+	if e == nil || e.Type != EventLeft || e.User.Status != StatusOffline || e.PrevStatus != StatusOnline {
+		t.Errorf("Expected %s from online to offline, got %+v", EventLeft, e)
+	}
+	if e := m.RemoveUser("ws", "user-1"); e != nil {
+		t.Errorf("Expected no event for an absent user, got %+v", e)
+	}
+}
+
+func TestManager_Sweep(t *testing.T) {
+	m := NewManager()
+	m.AddUser("ws", User{UserID: "user-1", Status: StatusOnline})
+	start := time.Now()
+
+	// Not silent long enough for anything.
+	if events := m.Sweep(start, time.Minute, 5*time.Minute); len(events) != 0 {
+		t.Errorf("Expected no events, got %+v", events)
+	}
+
+	events := m.Sweep(start.Add(2*time.Minute), time.Minute, 5*time.Minute)
+	if len(events) != 1 || events[0].Type != EventStatusChanged || events[0].User.Status != StatusIdle {
+		t.Fatalf("Expected a change to idle, got %+v", events)
+	}
+	// Idle users are not reported again.
+	if events := m.Sweep(start.Add(3*time.Minute), time.Minute, 5*time.Minute); len(events) != 0 {
+		t.Errorf("Expected no repeated events, got %+v", events)
+	}
+
+	// Activity brings the user back online.
+	session, e, ok := m.UpdateSession("ws", "user-1", "user-1", nil)
+	if !ok || session.Status != StatusOnline || e == nil || e.PrevStatus != StatusIdle {
+		t.Errorf("Expected a change from idle to online, got %+v (event %+v)", session, e)
+	}
+
+	events = m.Sweep(time.Now().Add(6*time.Minute), time.Minute, 5*time.Minute)
+	if len(events) != 1 || events[0].Type != EventLeft {
+		t.Fatalf("Expected %s after the offline TTL, got %+v", EventLeft, events)
+	}
+	if users := m.GetUsers("ws"); len(users) != 0 {
+		t.Errorf("Expected the user to be removed, got %v", users)
+	}
+}
+
+func TestManager_TouchSession(t *testing.T) {
+	m := NewManager()
+	start := time.Now()
+	connected := start.Add(-3 * time.Minute)
+	m.AddSession("ws", Session{SessionID: "s-1", UserID: "user-1", Status: StatusOnline, LastSeen: connected.UnixMilli()})
+
+	// A heartbeat keeps the session alive but does not count as activity.
+	if !m.TouchSession("ws", "user-1", "s-1") {
+		t.Fatal("Expected the session to be touched")
+	}
+	events := m.Sweep(start, time.Minute, 5*time.Minute)
+	if len(events) != 1 || events[0].User.Status != StatusIdle {
+		t.Errorf("Expected a change to idle despite the heartbeat, got %+v", events)
+	}
+	// Removal is measured from the heartbeat, not from the last activity.
+	if events := m.Sweep(start.Add(4*time.Minute), time.Minute, 5*time.Minute); len(events) != 0 {
+		t.Errorf("Expected the touched session to stay, got %+v", events)
+	}
+
+	if m.TouchSession("ws", "user-1", "missing") {
+		t.Error("Expected TouchSession to report an unknown session")
+	}
+}
+
+func TestManager_Sessions(t *testing.T) {
+	m := NewManager()
+
+	laptop := Session{SessionID: "s-1", UserID: "user-1", Status: StatusOnline, Device: Device{Name: "laptop"}}
+	phone := Session{SessionID: "s-2", UserID: "user-1", Status: StatusOnline, Device: Device{Name: "phone"}}
+	if e := m.AddSession("ws", laptop); e == nil || e.Type != EventJoined {
+		t.Errorf("Expected %s for the first session, got %+v", EventJoined, e)
+	}
+	if e := m.AddSession("ws", phone); e != nil {
+		t.Errorf("Expected no event for a second session, got %+v", e)
+	}
+
+	users := m.GetUsers("ws")
+	if len(users) != 1 || users[0].SessionCount != 2 || users[0].Sessions[1].Device.Name != "phone" {
+		t.Fatalf("Expected user-1 with 2 sessions, got %+v", users)
+	}
+
+	// The most recently updated session provides the user's state.
+	m.UpdateSession("ws", "user-1", "s-2", []byte(`{"cursor":7}`))
+	if users := m.GetUsers("ws"); string(users[0].State) != `{"cursor":7}` {
+		t.Errorf("Expected the phone's state, got %s", users[0].State)
+	}
+
+	// Closing one tab keeps the user online.
+	if e := m.RemoveSession("ws", "user-1", "s-1"); e != nil {
+		t.Errorf("Expected no event while a session remains, got %+v", e)
+	}
+	if users := m.GetUsers("ws"); len(users) != 1 || users[0].Status != StatusOnline || users[0].SessionCount != 1 {
+		t.Errorf("Expected user-1 online with 1 session, got %+v", users)
+	}
+
+	// An idle session does not make the user idle while another is online.
+	m.AddSession("ws", laptop)
+	start := time.Now()
+	m.UpdateSession("ws", "user-1", "s-1", nil)
+	m.workspaces["ws"]["user-1"]["s-2"] = Session{SessionID: "s-2", UserID: "user-1", Status: StatusOnline, LastSeen: start.Add(-2 * time.Minute).UnixMilli()}
+	if events := m.Sweep(start, time.Minute, 5*time.Minute); len(events) != 0 {
+		t.Errorf("Expected no events, got %+v", events)
+	}
+	if users := m.GetUsers("ws"); users[0].Status != StatusOnline || users[0].Sessions[1].Status != StatusIdle {
+		t.Errorf("Expected user-1 online with an idle phone, got %+v", users)
+	}
+
+	e := m.RemoveSession("ws", "user-1", "s-1")
+	if e == nil || e.Type != EventStatusChanged || e.User.Status != StatusIdle {
+		t.Errorf("Expected a change to idle, got %+v", e)
+	}
+	e = m.RemoveSession("ws", "user-1", "s-2")
+	if e == nil || e.Type != EventLeft || e.PrevStatus != StatusIdle {
+		t.Errorf("Expected %s from idle, got %+v", EventLeft, e)
+	}
+}
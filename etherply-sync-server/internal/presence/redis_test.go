@@ -0,0 +1,158 @@
+package presence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newRedisBackend(t *testing.T, mr *miniredis.Miniredis, instance string) *RedisBackend {
+	t.Helper()
+	b, err := NewRedisBackend(RedisConfig{URL: "redis://" + mr.Addr(), InstanceID: instance})
+	if err != nil {
+		t.Fatalf("NewRedisBackend failed: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func userIDs(users []User) map[string]string {
+	ids := make(map[string]string)
+	for _, u := range users {
+		ids[u.UserID] = u.Status
+	}
+	return ids
+}
+
+func TestRedisBackend_SharedAcrossInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	server1 := newRedisBackend(t, mr, "server-1")
+	server2 := newRedisBackend(t, mr, "server-2")
+
+	server1.AddSession("workspace-1", Session{SessionID: "user-1-server1", UserID: "user-1", Status: StatusOnline})
+	server2.AddSession("workspace-1", Session{SessionID: "user-2-server2", UserID: "user-2", Status: StatusIdle})
+	server2.AddSession("workspace-2", Session{SessionID: "user-3-server2", UserID: "user-3", Status: StatusOnline})
+
+	for name, b := range map[string]*RedisBackend{"server1": server1, "server2": server2} {
+		ids := userIDs(b.GetUsers("workspace-1"))
+		if len(ids) != 2 || ids["user-1"] != StatusOnline || ids["user-2"] != StatusIdle {
+			t.Errorf("%s: expected user-1 online and user-2 idle, got %v", name, ids)
+		}
+	}
+
+	server1.RemoveSession("workspace-1", "user-1", "user-1-server1")
+	if ids := userIDs(server2.GetUsers("workspace-1")); len(ids) != 1 || ids["user-2"] == "" {
+		t.Errorf("Expected only user-2 after removal, got %v", ids)
+	}
+}
+
+func TestRedisBackend_UserOnSeveralInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	server1 := newRedisBackend(t, mr, "server-1")
+	server2 := newRedisBackend(t, mr, "server-2")
+
+	server1.AddSession("workspace-1", Session{SessionID: "user-1-server1", UserID: "user-1", Status: StatusIdle})
+	server2.AddSession("workspace-1", Session{SessionID: "user-1-server2", UserID: "user-1", Status: StatusOnline})
+
+	users := server1.GetUsers("workspace-1")
+	if len(users) != 1 || users[0].Status != StatusOnline || users[0].SessionCount != 2 {
+		t.Errorf("Expected user-1 listed once as online with 2 sessions, got %v", users)
+	}
+
+	// Leaving one instance keeps the user online through the other.
+	server2.RemoveSession("workspace-1", "user-1", "user-1-server2")
+	if ids := userIDs(server2.GetUsers("workspace-1")); ids["user-1"] != StatusIdle {
+		t.Errorf("Expected user-1 still present via server-1, got %v", ids)
+	}
+}
+
+func TestRedisBackend_EventsFromClusterView(t *testing.T) {
+	mr := miniredis.RunT(t)
+	server1 := newRedisBackend(t, mr, "server-1")
+	server2 := newRedisBackend(t, mr, "server-2")
+
+	if e := server1.AddSession("workspace-1", Session{SessionID: "s1", UserID: "user-1", Status: StatusIdle}); e == nil || e.Type != EventJoined {
+		t.Fatalf("Expected the first session to join, got %+v", e)
+	}
+	// A second session on another instance only raises the status.
+	e := server2.AddSession("workspace-1", Session{SessionID: "s2", UserID: "user-1", Status: StatusOnline})
+	if e == nil || e.Type != EventStatusChanged || e.PrevStatus != StatusIdle || e.User.Status != StatusOnline || e.User.SessionCount != 2 {
+		t.Errorf("Expected idle -> online, got %+v", e)
+	}
+
+	// Leaving one instance is not leaving the workspace.
+	e = server2.RemoveSession("workspace-1", "user-1", "s2")
+	if e == nil || e.Type != EventStatusChanged || e.User.Status != StatusIdle {
+		t.Errorf("Expected online -> idle while server-1 still has a session, got %+v", e)
+	}
+	if e := server2.AddSession("workspace-1", Session{SessionID: "s3", UserID: "user-1", Status: StatusIdle}); e != nil {
+		t.Errorf("Expected no event for another idle session, got %+v", e)
+	}
+	if e := server2.RemoveSession("workspace-1", "user-1", "s3"); e != nil {
+		t.Errorf("Expected no event while server-1 still has a session, got %+v", e)
+	}
+	if e := server1.RemoveSession("workspace-1", "user-1", "s1"); e == nil || e.Type != EventLeft {
+		t.Errorf("Expected the last session to leave, got %+v", e)
+	}
+}
+
+func TestRedisBackend_WorkspaceIDsWithColons(t *testing.T) {
+	mr := miniredis.RunT(t)
+	server1 := newRedisBackend(t, mr, "server-1")
+	server2 := newRedisBackend(t, mr, "server-2")
+
+	// With "<prefix><workspace>" as the instance set, this workspace's set
+	// was server-1's session hash of "a".
+	server1.AddSession("a", Session{SessionID: "s1", UserID: "user-1", Status: StatusOnline})
+	server2.AddSession("a:server-1", Session{SessionID: "s2", UserID: "user-2", Status: StatusOnline})
+
+	for ws, want := range map[string]string{"a": "user-1", "a:server-1": "user-2"} {
+		for name, b := range map[string]*RedisBackend{"server1": server1, "server2": server2} {
+			if ids := userIDs(b.GetUsers(ws)); len(ids) != 1 || ids[want] == "" {
+				t.Errorf("%s: expected only %s in %s, got %v", name, want, ws, ids)
+			}
+		}
+	}
+}
+
+func TestRedisBackend_CrashedInstanceExpires(t *testing.T) {
+	mr := miniredis.RunT(t)
+	alive := newRedisBackend(t, mr, "server-1")
+	crashed := newRedisBackend(t, mr, "server-2")
+
+	alive.AddSession("workspace-1", Session{SessionID: "user-1-alive", UserID: "user-1", Status: StatusOnline})
+	crashed.AddSession("workspace-1", Session{SessionID: "user-2-crashed", UserID: "user-2", Status: StatusOnline})
+
+	// Crash: the heartbeat stops without withdrawing the entries.
+	crashed.closeOnce.Do(func() { close(crashed.stop) })
+	t.Cleanup(func() { crashed.client.Close() })
+
+	mr.FastForward(DefaultRedisTTL / 2)
+	alive.sync("workspace-1") // What alive's heartbeat does
+	mr.FastForward(DefaultRedisTTL/2 + time.Second)
+
+	if ids := userIDs(alive.GetUsers("workspace-1")); len(ids) != 1 || ids["user-1"] == "" {
+		t.Errorf("Expected only user-1 after server-2 expired, got %v", ids)
+	}
+}
+
+func TestRedisBackend_CloseWithdrawsUsers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	server1 := newRedisBackend(t, mr, "server-1")
+	server2 := newRedisBackend(t, mr, "server-2")
+
+	server2.AddSession("workspace-1", Session{SessionID: "user-2-server2", UserID: "user-2", Status: StatusOnline})
+	server2.Close()
+
+	if users := server1.GetUsers("workspace-1"); len(users) != 0 {
+		t.Errorf("Expected no users after server-2 closed, got %v", users)
+	}
+}
+
+func TestNewRedisBackend_RequiresInstanceID(t *testing.T) {
+	mr := miniredis.RunT(t)
+	if _, err := NewRedisBackend(RedisConfig{URL: "redis://" + mr.Addr()}); err == nil {
+		t.Error("Expected an error without an instance ID")
+	}
+}
@@ -4,9 +4,27 @@ package pubsub
 type Message struct {
 	Topic    string
 	Payload  []byte
-	SenderID string // Optional: exclude this sender from receiving (logic depends on implementation)
+	SenderID string // Optional: session ID of the sender; the write pump skips it unless the client opted into echoes
+	// Kind is empty for published messages, except for MessagePresence.
+	// Markers generated by the PubSub for slow subscribers carry no payload.
+	Kind MessageKind
 }
 
+// MessageKind tells published messages apart from overflow markers.
+type MessageKind string
+
+const (
+	// MessageResync replaces messages dropped for a slow subscriber; the
+	// subscriber must re-fetch the full state.
+	MessageResync MessageKind = "resync_required"
+	// MessageOverflow is the last message of a slow subscriber under
+	// OverflowDisconnect; the subscriber must disconnect its client.
+	MessageOverflow MessageKind = "overflow"
+	// MessagePresence is a published presence event. Unlike other messages,
+	// it is never echoed to the sender, which it describes.
+	MessagePresence MessageKind = "presence"
+)
+
 // PubSubStats contains metrics about the PubSub system.
 type PubSubStats struct {
 	TotalSubscribers int `json:"total_subscribers"`
@@ -6,18 +6,84 @@ import (
 	"github.com/google/uuid"
 )
 
+// OverflowPolicy decides what happens when a subscriber falls BufferSize
+// messages behind.
+type OverflowPolicy string
+
+const (
+	// OverflowDisconnect drops the backlog and tells the subscriber to
+	// disconnect its client (MessageOverflow).
+	OverflowDisconnect OverflowPolicy = "disconnect"
+	// OverflowResync drops the backlog and tells the subscriber to re-fetch
+	// the full state (MessageResync).
+	OverflowResync OverflowPolicy = "resync"
+	// OverflowSpill keeps up to SpillSize further messages queued, then
+	// falls back to OverflowResync.
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// Defaults for MemoryConfig.
+const (
+	DefaultBufferSize = 100
+	DefaultSpillSize  = 1000
+)
+
+// MemoryConfig holds MemoryPubSub configuration.
+type MemoryConfig struct {
+	BufferSize int
+	SpillSize  int
+	Overflow   OverflowPolicy
+}
+
+// MemoryOption configures a MemoryPubSub.
+type MemoryOption func(*MemoryConfig)
+
+// WithBufferSize sets how many messages a subscriber may fall behind before
+// the overflow policy applies.
+func WithBufferSize(n int) MemoryOption {
+	return func(cfg *MemoryConfig) {
+		cfg.BufferSize = n
+	}
+}
+
+// WithOverflowPolicy sets how slow subscribers are handled. Defaults to OverflowResync.
+func WithOverflowPolicy(p OverflowPolicy) MemoryOption {
+	return func(cfg *MemoryConfig) {
+		cfg.Overflow = p
+	}
+}
+
+// WithSpillSize sets the extra queue length of OverflowSpill.
+func WithSpillSize(n int) MemoryOption {
+	return func(cfg *MemoryConfig) {
+		cfg.SpillSize = n
+	}
+}
+
 // MemoryPubSub is a thread-safe in-memory implementation of PubSub.
 type MemoryPubSub struct {
 	mu     sync.RWMutex
-	topics map[string]map[string]chan Message // topic -> subID -> chan
+	config *MemoryConfig
+	topics map[string]map[string]*subscriber // topic -> subID -> subscriber
 }
 
-func NewMemoryPubSub() *MemoryPubSub {
+func NewMemoryPubSub(opts ...MemoryOption) *MemoryPubSub {
+	cfg := &MemoryConfig{
+		BufferSize: DefaultBufferSize,
+		SpillSize:  DefaultSpillSize,
+		Overflow:   OverflowResync,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
 	return &MemoryPubSub{
-		topics: make(map[string]map[string]chan Message),
+		config: cfg,
+		topics: make(map[string]map[string]*subscriber),
 	}
 }
 
+// Publish queues msg for every subscriber of topic. It never blocks on slow
+// subscribers; those are handled by the overflow policy instead.
 func (ps *MemoryPubSub) Publish(topic string, msg Message) error {
 	ps.mu.RLock()
 	defer ps.mu.RUnlock()
@@ -27,18 +93,8 @@ func (ps *MemoryPubSub) Publish(topic string, msg Message) error {
 		return nil // No subscribers
 	}
 
-	for _, ch := range subs {
-		// Non-blocking send to prevent one slow subscriber from blocking publisher?
-		// Or blocking? For in-memory Sync Server, likely blocking with buffer is safer to assume delivery,
-		// but if buffer full, we drop or block?
-		// Let's use non-blocking select to avoid deadlock if subscriber is dead.
-		select {
-		case ch <- msg:
-		default:
-			// Buffer full, drop message or log?
-			// For reliable sync, this is bad. But if client is too slow, maybe we disconnect them.
-			// Ideally we use a larger buffer.
-		}
+	for _, sub := range subs {
+		sub.deliver(msg)
 	}
 	return nil
 }
@@ -48,14 +104,13 @@ func (ps *MemoryPubSub) Subscribe(topic string) (<-chan Message, func()) {
 	defer ps.mu.Unlock()
 
 	if _, ok := ps.topics[topic]; !ok {
-		ps.topics[topic] = make(map[string]chan Message)
+		ps.topics[topic] = make(map[string]*subscriber)
 	}
 
-	// Buffer size 100 to tolerate bursts
-	ch := make(chan Message, 100)
+	sub := newSubscriber(topic, ps.config)
 	subID := uuid.New().String()
 
-	ps.topics[topic][subID] = ch
+	ps.topics[topic][subID] = sub
 
 	unsub := func() {
 		ps.mu.Lock()
@@ -64,7 +119,7 @@ func (ps *MemoryPubSub) Subscribe(topic string) (<-chan Message, func()) {
 		if subs, ok := ps.topics[topic]; ok {
 			if _, exists := subs[subID]; exists {
 				delete(subs, subID)
-				close(ch)
+				sub.close()
 			}
 			if len(subs) == 0 {
 				delete(ps.topics, topic)
@@ -72,7 +127,7 @@ func (ps *MemoryPubSub) Subscribe(topic string) (<-chan Message, func()) {
 		}
 	}
 
-	return ch, unsub
+	return sub.out, unsub
 }
 
 func (ps *MemoryPubSub) Stats() PubSubStats {
@@ -4,7 +4,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/bneb/etherply/etherply-sync-server/internal/metrics"
 	"github.com/bneb/etherply/etherply-sync-server/internal/pubsub"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestMemoryPubSub(t *testing.T) {
@@ -56,8 +58,11 @@ func TestMemoryPubSub(t *testing.T) {
 	ps.Publish(topic, msg2)
 
 	select {
-	case <-rx1:
-		t.Error("Sub1 received message after unsubscribe")
+	case _, ok := <-rx1:
+		// A closed channel is expected; a delivered message is not.
+		if ok {
+			t.Error("Sub1 received message after unsubscribe")
+		}
 	case m := <-rx2:
 		if string(m.Payload) != "world" {
 			t.Error("Sub2 wrong payload")
@@ -67,3 +72,94 @@ func TestMemoryPubSub(t *testing.T) {
 		// If rx2 received above, we are good.
 	}
 }
+
+// publishN publishes n numbered messages to topic.
+func publishN(ps *pubsub.MemoryPubSub, topic string, n int) {
+	for i := 0; i < n; i++ {
+		ps.Publish(topic, pubsub.Message{Topic: topic, Payload: []byte{byte(i)}})
+	}
+}
+
+// drain reads from rx until nothing arrives for a short while.
+func drain(rx <-chan pubsub.Message) []pubsub.Message {
+	var msgs []pubsub.Message
+	for {
+		select {
+		case m, ok := <-rx:
+			if !ok {
+				return msgs
+			}
+			msgs = append(msgs, m)
+		case <-time.After(50 * time.Millisecond):
+			return msgs
+		}
+	}
+}
+
+func TestMemoryPubSub_OverflowResync(t *testing.T) {
+	ps := pubsub.NewMemoryPubSub(pubsub.WithBufferSize(3), pubsub.WithOverflowPolicy(pubsub.OverflowResync))
+	rx, unsub := ps.Subscribe("ws-resync")
+	defer unsub()
+
+	before := testutil.ToFloat64(metrics.PubSubDropped.WithLabelValues("ws-resync", "resync"))
+	// Nobody reads: the backlog beyond 3 messages is replaced by a resync
+	// marker, and nothing is queued behind it.
+	publishN(ps, "ws-resync", 10)
+	msgs := drain(rx)
+	if len(msgs) == 0 || msgs[len(msgs)-1].Kind != pubsub.MessageResync {
+		t.Fatalf("Expected the resync marker last, got %v", msgs)
+	}
+	dropped := testutil.ToFloat64(metrics.PubSubDropped.WithLabelValues("ws-resync", "resync")) - before
+	if delivered := len(msgs) - 1; int(dropped)+delivered != 10 {
+		t.Errorf("Expected every message delivered or counted as dropped, got %d delivered and %v dropped", delivered, dropped)
+	}
+
+	// Once the marker was read, delivery continues.
+	publishN(ps, "ws-resync", 1)
+	if msgs := drain(rx); len(msgs) != 1 || msgs[0].Kind != "" {
+		t.Errorf("Expected delivery to continue after the marker, got %v", msgs)
+	}
+}
+
+func TestMemoryPubSub_OverflowDisconnect(t *testing.T) {
+	ps := pubsub.NewMemoryPubSub(pubsub.WithBufferSize(2), pubsub.WithOverflowPolicy(pubsub.OverflowDisconnect))
+	rx, unsub := ps.Subscribe("ws-disconnect")
+	defer unsub()
+
+	publishN(ps, "ws-disconnect", 10)
+
+	msgs := drain(rx)
+	if len(msgs) == 0 || msgs[len(msgs)-1].Kind != pubsub.MessageOverflow {
+		t.Fatalf("Expected the overflow marker last, got %v", msgs)
+	}
+	// Nothing is delivered to a subscriber that is being disconnected.
+	publishN(ps, "ws-disconnect", 1)
+	if more := drain(rx); len(more) != 0 {
+		t.Errorf("Expected no messages after the overflow marker, got %v", more)
+	}
+}
+
+func TestMemoryPubSub_OverflowSpill(t *testing.T) {
+	ps := pubsub.NewMemoryPubSub(pubsub.WithBufferSize(2), pubsub.WithSpillSize(20), pubsub.WithOverflowPolicy(pubsub.OverflowSpill))
+	rx, unsub := ps.Subscribe("ws-spill")
+	defer unsub()
+
+	// Within buffer plus spill, everything arrives in order.
+	publishN(ps, "ws-spill", 15)
+	msgs := drain(rx)
+	if len(msgs) != 15 {
+		t.Fatalf("Expected 15 messages, got %d", len(msgs))
+	}
+	for i, m := range msgs {
+		if m.Kind != "" || m.Payload[0] != byte(i) {
+			t.Fatalf("Expected message %d in order, got %v", i, m)
+		}
+	}
+
+	// Past the spill queue it falls back to a resync.
+	publishN(ps, "ws-spill", 40)
+	msgs = drain(rx)
+	if msgs[len(msgs)-1].Kind != pubsub.MessageResync {
+		t.Errorf("Expected a resync marker once the spill queue is full, got %v", msgs[len(msgs)-1])
+	}
+}
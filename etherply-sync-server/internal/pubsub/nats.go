@@ -0,0 +1,187 @@
+package pubsub
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultSubjectPrefix is the subject prefix of NATSPubSub topics.
+const DefaultSubjectPrefix = "etherply.ws"
+
+// Header names carrying Message fields besides the payload.
+const (
+	natsSenderHeader = "Etherply-Sender"
+	natsKindHeader   = "Etherply-Kind"
+)
+
+// NATSConfig holds NATSPubSub configuration.
+type NATSConfig struct {
+	URL           string
+	Name          string // Connection name shown in NATS monitoring
+	SubjectPrefix string // Defaults to DefaultSubjectPrefix
+}
+
+// NATSPubSub fans messages out across server instances over core NATS, one
+// subject per topic. Each instance holds one NATS subscription per topic that
+// has local subscribers, and delivers to those subscribers through a
+// MemoryPubSub so the overflow policies still apply.
+//
+// Delivery is at-most-once: subscribers on an instance that is disconnected
+// from NATS miss the messages published in the meantime.
+type NATSPubSub struct {
+	nc     *nats.Conn
+	prefix string
+	local  *MemoryPubSub
+	logger *slog.Logger
+
+	mu   sync.Mutex
+	subs map[string]*natsTopic // topic -> NATS subscription
+}
+
+// natsTopic is the NATS subscription of one topic, shared by its local subscribers.
+type natsTopic struct {
+	sub   *nats.Subscription
+	refs  int
+	ready chan struct{} // closed once the first subscriber has subscribed
+}
+
+// NewNATSPubSub connects to NATS. Options configure the local fan-out.
+func NewNATSPubSub(cfg NATSConfig, opts ...MemoryOption) (*NATSPubSub, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("NATS URL is required")
+	}
+	if cfg.SubjectPrefix == "" {
+		cfg.SubjectPrefix = DefaultSubjectPrefix
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
+
+	nc, err := nats.Connect(cfg.URL,
+		nats.Name(cfg.Name),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(nc *nats.Conn, err error) {
+			logger.Warn("pubsub_nats_disconnected", slog.Any("error", err))
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			logger.Info("pubsub_nats_reconnected", slog.String("url", nc.ConnectedUrl()))
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	return &NATSPubSub{
+		nc:     nc,
+		prefix: cfg.SubjectPrefix,
+		local:  NewMemoryPubSub(opts...),
+		logger: logger,
+		subs:   make(map[string]*natsTopic),
+	}, nil
+}
+
+// plainToken matches topics that are valid as a single NATS subject token.
+var plainToken = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// subject maps a topic to its NATS subject. Topics that are not a valid
+// subject token (dots, wildcards, spaces, ...) are base64url-encoded under a
+// "b64" token, which cannot collide with plain topics.
+func (ps *NATSPubSub) subject(topic string) string {
+	if plainToken.MatchString(topic) {
+		return ps.prefix + "." + topic
+	}
+	return ps.prefix + ".b64." + base64.RawURLEncoding.EncodeToString([]byte(topic))
+}
+
+// Publish sends msg to every instance subscribed to topic, this one included.
+func (ps *NATSPubSub) Publish(topic string, msg Message) error {
+	out := nats.NewMsg(ps.subject(topic))
+	out.Data = msg.Payload
+	if msg.SenderID != "" {
+		out.Header.Set(natsSenderHeader, msg.SenderID)
+	}
+	if msg.Kind != "" {
+		out.Header.Set(natsKindHeader, string(msg.Kind))
+	}
+	if err := ps.nc.PublishMsg(out); err != nil {
+		return fmt.Errorf("failed to publish to NATS: %w", err)
+	}
+	return nil
+}
+
+// Subscribe joins topic, subscribing to its NATS subject on first use.
+func (ps *NATSPubSub) Subscribe(topic string) (<-chan Message, func()) {
+	ps.mu.Lock()
+	ch, unsubLocal := ps.local.Subscribe(topic)
+
+	t, ok := ps.subs[topic]
+	if !ok {
+		t = &natsTopic{ready: make(chan struct{})}
+		ps.subs[topic] = t
+	}
+	t.refs++
+	ps.mu.Unlock()
+
+	if !ok {
+		sub, err := ps.nc.Subscribe(ps.subject(topic), func(m *nats.Msg) {
+			ps.local.Publish(topic, Message{
+				Topic:    topic,
+				Payload:  m.Data,
+				SenderID: m.Header.Get(natsSenderHeader),
+				Kind:     MessageKind(m.Header.Get(natsKindHeader)),
+			})
+		})
+		if err == nil {
+			// Wait until the server has registered the subscription: callers
+			// subscribe before reading the state, and must not miss anything
+			// published after that read.
+			err = ps.nc.Flush()
+		}
+		if err != nil {
+			// Local subscribers still work; other instances are not heard.
+			ps.logger.Error("pubsub_nats_subscribe_failed",
+				slog.String("topic", topic),
+				slog.Any("error", err),
+			)
+		}
+		ps.mu.Lock()
+		t.sub = sub
+		ps.mu.Unlock()
+		close(t.ready)
+	} else {
+		<-t.ready
+	}
+
+	var once sync.Once
+	unsub := func() {
+		once.Do(func() {
+			unsubLocal()
+
+			ps.mu.Lock()
+			defer ps.mu.Unlock()
+			t.refs--
+			if t.refs > 0 {
+				return
+			}
+			delete(ps.subs, topic)
+			if t.sub != nil {
+				t.sub.Unsubscribe()
+			}
+		})
+	}
+	return ch, unsub
+}
+
+// Stats returns the local subscribers of this instance.
+func (ps *NATSPubSub) Stats() PubSubStats {
+	return ps.local.Stats()
+}
+
+// Close drains pending messages and closes the NATS connection.
+func (ps *NATSPubSub) Close() error {
+	return ps.nc.Drain()
+}
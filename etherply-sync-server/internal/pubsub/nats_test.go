@@ -0,0 +1,143 @@
+package pubsub_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/pubsub"
+	natsserver "github.com/nats-io/nats-server/v2/server"
+)
+
+// runNATSServer starts an in-process NATS server on a random port.
+func runNATSServer(t *testing.T) string {
+	t.Helper()
+	ns, err := natsserver.NewServer(&natsserver.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatalf("Failed to create NATS server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(ns.Shutdown)
+	return ns.ClientURL()
+}
+
+func newNATSPubSub(t *testing.T, url string) *pubsub.NATSPubSub {
+	t.Helper()
+	ps, err := pubsub.NewNATSPubSub(pubsub.NATSConfig{URL: url})
+	if err != nil {
+		t.Fatalf("NewNATSPubSub failed: %v", err)
+	}
+	t.Cleanup(func() { ps.Close() })
+	return ps
+}
+
+func receive(t *testing.T, rx <-chan pubsub.Message) pubsub.Message {
+	t.Helper()
+	select {
+	case m := <-rx:
+		return m
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for message")
+		return pubsub.Message{}
+	}
+}
+
+func TestNATSPubSub_FanOutAcrossInstances(t *testing.T) {
+	url := runNATSServer(t)
+	server1, server2 := newNATSPubSub(t, url), newNATSPubSub(t, url)
+
+	rx1, unsub1 := server1.Subscribe("ws-1")
+	defer unsub1()
+	rx2, unsub2 := server2.Subscribe("ws-1")
+	defer unsub2()
+	other, unsubOther := server2.Subscribe("ws-2")
+	defer unsubOther()
+
+	server1.Publish("ws-1", pubsub.Message{Topic: "ws-1", Payload: []byte("hello"), SenderID: "session-a"})
+
+	for name, rx := range map[string]<-chan pubsub.Message{"server1": rx1, "server2": rx2} {
+		m := receive(t, rx)
+		if string(m.Payload) != "hello" || m.SenderID != "session-a" || m.Topic != "ws-1" {
+			t.Errorf("%s: expected hello from session-a, got %+v", name, m)
+		}
+	}
+	select {
+	case m := <-other:
+		t.Errorf("Expected nothing on another workspace, got %+v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNATSPubSub_TopicsAreSubjectSafe(t *testing.T) {
+	url := runNATSServer(t)
+	ps := newNATSPubSub(t, url)
+
+	// "a.b" and "a.*" must neither be invalid subjects nor match each other.
+	rxDots, unsub1 := ps.Subscribe("a.b")
+	defer unsub1()
+	rxWild, unsub2 := ps.Subscribe("a.*")
+	defer unsub2()
+
+	ps.Publish("a.b", pubsub.Message{Topic: "a.b", Payload: []byte("dots")})
+	if m := receive(t, rxDots); string(m.Payload) != "dots" {
+		t.Errorf("Expected 'dots', got %q", m.Payload)
+	}
+	select {
+	case m := <-rxWild:
+		t.Errorf("Expected nothing on 'a.*', got %+v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNATSPubSub_UnsubscribeReleasesSubject(t *testing.T) {
+	url := runNATSServer(t)
+	ps := newNATSPubSub(t, url)
+
+	rx1, unsub1 := ps.Subscribe("ws-1")
+	rx2, unsub2 := ps.Subscribe("ws-1")
+	defer unsub2()
+
+	unsub1()
+	unsub1() // Idempotent
+	ps.Publish("ws-1", pubsub.Message{Topic: "ws-1", Payload: []byte("still here")})
+	if m := receive(t, rx2); string(m.Payload) != "still here" {
+		t.Errorf("Expected remaining subscriber to receive, got %q", m.Payload)
+	}
+	if _, ok := <-rx1; ok {
+		t.Error("Expected unsubscribed channel to be closed")
+	}
+	if stats := ps.Stats(); stats.TotalSubscribers != 1 {
+		t.Errorf("Expected 1 subscriber, got %d", stats.TotalSubscribers)
+	}
+}
+
+func TestNATSPubSub_ConcurrentSubscribers(t *testing.T) {
+	url := runNATSServer(t)
+	server1, server2 := newNATSPubSub(t, url), newNATSPubSub(t, url)
+
+	// Subscribers racing the first one for a topic return only once the
+	// shared NATS subscription is registered.
+	const n = 8
+	rxs := make([]<-chan pubsub.Message, n)
+	var wg sync.WaitGroup
+	for i := range rxs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rx, unsub := server2.Subscribe("ws-1")
+			t.Cleanup(unsub)
+			rxs[i] = rx
+		}(i)
+	}
+	wg.Wait()
+
+	server1.Publish("ws-1", pubsub.Message{Topic: "ws-1", Payload: []byte("hello")})
+	for i, rx := range rxs {
+		if m := receive(t, rx); string(m.Payload) != "hello" {
+			t.Errorf("subscriber %d: expected hello, got %q", i, m.Payload)
+		}
+	}
+}
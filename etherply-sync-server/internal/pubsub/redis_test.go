@@ -0,0 +1,100 @@
+package pubsub_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bneb/etherply/etherply-sync-server/internal/pubsub"
+)
+
+func newRedisPubSub(t *testing.T, url string) *pubsub.RedisPubSub {
+	t.Helper()
+	ps, err := pubsub.NewRedisPubSub(pubsub.RedisConfig{URL: url})
+	if err != nil {
+		t.Fatalf("NewRedisPubSub failed: %v", err)
+	}
+	t.Cleanup(func() { ps.Close() })
+	return ps
+}
+
+func TestRedisPubSub_FanOutAcrossInstances(t *testing.T) {
+	url := "redis://" + miniredis.RunT(t).Addr()
+	server1, server2 := newRedisPubSub(t, url), newRedisPubSub(t, url)
+
+	rx1, unsub1 := server1.Subscribe("ws-1")
+	defer unsub1()
+	rx2, unsub2 := server2.Subscribe("ws-1")
+	defer unsub2()
+	other, unsubOther := server2.Subscribe("ws-2")
+	defer unsubOther()
+
+	server1.Publish("ws-1", pubsub.Message{Topic: "ws-1", Payload: []byte("hello"), SenderID: "session-a"})
+
+	for name, rx := range map[string]<-chan pubsub.Message{"server1": rx1, "server2": rx2} {
+		m := receive(t, rx)
+		if string(m.Payload) != "hello" || m.SenderID != "session-a" || m.Topic != "ws-1" {
+			t.Errorf("%s: expected hello from session-a, got %+v", name, m)
+		}
+	}
+	select {
+	case m := <-other:
+		t.Errorf("Expected nothing on another workspace, got %+v", m)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRedisPubSub_UnsubscribeReleasesChannel(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ps := newRedisPubSub(t, "redis://"+mr.Addr())
+
+	rx1, unsub1 := ps.Subscribe("ws-1")
+	rx2, unsub2 := ps.Subscribe("ws-1")
+
+	unsub1()
+	unsub1() // Idempotent
+	ps.Publish("ws-1", pubsub.Message{Topic: "ws-1", Payload: []byte("still here")})
+	if m := receive(t, rx2); string(m.Payload) != "still here" {
+		t.Errorf("Expected remaining subscriber to receive, got %q", m.Payload)
+	}
+	if _, ok := <-rx1; ok {
+		t.Error("Expected unsubscribed channel to be closed")
+	}
+
+	unsub2()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(mr.PubSubChannels("")) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected no Redis channels after the last unsubscribe, got %v", mr.PubSubChannels(""))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedisPubSub_FailedSubscribeDoesNotBlock(t *testing.T) {
+	ps, err := pubsub.NewRedisPubSub(pubsub.RedisConfig{URL: "redis://" + miniredis.RunT(t).Addr()})
+	if err != nil {
+		t.Fatalf("NewRedisPubSub failed: %v", err)
+	}
+	ps.Close() // Every Redis subscribe now fails
+
+	_, unsub1 := ps.Subscribe("ws-1")
+	defer unsub1()
+
+	start := time.Now()
+	_, unsub2 := ps.Subscribe("ws-1")
+	defer unsub2()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected a later subscriber not to wait for the failed subscription, took %s", elapsed)
+	}
+}
+
+func TestNewRedisPubSub_Unreachable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	url := "redis://" + mr.Addr()
+	mr.Close()
+
+	if _, err := pubsub.NewRedisPubSub(pubsub.RedisConfig{URL: url}); err == nil {
+		t.Error("Expected an error for an unreachable Redis")
+	}
+}
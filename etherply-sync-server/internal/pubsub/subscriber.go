@@ -0,0 +1,125 @@
+package pubsub
+
+import (
+	"sync"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/metrics"
+)
+
+// subscriber queues messages for one subscription and hands them to its
+// channel from its own goroutine, so Publish never blocks on a slow reader
+// and always knows exactly how far behind the reader is.
+type subscriber struct {
+	topic  string
+	config *MemoryConfig
+
+	mu    sync.Mutex
+	queue []Message
+	// dead is set once the overflow marker of OverflowDisconnect is queued;
+	// the reader is about to be disconnected and gets nothing else.
+	dead bool
+	// resyncing is set while a resync marker is queued. Messages published
+	// meanwhile are dropped: the state fetched after the marker includes them.
+	resyncing bool
+
+	wake chan struct{} // signals run that queue is non-empty
+	out  chan Message
+	done chan struct{}
+	once sync.Once
+}
+
+func newSubscriber(topic string, cfg *MemoryConfig) *subscriber {
+	s := &subscriber{
+		topic:  topic,
+		config: cfg,
+		wake:   make(chan struct{}, 1),
+		out:    make(chan Message),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// run delivers queued messages in order until the subscriber is closed.
+func (s *subscriber) run() {
+	defer close(s.out)
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.mu.Unlock()
+			select {
+			case <-s.wake:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		msg := s.queue[0]
+		s.queue[0] = Message{}
+		s.queue = s.queue[1:]
+		if msg.Kind == MessageResync {
+			s.resyncing = false
+		}
+		s.mu.Unlock()
+
+		select {
+		case s.out <- msg:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// close stops delivery and closes the subscriber's channel.
+func (s *subscriber) close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// deliver queues msg, applying the overflow policy when the reader is
+// BufferSize messages behind.
+func (s *subscriber) deliver(msg Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.dead || s.resyncing {
+		s.dropped(1)
+		return
+	}
+
+	limit := s.config.BufferSize
+	if s.config.Overflow == OverflowSpill {
+		limit += s.config.SpillSize
+	}
+	if len(s.queue) < limit {
+		if len(s.queue) >= s.config.BufferSize {
+			metrics.PubSubSpilled.WithLabelValues(s.topic).Inc()
+		}
+		s.push(msg)
+		return
+	}
+
+	// The reader is too far behind. Everything still queued is dropped along
+	// with msg and replaced by a single marker telling it how to recover.
+	s.dropped(len(s.queue) + 1)
+	s.queue = s.queue[:0]
+	if s.config.Overflow == OverflowDisconnect {
+		s.dead = true
+		s.push(Message{Topic: s.topic, Kind: MessageOverflow})
+		return
+	}
+	// OverflowResync, and OverflowSpill once the spill queue is full too.
+	s.resyncing = true
+	s.push(Message{Topic: s.topic, Kind: MessageResync})
+}
+
+func (s *subscriber) push(msg Message) {
+	s.queue = append(s.queue, msg)
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscriber) dropped(n int) {
+	metrics.PubSubDropped.WithLabelValues(s.topic, string(s.config.Overflow)).Add(float64(n))
+}
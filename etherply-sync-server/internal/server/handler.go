@@ -8,9 +8,11 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,6 +25,7 @@ import (
 	"github.com/bneb/etherply/etherply-sync-server/internal/presence"
 	"github.com/bneb/etherply/etherply-sync-server/internal/pubsub"
 	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
 	"github.com/bneb/etherply/etherply-sync-server/internal/webhook"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -43,19 +46,120 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all origins for Demo/DX
 	},
+	// Offered to clients that request it; plain JSON otherwise.
+	Subprotocols: []string{automergeSyncProtocol},
+}
+
+// canWrite reports whether the request's token allows mutations.
+// Legacy/Dev: If no scopes defined in token, allow all.
+// If scopes defined, must have "write" (or "admin").
+func canWrite(r *http.Request) bool {
+	scopes := auth.ScopesFromContext(r.Context())
+	if len(scopes) == 0 {
+		return true
+	}
+	for _, s := range scopes {
+		if s == "write" || s == "admin" {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessWorkspace reports whether the request's token covers workspaceID.
+// Tokens without a workspaces claim, like unauthenticated requests, cover all.
+func canAccessWorkspace(r *http.Request, workspaceID string) bool {
+	patterns, limited := auth.WorkspacesFromContext(r.Context())
+	return !limited || auth.WorkspaceAllowed(patterns, workspaceID)
+}
+
+// requireWorkspace responds 403 and returns false unless the request may
+// access workspaceID.
+func (h *Handler) requireWorkspace(w http.ResponseWriter, r *http.Request, workspaceID string) bool {
+	if canAccessWorkspace(r, workspaceID) {
+		return true
+	}
+	h.logger.Warn("acl_denied",
+		slog.String("reason", "workspace_not_in_token"),
+		slog.String("workspace_id", workspaceID),
+	)
+	http.Error(w, "Forbidden: token does not grant access to this workspace", http.StatusForbidden)
+	return false
 }
 
 type Handler struct {
 	crdtEngine      *crdt.Engine
-	presenceManager *presence.Manager
+	presenceManager presence.Backend
 	pubsub          pubsub.PubSub
 	webhook         *webhook.Dispatcher
 	store           store.Store
 	metering        metering.Service
 	logger          *slog.Logger
+	config          HandlerConfig
+}
+
+// Presence update defaults.
+const (
+	DefaultPresenceRate     = 20   // Updates per second and connection
+	DefaultPresenceBurst    = 20   // Updates allowed at once before the rate applies
+	DefaultPresenceMaxBytes = 4096 // Largest presence payload
+
+	DefaultPresenceIdleAfter    = time.Minute     // Inactivity before a session turns idle
+	DefaultPresenceOfflineAfter = 5 * time.Minute // Silence, heartbeats included, before a session is removed
+)
+
+// HandlerConfig holds handler configuration.
+type HandlerConfig struct {
+	PresenceRate     float64
+	PresenceBurst    int
+	PresenceMaxBytes int
+
+	PresenceIdleAfter    time.Duration
+	PresenceOfflineAfter time.Duration
+}
+
+// HandlerOption configures the handler.
+type HandlerOption func(*HandlerConfig)
+
+// WithPresenceRate limits each connection to rate presence updates per
+// second, allowing bursts of burst updates.
+func WithPresenceRate(rate float64, burst int) HandlerOption {
+	return func(cfg *HandlerConfig) {
+		cfg.PresenceRate = rate
+		cfg.PresenceBurst = burst
+	}
+}
+
+// WithPresenceMaxBytes sets the largest presence payload accepted.
+func WithPresenceMaxBytes(n int) HandlerOption {
+	return func(cfg *HandlerConfig) {
+		cfg.PresenceMaxBytes = n
+	}
+}
+
+// WithPresenceTTL sets how long a session may go without ops or presence
+// updates before turning idle, and without any message or heartbeat before
+// being removed. Zero disables the transition.
+func WithPresenceTTL(idleAfter, offlineAfter time.Duration) HandlerOption {
+	return func(cfg *HandlerConfig) {
+		cfg.PresenceIdleAfter = idleAfter
+		cfg.PresenceOfflineAfter = offlineAfter
+	}
 }
 
-func NewHandler(e *crdt.Engine, p *presence.Manager, ps pubsub.PubSub, wh *webhook.Dispatcher, s store.Store, m metering.Service) *Handler {
+func NewHandler(e *crdt.Engine, p presence.Backend, ps pubsub.PubSub, wh *webhook.Dispatcher, s store.Store, m metering.Service, opts ...HandlerOption) *Handler {
+	cfg := HandlerConfig{
+		PresenceRate:     DefaultPresenceRate,
+		PresenceBurst:    DefaultPresenceBurst,
+		PresenceMaxBytes: DefaultPresenceMaxBytes,
+
+		PresenceIdleAfter:    DefaultPresenceIdleAfter,
+		PresenceOfflineAfter: DefaultPresenceOfflineAfter,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	// Default to JSON handler for structured output, writing to stderr
 	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
 	return &Handler{
@@ -66,6 +170,7 @@ func NewHandler(e *crdt.Engine, p *presence.Manager, ps pubsub.PubSub, wh *webho
 		store:           s,
 		metering:        m,
 		logger:          logger,
+		config:          cfg,
 	}
 }
 
@@ -77,10 +182,12 @@ func (h *Handler) HandleGetPresence(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	workspaceID := parts[3]
+	if !h.requireWorkspace(w, r, workspaceID) {
+		return
+	}
 
-	users := h.presenceManager.GetUsers(workspaceID)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
+	json.NewEncoder(w).Encode(h.presenceSnapshot(workspaceID))
 }
 
 func (h *Handler) HandleGetStats(w http.ResponseWriter, r *http.Request) {
@@ -111,6 +218,9 @@ func (h *Handler) HandleGetHistory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	workspaceID := parts[3]
+	if !h.requireWorkspace(w, r, workspaceID) {
+		return
+	}
 
 	// Auth check implicitly done by middleware, but scoped check?
 	// If strictly enforcing scopes, we check for "read".
@@ -138,6 +248,9 @@ func (h *Handler) HandleGetUsage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	workspaceID := parts[3]
+	if !h.requireWorkspace(w, r, workspaceID) {
+		return
+	}
 
 	// Parse query params (default to current month)
 	startStr := r.URL.Query().Get("start")
@@ -183,13 +296,47 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	workspaceID := parts[3]
+	if !h.requireWorkspace(w, r, workspaceID) {
+		return
+	}
 
-	// Stub User ID (normally from Auth)
+	// The user is the token's subject. ?userId= is only trusted when the
+	// request was not authenticated (tests, local development); with a token
+	// it may only repeat the subject, so clients cannot impersonate others.
+	// Tokens without a subject identify no one and are refused, rather than
+	// sharing the "anon" user of unauthenticated servers.
 	userID := r.URL.Query().Get("userId")
+	identity, authenticated := auth.IdentityFromContext(r.Context())
+	if authenticated {
+		if identity.UserID == "" {
+			h.logger.Warn("acl_denied", slog.String("reason", "missing_subject"))
+			http.Error(w, "Unauthorized: token has no subject", http.StatusUnauthorized)
+			return
+		}
+		if userID != "" && userID != identity.UserID {
+			h.logger.Warn("acl_denied",
+				slog.String("reason", "user_id_mismatch"),
+				slog.String("user_id", identity.UserID),
+				slog.String("requested_user_id", userID),
+			)
+			http.Error(w, "Forbidden: userId does not match token subject", http.StatusForbidden)
+			return
+		}
+		userID = identity.UserID
+	}
 	if userID == "" {
 		userID = "anon"
 	}
 
+	// clientID scopes op_id deduplication. Unlike the session ID it survives
+	// reconnects, so ops replayed from an offline queue are recognized. It is
+	// chosen by the client, so it is scoped under the user: another user
+	// reusing it cannot get their ops dropped as replays.
+	clientID := userID
+	if id := r.URL.Query().Get("client_id"); id != "" {
+		clientID = userID + "/" + id
+	}
+
 	// Generate unique session ID for this connection (Session Affinity support)
 	// This ID can be used by load balancers for sticky sessions.
 	sessionID := generateSessionID()
@@ -199,17 +346,31 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	responseHeaders := http.Header{}
 	responseHeaders.Set("X-Session-ID", sessionID)
 
-	conn, err := upgrader.Upgrade(w, r, responseHeaders)
+	rawConn, err := upgrader.Upgrade(w, r, responseHeaders)
 	if err != nil {
 		h.logger.Error("ws_upgrade_failed", slog.Any("error", err))
 		return
 	}
+	conn := newWSConn(rawConn)
 
 	// 1. Subscribe to PubSub
 	rxChan, unsub := h.pubsub.Subscribe(workspaceID)
 
-	// Register Connection Presence
-	h.presenceManager.AddUser(workspaceID, presence.User{UserID: userID, Status: presence.StatusOnline})
+	// Register Connection Presence. Every connection is its own session, so a
+	// user with several tabs or devices stays present until the last one leaves.
+	self := presence.Session{
+		SessionID: sessionID,
+		UserID:    userID,
+		Status:    presence.StatusOnline,
+		Name:      identity.Name,
+		Avatar:    identity.Avatar,
+		Device: presence.Device{
+			Name:      r.URL.Query().Get("device"),
+			UserAgent: r.UserAgent(),
+		},
+	}
+	h.publishPresenceEvent(h.presenceManager.AddSession(workspaceID, self), sessionID)
+	h.trackHeartbeats(conn, workspaceID, self)
 
 	// Webhook: client.connected (now includes session_id for affinity tracking)
 	h.webhook.Dispatch("client.connected", map[string]string{
@@ -231,7 +392,7 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		metrics.ConnectedClients.Dec()
 		unsub()
-		h.presenceManager.RemoveUser(workspaceID, userID)
+		h.publishPresenceEvent(h.presenceManager.RemoveSession(workspaceID, userID, sessionID), sessionID)
 		// Webhook: client.disconnected (includes session_id)
 		h.webhook.Dispatch("client.disconnected", map[string]string{
 			"workspace_id": workspaceID,
@@ -241,31 +402,38 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		conn.Close()
 	}()
 
+	if conn.Subprotocol() == automergeSyncProtocol {
+		h.serveAutomergeSync(conn, r, workspaceID, self, rxChan)
+		return
+	}
+
+	// Clients receive their own ops back only if they ask for it with
+	// ?echo=1, e.g. to learn where their op landed in the broadcast order.
+	echo := r.URL.Query().Get("echo") == "1"
+
 	// 2. Start Writer Goroutine (WritePump)
 	// Consumes messages from PubSub and writes to WebSocket
 	go func() {
 		defer conn.Close() // Ensure close if this routine exits
 		for msg := range rxChan {
-			// Don't echo back if senderID matches?
-			// The current impl of PubSub stores SenderID in Message.
-			// But wait, the WebSocket itself doesn't have a unique ID unless we assign one.
-			// `conn` pointer is unique address but not serializable easily.
-			// Using userID? But users can have multiple tabs.
-			// For now, simple echo or filter if we add ID.
+			switch msg.Kind {
+			case pubsub.MessageOverflow:
+				h.disconnectSlowConsumer(conn, workspaceID)
+				return
+			case pubsub.MessageResync:
+				// Broadcasts were dropped; bring the client up to date again.
+				conn.WriteJSON(map[string]interface{}{"type": string(pubsub.MessageResync)})
+				h.sendInitialState(conn, workspaceID, nil)
+				continue
+			}
 
-			// If we want basic functionality: Just echo to all. Client can filter echoes if needed.
-			// But usually we want to avoid echo.
-			// Let's assume we send everything for now (simplest transition).
+			// Messages are published with the sender's session ID. Skipping
+			// them here rather than in Publish works for every PubSub backend.
+			if msg.SenderID == sessionID && (!echo || msg.Kind == pubsub.MessagePresence) {
+				continue
+			}
 
 			// We receive raw bytes in Payload
-			// But WebSocket expects JSON object usually if we rely on `conn.WriteJSON`.
-			// Payload is []byte.
-			// `conn.WriteMessage(websocket.TextMessage, msg.Payload)`
-
-			// Wait, previous broadcast filtered sender.
-			// If we send back to sender, they might apply double or ignore.
-			// Automerge handles idempotency, so echoes are fine logically but wasteful bandwidth.
-
 			err := conn.WriteMessage(websocket.TextMessage, msg.Payload)
 			if err != nil {
 				return // Stop writer if write fails
@@ -277,17 +445,16 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	// 3. Send Initial State
-	snapshot, err := h.crdtEngine.GetFullState(workspaceID)
-	if err == nil {
-		// Wrap in a sync message
-		msg := map[string]interface{}{
-			"type":  "init",
-			"data":  snapshot.Data,
-			"heads": snapshot.Heads,
-		}
-		conn.WriteJSON(msg)
+	// Reconnecting clients pass their last heads to receive only a delta, either
+	// as ?heads=... or, with ?resume=1, in a first {"type":"resume"} message.
+	since := resumeHeads(r)
+	awaitResume := len(since) == 0 && r.URL.Query().Get("resume") == "1"
+	if !awaitResume {
+		h.sendInitialState(conn, workspaceID, since)
 	}
 
+	presenceLimit := h.newPresenceLimiter()
+
 	// 4. Read Loop (Main routine blocks here)
 	for {
 		// Read Message
@@ -303,6 +470,33 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 		// Handle "ping" or "op"
 		msgType, _ := rawMsg["type"].(string)
 
+		if awaitResume {
+			awaitResume = false
+			if msgType != "resume" {
+				// The client did not resume after all; bootstrap it before its message.
+				h.sendInitialState(conn, workspaceID, nil)
+			}
+		}
+		if msgType == "resume" {
+			h.sendInitialState(conn, workspaceID, headsFromMessage(rawMsg))
+			continue
+		}
+
+		if msgType == "presence" {
+			h.handlePresence(conn, workspaceID, self, rawMsg, presenceLimit)
+			continue
+		}
+		if msgType == "op" || msgType == "batch" {
+			h.markActive(workspaceID, self, nil)
+		} else {
+			// Pings and other messages only show that the connection is alive;
+			// a client that just keeps its tab open turns idle.
+			h.markSeen(workspaceID, self)
+		}
+
+		// Clients tag ops and batches with an op_id to receive an ack or error frame for them.
+		opID, _ := rawMsg["op_id"].(string)
+
 		if msgType == "op" {
 			// Parse Operation
 			payloadBytes, _ := json.Marshal(rawMsg["payload"])
@@ -311,44 +505,38 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 			// Process
 			op.WorkspaceID = workspaceID // Force security
+			op.OpID, op.ClientID = opID, clientID
 
 			// ACL Check: "write" scope
-			// Legacy/Dev: If no scopes defined in token, allow all.
-			// If scopes defined, must have "write".
-			scopes := auth.ScopesFromContext(r.Context())
-			if len(scopes) > 0 {
-				canWrite := false
-				for _, s := range scopes {
-					if s == "write" || s == "admin" {
-						canWrite = true
-						break
-					}
-				}
-				if !canWrite {
-					h.logger.Warn("acl_denied", slog.String("reason", "missing_write_scope"), slog.String("user_id", userID))
-					// Send error to client?
-					conn.WriteJSON(map[string]interface{}{
-						"type":    "error",
-						"payload": "permission_denied: missing 'write' scope",
-					})
-					continue
-				}
+			if !canWrite(r) {
+				h.logger.Warn("acl_denied", slog.String("reason", "missing_write_scope"), slog.String("user_id", userID))
+				conn.writeOpError(opID, "permission_denied", "missing 'write' scope", nil)
+				continue
 			}
 
 			timer := prometheus.NewTimer(metrics.OperationDuration)
-			err := h.crdtEngine.ProcessOperation(op)
+			heads, err := h.crdtEngine.ApplyOperation(op)
 			timer.ObserveDuration()
 
+			if errors.Is(err, crdt.ErrDuplicateOp) {
+				h.logger.Info("duplicate_op_dropped", slog.String("op_id", opID), slog.String("client_id", clientID))
+				conn.writeAck(opID, heads, true)
+				continue
+			}
 			if err != nil {
 				h.logger.Error("op_processing_failed", slog.Any("error", err))
+				writeProcessError(conn, opID, err)
 				continue
 			}
 
 			// Webhook: doc.updated
+			mutation := op.Mutation()
 			h.webhook.Dispatch("doc.updated", map[string]string{
 				"workspace_id": workspaceID,
 				"user_id":      userID,
-				"key":          op.Key,
+				"key":          mutation.Key(),
+				"op":           string(mutation.Kind),
+				"path":         mutation.PathString(),
 			})
 
 			// Broadcast via PubSub
@@ -356,10 +544,97 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 			fullMsgBytes, _ := json.Marshal(rawMsg)
 
 			h.pubsub.Publish(workspaceID, pubsub.Message{
-				Topic:   workspaceID,
-				Payload: fullMsgBytes,
-				// SenderID: ???
+				Topic:    workspaceID,
+				Payload:  fullMsgBytes,
+				SenderID: sessionID,
+			})
+			if opID != "" {
+				conn.writeAck(opID, heads, false)
+			}
+		}
+
+		if msgType == "batch" {
+			// A batch is all-or-nothing: one commit, one write, one broadcast.
+			payloadBytes, _ := json.Marshal(rawMsg["payload"])
+			var batch crdt.Batch
+			if err := json.Unmarshal(payloadBytes, &batch); err != nil {
+				conn.writeOpError(opID, "invalid_operation", "malformed batch payload", nil)
+				continue
+			}
+			batch.WorkspaceID = workspaceID // Force security
+			batch.OpID, batch.ClientID = opID, clientID
+
+			if !canWrite(r) {
+				h.logger.Warn("acl_denied", slog.String("reason", "missing_write_scope"), slog.String("user_id", userID))
+				conn.writeOpError(opID, "permission_denied", "missing 'write' scope", nil)
+				continue
+			}
+
+			timer := prometheus.NewTimer(metrics.OperationDuration)
+			heads, err := h.crdtEngine.ApplyBatch(batch)
+			timer.ObserveDuration()
+
+			if errors.Is(err, crdt.ErrDuplicateOp) {
+				h.logger.Info("duplicate_op_dropped", slog.String("op_id", opID), slog.String("client_id", clientID))
+				conn.writeAck(opID, heads, true)
+				continue
+			}
+			if err != nil {
+				h.logger.Error("batch_processing_failed", slog.Any("error", err), slog.Int("ops", len(batch.Ops)))
+				writeProcessError(conn, opID, err)
+				continue
+			}
+
+			h.webhook.Dispatch("doc.updated", map[string]string{
+				"workspace_id": workspaceID,
+				"user_id":      userID,
+				"op":           "batch",
+				"ops":          strconv.Itoa(len(batch.Ops)),
+			})
+
+			fullMsgBytes, _ := json.Marshal(rawMsg)
+			h.pubsub.Publish(workspaceID, pubsub.Message{
+				Topic:    workspaceID,
+				Payload:  fullMsgBytes,
+				SenderID: sessionID,
 			})
+			if opID != "" {
+				conn.writeAck(opID, heads, false)
+			}
 		}
 	}
 }
+
+// writeProcessError answers a failed op or batch with an error frame. Errors
+// the client can act on carry their message; internal failures stay
+// server-side and are reported as "internal_error".
+func writeProcessError(conn *wsConn, opID string, err error) {
+	var schemaErr *crdt.SchemaError
+	var conflict *crdt.ConflictError
+	switch {
+	case errors.As(err, &conflict):
+		conn.writeOpError(opID, "conflict", err.Error(), conflict)
+	case errors.As(err, &schemaErr):
+		conn.writeOpError(opID, "schema_violation", err.Error(), schemaErr.Violations)
+	case errors.Is(err, sync.ErrUnsupportedOp):
+		conn.writeOpError(opID, "unsupported_operation", err.Error(), nil)
+	case errors.Is(err, crdt.ErrInvalidOperation), errors.Is(err, sync.ErrInvalidMutation):
+		conn.writeOpError(opID, "invalid_operation", err.Error(), nil)
+	case errors.Is(err, crdt.ErrTimestampOutOfRange):
+		conn.writeOpError(opID, "timestamp_rejected", err.Error(), nil)
+	default:
+		conn.writeOpError(opID, "internal_error", "operation was not applied", nil)
+	}
+}
+
+// CloseSlowConsumer is the WebSocket close code sent to clients that fell too
+// far behind the workspace broadcast under the "disconnect" overflow policy.
+// Clients should reconnect, which brings them up to date.
+const CloseSlowConsumer = 4008
+
+// disconnectSlowConsumer closes conn with CloseSlowConsumer.
+func (h *Handler) disconnectSlowConsumer(conn *wsConn, workspaceID string) {
+	h.logger.Warn("slow_consumer_disconnected", slog.String("workspace_id", workspaceID))
+	conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(CloseSlowConsumer, "slow consumer"))
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/presence"
+	"github.com/bneb/etherply/etherply-sync-server/internal/pubsub"
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+// newPresenceLimiter returns the presence rate limiter of one connection.
+func (h *Handler) newPresenceLimiter() *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(h.config.PresenceRate), h.config.PresenceBurst)
+}
+
+// handlePresence applies a {"type":"presence","payload":{...}} message.
+//
+// The payload is user-defined (cursor, selection, ...) and replaces the
+// presence state of the sending session; a message without payload is a
+// heartbeat that only marks it as seen. The update is broadcast to the
+// workspace as
+//
+//	{"type":"presence","user_id":"...","session_id":"...","payload":{...},"last_seen":<unix ms>}
+//
+// but never reaches the CRDT store. Presence only needs the "read" scope.
+func (h *Handler) handlePresence(conn *wsConn, workspaceID string, self presence.Session, msg map[string]interface{}, limiter *rate.Limiter) {
+	if !limiter.Allow() {
+		conn.writeError("rate_limited", fmt.Sprintf("presence updates are limited to %g per second", h.config.PresenceRate))
+		return
+	}
+
+	payload, ok := msg["payload"]
+	if !ok {
+		// A heartbeat: the session is alive, but not active.
+		h.markSeen(workspaceID, self)
+		return
+	}
+	state, _ := json.Marshal(payload)
+	if len(state) > h.config.PresenceMaxBytes {
+		conn.writeError("presence_too_large", fmt.Sprintf("presence payload exceeds %d bytes", h.config.PresenceMaxBytes))
+		return
+	}
+
+	session := h.markActive(workspaceID, self, state)
+
+	frame, _ := json.Marshal(map[string]interface{}{
+		"type":       "presence",
+		"user_id":    session.UserID,
+		"session_id": session.SessionID,
+		"payload":    session.State,
+		"last_seen":  session.LastSeen,
+	})
+	if err := h.pubsub.Publish(workspaceID, pubsub.Message{
+		Topic:    workspaceID,
+		Payload:  frame,
+		SenderID: self.SessionID,
+		Kind:     pubsub.MessagePresence,
+	}); err != nil {
+		h.logger.Warn("presence_broadcast_failed",
+			slog.String("workspace_id", workspaceID),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// markActive records activity of the session self (an op, a batch or a
+// presence update), setting its presence state unless state is nil. A session
+// that was removed after a long silence joins again.
+func (h *Handler) markActive(workspaceID string, self presence.Session, state json.RawMessage) presence.Session {
+	session, event, ok := h.presenceManager.UpdateSession(workspaceID, self.UserID, self.SessionID, state)
+	if !ok {
+		h.publishPresenceEvent(h.presenceManager.AddSession(workspaceID, self), self.SessionID)
+		session, event, _ = h.presenceManager.UpdateSession(workspaceID, self.UserID, self.SessionID, state)
+	}
+	h.publishPresenceEvent(event, self.SessionID)
+	return session
+}
+
+// markSeen records that the session self is alive without counting it as
+// activity. A session that was removed after a long silence joins again, as
+// idle.
+func (h *Handler) markSeen(workspaceID string, self presence.Session) {
+	if h.presenceManager.TouchSession(workspaceID, self.UserID, self.SessionID) {
+		return
+	}
+	self.Status = presence.StatusIdle
+	h.publishPresenceEvent(h.presenceManager.AddSession(workspaceID, self), self.SessionID)
+}
+
+// trackHeartbeats marks the session self as seen on WebSocket ping and pong
+// control frames, so that clients can keep a session alive without sending
+// messages.
+func (h *Handler) trackHeartbeats(conn *wsConn, workspaceID string, self presence.Session) {
+	conn.SetPingHandler(func(appData string) error {
+		h.markSeen(workspaceID, self)
+		// Answer like the default handler of gorilla/websocket.
+		err := conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(time.Second))
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			return nil
+		}
+		return err
+	})
+	conn.SetPongHandler(func(string) error {
+		h.markSeen(workspaceID, self)
+		return nil
+	})
+}
+
+// publishPresenceEvent broadcasts event to the workspace, e.g.
+//
+//	{"type":"presence.status_changed","workspace_id":"...","user":{...},"prev_status":"online"}
+//
+// and dispatches it to webhooks. It is not sent back to sessionID, the
+// session that caused it; sweeper events have no sender. A nil event is ignored.
+func (h *Handler) publishPresenceEvent(event *presence.Event, sessionID string) {
+	if event == nil {
+		return
+	}
+
+	frame, _ := json.Marshal(event)
+	if err := h.pubsub.Publish(event.WorkspaceID, pubsub.Message{
+		Topic:    event.WorkspaceID,
+		Payload:  frame,
+		SenderID: sessionID,
+		Kind:     pubsub.MessagePresence,
+	}); err != nil {
+		h.logger.Warn("presence_broadcast_failed",
+			slog.String("workspace_id", event.WorkspaceID),
+			slog.Any("error", err),
+		)
+	}
+
+	h.webhook.Dispatch(event.Type, map[string]string{
+		"workspace_id": event.WorkspaceID,
+		"user_id":      event.User.UserID,
+		"status":       event.User.Status,
+		"prev_status":  event.PrevStatus,
+	})
+}
+
+// RunPresenceSweeper applies the presence TTLs every interval: sessions that
+// sent nothing for the idle TTL turn idle, and sessions silent for the offline
+// TTL are removed, which also clears connections that died without closing. It blocks until ctx is cancelled.
+func (h *Handler) RunPresenceSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			events := h.presenceManager.Sweep(now, h.config.PresenceIdleAfter, h.config.PresenceOfflineAfter)
+			for i := range events {
+				h.publishPresenceEvent(&events[i], "")
+			}
+		}
+	}
+}
+
+// presenceSnapshot lists the users present in a workspace, with their
+// sessions, for init and delta messages and GET /v1/presence. It is never null.
+func (h *Handler) presenceSnapshot(workspaceID string) []presence.User {
+	users := h.presenceManager.GetUsers(workspaceID)
+	if users == nil {
+		users = []presence.User{}
+	}
+	return users
+}
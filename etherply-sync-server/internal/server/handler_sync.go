@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	gosync "sync"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/metering"
+	"github.com/bneb/etherply/etherply-sync-server/internal/metrics"
+	"github.com/bneb/etherply/etherply-sync-server/internal/presence"
+	"github.com/bneb/etherply/etherply-sync-server/internal/pubsub"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+	"github.com/gorilla/websocket"
+)
+
+// automergeSyncProtocol is the WebSocket subprotocol for binary Automerge sync.
+//
+// Clients opt in by offering it in Sec-WebSocket-Protocol. Instead of JSON ops
+// and full-state init messages, both sides then exchange binary frames holding
+// Automerge sync messages, so a client only receives the changes it is missing.
+const automergeSyncProtocol = "etherply.automerge-sync.v1"
+
+// serveAutomergeSync runs a connection that negotiated automergeSyncProtocol.
+//
+// Every PubSub message for the workspace is treated as "the document changed":
+// the write pump then generates the next sync message from this connection's
+// sync state. Changes received from the client are announced to JSON clients
+// with a "state" frame carrying the new document state.
+func (h *Handler) serveAutomergeSync(conn *wsConn, r *http.Request, workspaceID string, self presence.Session, rxChan <-chan pubsub.Message) {
+	session, err := h.crdtEngine.NewSyncSession(workspaceID)
+	if err != nil {
+		h.logger.Warn("sync_session_failed",
+			slog.String("workspace_id", workspaceID),
+			slog.Any("error", err),
+		)
+		conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseUnsupportedData, err.Error()))
+		return
+	}
+
+	// Generating and writing must happen as one step, or two goroutines could
+	// send sync messages out of order.
+	var sendMu gosync.Mutex
+	sendNext := func() error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+
+		msg, ok, err := session.Generate()
+		if err != nil || !ok {
+			return err
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+			return err
+		}
+		metrics.MessagesBroadcast.Inc()
+		h.metering.Record(workspaceID, metering.MetricMessagesSent, 1)
+		return nil
+	}
+
+	go func() {
+		defer conn.Close()
+		for msg := range rxChan {
+			if msg.Kind == pubsub.MessageOverflow {
+				h.disconnectSlowConsumer(conn, workspaceID)
+				return
+			}
+			// A resync marker needs no special care: the sync state already
+			// knows what the client is missing.
+			if err := sendNext(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Open the exchange; the client replies with what it has.
+	if err := sendNext(); err != nil {
+		return
+	}
+
+	for {
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		metrics.MessagesReceived.Inc()
+		h.metering.Record(workspaceID, metering.MetricMessagesReceived, 1)
+		h.markSeen(workspaceID, self)
+
+		if msgType != websocket.BinaryMessage {
+			conn.writeError("invalid_message", "expected a binary sync message")
+			continue
+		}
+
+		// Read-only clients may sync down, but not push changes.
+		if !canWrite(r) {
+			hasChanges, err := sync.SyncMessageHasChanges(data)
+			if err != nil {
+				conn.writeError("invalid_sync_message", err.Error())
+				continue
+			}
+			if hasChanges {
+				h.logger.Warn("acl_denied", slog.String("reason", "missing_write_scope"), slog.String("user_id", self.UserID))
+				conn.writeError("permission_denied", "missing 'write' scope")
+				continue
+			}
+		}
+
+		changed, err := session.Receive(data)
+		if err != nil {
+			h.logger.Error("sync_message_failed",
+				slog.String("workspace_id", workspaceID),
+				slog.Any("error", err),
+			)
+			var schemaErr *crdt.SchemaError
+			switch {
+			case errors.As(err, &schemaErr):
+				conn.writeOpError("", "schema_violation", err.Error(), schemaErr.Violations)
+			case !errors.Is(err, crdt.ErrSyncUnsupported):
+				conn.writeError("invalid_sync_message", err.Error())
+			}
+			continue
+		}
+
+		if changed {
+			// Only messages carrying changes count as activity.
+			h.markActive(workspaceID, self, nil)
+			h.webhook.Dispatch("doc.updated", map[string]string{
+				"workspace_id": workspaceID,
+				"user_id":      self.UserID,
+				"op":           "sync",
+			})
+			h.publishState(workspaceID)
+		}
+
+		if err := sendNext(); err != nil {
+			break
+		}
+	}
+}
+
+// publishState broadcasts the current document state after changes that did
+// not arrive as a JSON op. Sync connections only use it as a change signal.
+func (h *Handler) publishState(workspaceID string) {
+	snapshot, err := h.crdtEngine.GetFullState(workspaceID)
+	if err != nil {
+		h.logger.Error("state_broadcast_failed",
+			slog.String("workspace_id", workspaceID),
+			slog.Any("error", err),
+		)
+		return
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type":    "state",
+		"data":    snapshot.Data,
+		"heads":   snapshot.Heads,
+		"version": snapshot.Version,
+	})
+	h.pubsub.Publish(workspaceID, pubsub.Message{
+		Topic:   workspaceID,
+		Payload: payload,
+	})
+}
@@ -0,0 +1,412 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/auth"
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/presence"
+	"github.com/bneb/etherply/etherply-sync-server/internal/pubsub"
+	"github.com/bneb/etherply/etherply-sync-server/internal/server"
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+	"github.com/bneb/etherply/etherply-sync-server/internal/webhook"
+	"github.com/gorilla/websocket"
+)
+
+func TestHandleWebSocket_NestedOpAndErrorFrame(t *testing.T) {
+	engine, _, handler := createTestHandlerWithComponents()
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-nested?echo=1", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var initMsg map[string]interface{}
+	if err := conn.ReadJSON(&initMsg); err != nil || initMsg["type"] != "init" {
+		t.Fatalf("Expected init message, got %v (err %v)", initMsg, err)
+	}
+
+	// A valid nested op is applied and broadcast back to the workspace.
+	conn.WriteJSON(map[string]interface{}{
+		"type":    "op",
+		"payload": map[string]interface{}{"op": "insert", "path": []interface{}{"todos", 0}, "value": "write tests"},
+	})
+	var echo map[string]interface{}
+	if err := conn.ReadJSON(&echo); err != nil || echo["type"] != "op" {
+		t.Fatalf("Expected op broadcast, got %v (err %v)", echo, err)
+	}
+
+	snapshot, _ := engine.GetFullState("ws-nested")
+	if todos, _ := snapshot.Data["todos"].([]interface{}); len(todos) != 1 || todos[0] != "write tests" {
+		t.Errorf("Expected todos [write tests], got %v", snapshot.Data["todos"])
+	}
+
+	// A malformed op is answered with an error frame instead of being dropped silently.
+	conn.WriteJSON(map[string]interface{}{
+		"type":    "op",
+		"payload": map[string]interface{}{"op": "insert", "path": []interface{}{"todos", "x"}, "value": 1},
+	})
+	var errMsg map[string]interface{}
+	if err := conn.ReadJSON(&errMsg); err != nil {
+		t.Fatalf("Failed to read error frame: %v", err)
+	}
+	payload, _ := errMsg["payload"].(string)
+	if errMsg["type"] != "error" || !strings.HasPrefix(payload, "invalid_operation:") {
+		t.Errorf("Expected invalid_operation error frame, got %v", errMsg)
+	}
+}
+
+func TestHandleWebSocket_TimestampRejected(t *testing.T) {
+	memStore := store.NewMemoryStore()
+	engine := crdt.NewEngine(memStore, crdt.WithTimestampPolicy(crdt.TimestampPolicy{
+		Action:  crdt.TimestampReject,
+		MaxSkew: time.Minute,
+	}))
+	handler := server.NewHandler(engine, presence.NewManager(), pubsub.NewMemoryPubSub(), webhook.NewDispatcher(""), nil, &MockMeteringService{})
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-skew", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var initMsg map[string]interface{}
+	conn.ReadJSON(&initMsg)
+
+	conn.WriteJSON(map[string]interface{}{
+		"type":    "op",
+		"payload": map[string]interface{}{"key": "k", "value": "v", "timestamp": time.Now().Add(time.Hour).UnixMicro()},
+	})
+	var errMsg map[string]interface{}
+	if err := conn.ReadJSON(&errMsg); err != nil {
+		t.Fatalf("Failed to read error frame: %v", err)
+	}
+	payload, _ := errMsg["payload"].(string)
+	if errMsg["type"] != "error" || !strings.HasPrefix(payload, "timestamp_rejected:") {
+		t.Errorf("Expected timestamp_rejected error frame, got %v", errMsg)
+	}
+}
+
+func TestHandleWebSocket_Batch(t *testing.T) {
+	engine, _, handler := createTestHandlerWithComponents()
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-batch?echo=1", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var initMsg map[string]interface{}
+	conn.ReadJSON(&initMsg)
+
+	// A valid batch is applied and broadcast as one message.
+	conn.WriteJSON(map[string]interface{}{
+		"type": "batch",
+		"payload": map[string]interface{}{"ops": []interface{}{
+			map[string]interface{}{"key": "a", "value": 1},
+			map[string]interface{}{"key": "b", "value": 2},
+		}},
+	})
+	var echo map[string]interface{}
+	if err := conn.ReadJSON(&echo); err != nil || echo["type"] != "batch" {
+		t.Fatalf("Expected batch broadcast, got %v (err %v)", echo, err)
+	}
+	snapshot, _ := engine.GetFullState("ws-batch")
+	if len(snapshot.Data) != 2 {
+		t.Errorf("Expected 2 keys, got %v", snapshot.Data)
+	}
+
+	// A batch with one bad op is rejected as a whole.
+	conn.WriteJSON(map[string]interface{}{
+		"type": "batch",
+		"payload": map[string]interface{}{"ops": []interface{}{
+			map[string]interface{}{"key": "c", "value": 3},
+			map[string]interface{}{"op": "insert", "path": []interface{}{"todos", "x"}, "value": 1},
+		}},
+	})
+	var errMsg map[string]interface{}
+	if err := conn.ReadJSON(&errMsg); err != nil {
+		t.Fatalf("Failed to read error frame: %v", err)
+	}
+	payload, _ := errMsg["payload"].(string)
+	if errMsg["type"] != "error" || !strings.HasPrefix(payload, "invalid_operation:") {
+		t.Errorf("Expected invalid_operation error frame, got %v", errMsg)
+	}
+	if snapshot, _ := engine.GetFullState("ws-batch"); snapshot.Data["c"] != nil {
+		t.Errorf("Expected no partial write, got %v", snapshot.Data)
+	}
+}
+
+func TestHandleWebSocket_ConditionalWriteConflict(t *testing.T) {
+	engine, _, handler := createTestHandlerWithComponents()
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-cas", Key: "stock", Value: 5})
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-cas", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var initMsg map[string]interface{}
+	conn.ReadJSON(&initMsg)
+	if version, _ := initMsg["version"].(string); version == "" {
+		t.Fatalf("Expected version in init message, got %v", initMsg)
+	}
+
+	conn.WriteJSON(map[string]interface{}{
+		"type":    "op",
+		"payload": map[string]interface{}{"key": "stock", "value": 4, "if_version": "stale"},
+	})
+	var errMsg map[string]interface{}
+	if err := conn.ReadJSON(&errMsg); err != nil {
+		t.Fatalf("Failed to read error frame: %v", err)
+	}
+	details, _ := errMsg["details"].(map[string]interface{})
+	if errMsg["code"] != "conflict" || details["current"] != float64(5) || details["version"] != initMsg["version"] {
+		t.Errorf("Expected conflict frame with current value 5, got %v", errMsg)
+	}
+}
+
+func TestHandleWebSocket_AckAndErrorFrames(t *testing.T) {
+	engine, _, handler := createTestHandlerWithComponents()
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-ack", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var initMsg map[string]interface{}
+	conn.ReadJSON(&initMsg)
+
+	// readType skips broadcasts until a frame of the given type arrives.
+	readType := func(msgType string) map[string]interface{} {
+		t.Helper()
+		for {
+			var msg map[string]interface{}
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("Failed waiting for %s frame: %v", msgType, err)
+			}
+			if msg["type"] == msgType {
+				return msg
+			}
+		}
+	}
+
+	conn.WriteJSON(map[string]interface{}{
+		"type":    "op",
+		"op_id":   "op-1",
+		"payload": map[string]interface{}{"key": "k", "value": "v"},
+	})
+	ack := readType("ack")
+	snapshot, _ := engine.GetFullState("ws-ack")
+	heads, _ := ack["heads"].([]interface{})
+	if ack["op_id"] != "op-1" || len(heads) != len(snapshot.Heads) || heads[0] != snapshot.Heads[0] {
+		t.Errorf("Expected ack for op-1 with heads %v, got %v", snapshot.Heads, ack)
+	}
+
+	conn.WriteJSON(map[string]interface{}{
+		"type":    "batch",
+		"op_id":   "op-2",
+		"payload": map[string]interface{}{"ops": []interface{}{map[string]interface{}{"op": "bogus", "key": "k"}}},
+	})
+	errMsg := readType("error")
+	if errMsg["op_id"] != "op-2" || errMsg["code"] != "invalid_operation" || errMsg["reason"] == "" {
+		t.Errorf("Expected invalid_operation error for op-2, got %v", errMsg)
+	}
+}
+
+func TestHandleWebSocket_InvalidMutationReported(t *testing.T) {
+	engine := crdt.NewEngine(store.NewMemoryStore(), crdt.WithStrategy(sync.NewServerAuthStrategy()))
+	handler := server.NewHandler(engine, presence.NewManager(), pubsub.NewMemoryPubSub(), webhook.NewDispatcher(""), nil, &MockMeteringService{})
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-invalid", Key: "title", Value: "hello"})
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-invalid", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var initMsg map[string]interface{}
+	conn.ReadJSON(&initMsg)
+
+	// Incrementing a string is the client's mistake, not a server failure.
+	conn.WriteJSON(map[string]interface{}{
+		"type":    "op",
+		"op_id":   "op-1",
+		"payload": map[string]interface{}{"op": "increment", "path": []interface{}{"title"}, "value": 1},
+	})
+	var errMsg map[string]interface{}
+	if err := conn.ReadJSON(&errMsg); err != nil {
+		t.Fatalf("Failed to read error frame: %v", err)
+	}
+	reason, _ := errMsg["reason"].(string)
+	if errMsg["code"] != "invalid_operation" || !strings.Contains(reason, "cannot increment") {
+		t.Errorf("Expected invalid_operation error naming the increment, got %v", errMsg)
+	}
+}
+
+func TestHandleWebSocket_DuplicateOpAcked(t *testing.T) {
+	engine, _, handler := createTestHandlerWithComponents()
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	send := func(value string) map[string]interface{} {
+		t.Helper()
+		// Each send is a fresh connection with the same client_id, like an SDK reconnect.
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-dup?client_id=c1", nil)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var initMsg map[string]interface{}
+		conn.ReadJSON(&initMsg)
+
+		conn.WriteJSON(map[string]interface{}{
+			"type":    "op",
+			"op_id":   "op-1",
+			"payload": map[string]interface{}{"key": "k", "value": value},
+		})
+		for {
+			var msg map[string]interface{}
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("Failed waiting for ack: %v", err)
+			}
+			if msg["type"] == "ack" {
+				return msg
+			}
+		}
+	}
+
+	if ack := send("first"); ack["already_applied"] != nil {
+		t.Errorf("Expected first delivery to be applied, got %v", ack)
+	}
+	if ack := send("replayed"); ack["already_applied"] != true {
+		t.Errorf("Expected replay to be acked as already applied, got %v", ack)
+	}
+	if snapshot, _ := engine.GetFullState("ws-dup"); snapshot.Data["k"] != "first" {
+		t.Errorf("Expected k='first', got %v", snapshot.Data["k"])
+	}
+}
+
+func TestHandleWebSocket_ClientIDScopedToUser(t *testing.T) {
+	engine, _, handler := createTestHandlerWithComponents()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := auth.Identity{UserID: r.URL.Query().Get("as")}
+		handler.HandleWebSocket(w, r.WithContext(auth.NewContextWithIdentity(r.Context(), identity)))
+	}))
+	defer s.Close()
+
+	send := func(user, value string) map[string]interface{} {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-scoped?client_id=shared&as="+user, nil)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var initMsg map[string]interface{}
+		conn.ReadJSON(&initMsg)
+
+		conn.WriteJSON(map[string]interface{}{
+			"type":    "op",
+			"op_id":   "op-1",
+			"payload": map[string]interface{}{"key": user, "value": value},
+		})
+		for {
+			var msg map[string]interface{}
+			if err := conn.ReadJSON(&msg); err != nil {
+				t.Fatalf("Failed waiting for ack: %v", err)
+			}
+			if msg["type"] == "ack" {
+				return msg
+			}
+		}
+	}
+
+	send("alice", "a")
+	// Bob picks the same client_id and op_id; his op must still apply.
+	if ack := send("bob", "b"); ack["already_applied"] != nil {
+		t.Errorf("Expected bob's op to be applied, got %v", ack)
+	}
+	if snapshot, _ := engine.GetFullState("ws-scoped"); snapshot.Data["bob"] != "b" {
+		t.Errorf("Expected bob='b', got %v", snapshot.Data)
+	}
+}
+
+func TestHandleWebSocket_EchoSuppression(t *testing.T) {
+	_, _, handler := createTestHandlerWithComponents()
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	dial := func(query string) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-echo"+query, nil)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		var initMsg map[string]interface{}
+		conn.ReadJSON(&initMsg)
+		return conn
+	}
+	sender := dial("")
+	defer sender.Close()
+	peer := dial("")
+	defer peer.Close()
+	echoer := dial("?echo=1")
+	defer echoer.Close()
+
+	sender.WriteJSON(map[string]interface{}{
+		"type":    "op",
+		"op_id":   "op-1",
+		"payload": map[string]interface{}{"key": "k", "value": "v"},
+	})
+
+	// The sender only gets its ack, not its own op.
+	sender.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	for {
+		var msg map[string]interface{}
+		if err := sender.ReadJSON(&msg); err != nil {
+			break
+		}
+		if msg["type"] == "op" {
+			t.Errorf("Expected no echo to the sender, got %v", msg)
+		}
+	}
+
+	// Other sessions receive it; an echo=1 session would get its own ops too.
+	for name, conn := range map[string]*websocket.Conn{"peer": peer, "echoer": echoer} {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var msg map[string]interface{}
+		if err := conn.ReadJSON(&msg); err != nil || msg["type"] != "op" {
+			t.Errorf("%s: expected op broadcast, got %v (err %v)", name, msg, err)
+		}
+	}
+}
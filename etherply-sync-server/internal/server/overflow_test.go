@@ -0,0 +1,77 @@
+package server_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/presence"
+	"github.com/bneb/etherply/etherply-sync-server/internal/pubsub"
+	"github.com/bneb/etherply/etherply-sync-server/internal/server"
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+	"github.com/bneb/etherply/etherply-sync-server/internal/webhook"
+	"github.com/gorilla/websocket"
+)
+
+// newOverflowTestServer returns a server whose PubSub can be used to inject
+// the markers MemoryPubSub generates for slow subscribers.
+func newOverflowTestServer(t *testing.T) (*httptest.Server, *pubsub.MemoryPubSub, *crdt.Engine) {
+	t.Helper()
+	engine := crdt.NewEngine(store.NewMemoryStore())
+	ps := pubsub.NewMemoryPubSub()
+	handler := server.NewHandler(engine, presence.NewManager(), ps, webhook.NewDispatcher(""), nil, &MockMeteringService{})
+	return httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket)), ps, engine
+}
+
+func TestHandleWebSocket_ResyncMarker(t *testing.T) {
+	s, ps, engine := newOverflowTestServer(t)
+	defer s.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-slow", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var initMsg map[string]interface{}
+	conn.ReadJSON(&initMsg)
+
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-slow", Key: "missed", Value: "x"})
+	ps.Publish("ws-slow", pubsub.Message{Topic: "ws-slow", Kind: pubsub.MessageResync})
+
+	var marker, state map[string]interface{}
+	if err := conn.ReadJSON(&marker); err != nil || marker["type"] != "resync_required" {
+		t.Fatalf("Expected resync_required frame, got %v (err %v)", marker, err)
+	}
+	if err := conn.ReadJSON(&state); err != nil || state["type"] != "init" {
+		t.Fatalf("Expected init frame after the marker, got %v (err %v)", state, err)
+	}
+	if data, _ := state["data"].(map[string]interface{}); data["missed"] != "x" {
+		t.Errorf("Expected the fresh state to include missed ops, got %v", state["data"])
+	}
+}
+
+func TestHandleWebSocket_SlowConsumerDisconnected(t *testing.T) {
+	s, ps, _ := newOverflowTestServer(t)
+	defer s.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-slow", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var initMsg map[string]interface{}
+	conn.ReadJSON(&initMsg)
+
+	ps.Publish("ws-slow", pubsub.Message{Topic: "ws-slow", Kind: pubsub.MessageOverflow})
+
+	_, _, err = conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != server.CloseSlowConsumer {
+		t.Errorf("Expected close code %d, got %v", server.CloseSlowConsumer, err)
+	}
+}
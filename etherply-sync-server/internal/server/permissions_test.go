@@ -1,13 +1,16 @@
 package server_test
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/bneb/etherply/etherply-sync-server/internal/auth"
 	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/presence"
 	"github.com/gorilla/websocket"
 )
 
@@ -77,7 +80,7 @@ func TestACL_Enforcement(t *testing.T) {
 
 	// 3. Test Case: Write Token (scope="write")
 	// Should succeed.
-	wsURL2 := "ws" + s.URL[4:] + "/v1/sync/ws-acl-write?mock_scopes=write"
+	wsURL2 := "ws" + s.URL[4:] + "/v1/sync/ws-acl-write?mock_scopes=write&echo=1"
 	conn2, _, err := websocket.DefaultDialer.Dial(wsURL2, nil)
 	if err != nil {
 		t.Fatal(err)
@@ -106,3 +109,142 @@ func TestACL_Enforcement(t *testing.T) {
 		t.Errorf("Expected success, got error response: %v", response)
 	}
 }
+
+func TestIdentity_Enforcement(t *testing.T) {
+	handler := createTestHandler()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Mock Middleware behavior: authenticate as the token subject "alice"
+		ctx := auth.NewContextWithScopes(r.Context(), []string{"read"})
+		ctx = auth.NewContextWithIdentity(ctx, auth.Identity{UserID: "alice", Name: "Alice", Avatar: "https://example.com/a.png"})
+		handler.HandleWebSocket(w, r.WithContext(ctx))
+	}))
+	defer s.Close()
+
+	// 1. Test Case: Impersonation is rejected before the upgrade.
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-identity?userId=mallory", nil)
+	if err == nil {
+		t.Fatal("Expected the connection to be rejected")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %v", resp)
+	}
+
+	// 2. Test Case: Repeating the subject, or omitting userId, is allowed.
+	for _, query := range []string{"?userId=alice", ""} {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-identity"+query, nil)
+		if err != nil {
+			t.Fatalf("Failed to connect with %q: %v", query, err)
+		}
+		defer conn.Close()
+
+		var initMsg struct {
+			Presence []presence.User `json:"presence"`
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to read init: %v", err)
+		}
+		json.Unmarshal(data, &initMsg)
+
+		// 3. The token identity is what others see.
+		if len(initMsg.Presence) != 1 || initMsg.Presence[0].UserID != "alice" || initMsg.Presence[0].Name != "Alice" {
+			t.Errorf("Expected alice from the token in presence, got %+v", initMsg.Presence)
+		}
+	}
+}
+
+func TestIdentity_MissingSubject(t *testing.T) {
+	handler := createTestHandler()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Mock Middleware behavior: a valid token without a "sub" claim
+		ctx := auth.NewContextWithIdentity(r.Context(), auth.Identity{Name: "Nobody"})
+		handler.HandleWebSocket(w, r.WithContext(ctx))
+	}))
+	defer s.Close()
+
+	// Neither the fallback to "anon" nor a chosen userId applies.
+	for _, query := range []string{"", "?userId=anon", "?userId=alice"} {
+		_, resp, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-identity"+query, nil)
+		if err == nil {
+			t.Fatalf("Expected the connection with %q to be rejected", query)
+		}
+		if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 with %q, got %v", query, resp)
+		}
+	}
+}
+
+func TestWorkspaceACL_Enforcement(t *testing.T) {
+	// 1. Setup Test Server
+	handler := createTestHandler()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Mock Middleware behavior: limit the token to one workspace and a prefix
+		ctx := auth.NewContextWithWorkspaces(r.Context(), []string{"ws-allowed", "team-a-*"})
+		r = r.WithContext(ctx)
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/sync/"):
+			handler.HandleWebSocket(w, r)
+		case strings.HasPrefix(r.URL.Path, "/v1/presence/"):
+			handler.HandleGetPresence(w, r)
+		case strings.HasPrefix(r.URL.Path, "/v1/history/"):
+			handler.HandleGetHistory(w, r)
+		case strings.HasPrefix(r.URL.Path, "/v1/usage/"):
+			handler.HandleGetUsage(w, r)
+		case strings.HasPrefix(r.URL.Path, "/v1/workspaces/"):
+			handler.HandleWorkspace(w, r)
+		}
+	}))
+	defer s.Close()
+
+	// 2. Test Case: WebSocket upgrade
+	// Named and wildcard-matched workspaces connect; others are refused.
+	for _, ws := range []string{"ws-allowed", "team-a-docs"} {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/"+ws, nil)
+		if err != nil {
+			t.Errorf("Expected %s to connect, got %v", ws, err)
+			continue
+		}
+		conn.Close()
+	}
+	for _, ws := range []string{"ws-other", "ws-allowed-2", "team-b-docs"} {
+		_, resp, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/"+ws, nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected 403 for %s, got %v (%v)", ws, resp, err)
+		}
+	}
+
+	// 3. Test Case: HTTP routes addressed by workspace
+	for _, route := range []string{"/v1/presence/", "/v1/history/", "/v1/usage/", "/v1/workspaces/"} {
+		resp, err := http.Get(s.URL + route + "ws-other")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected 403 for %sws-other, got %d", route, resp.StatusCode)
+		}
+
+		resp, err = http.Get(s.URL + route + "team-a-docs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusForbidden {
+			t.Errorf("Expected %steam-a-docs to be allowed, got 403", route)
+		}
+	}
+
+	// 4. Test Case: Sub-resources of a workspace are covered too.
+	resp, err := http.Get(s.URL + "/v1/workspaces/ws-other/schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for the schema of ws-other, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,299 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/auth"
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/presence"
+	"github.com/bneb/etherply/etherply-sync-server/internal/pubsub"
+	"github.com/bneb/etherply/etherply-sync-server/internal/server"
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+	"github.com/bneb/etherply/etherply-sync-server/internal/webhook"
+	"github.com/gorilla/websocket"
+)
+
+func dialUser(t *testing.T, s *httptest.Server, workspaceID, userID string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/"+workspaceID+"?userId="+userID, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestHandleWebSocket_PresenceBroadcast(t *testing.T) {
+	engine, _, handler := createTestHandlerWithComponents()
+	// Presence needs no write scope.
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := auth.NewContextWithScopes(r.Context(), []string{"read"})
+		handler.HandleWebSocket(w, r.WithContext(ctx))
+	}))
+	defer s.Close()
+
+	alice := dialUser(t, s, "ws-presence", "alice")
+	readFrame(t, alice) // init
+
+	bob := dialUser(t, s, "ws-presence", "bob")
+	initMsg := readFrame(t, bob)
+	if users, _ := initMsg["presence"].([]interface{}); len(users) != 2 {
+		t.Errorf("Expected 2 users in init presence, got %v", initMsg["presence"])
+	}
+
+	alice.WriteJSON(map[string]interface{}{
+		"type":    "presence",
+		"payload": map[string]interface{}{"cursor": 42},
+	})
+	msg := readFrame(t, bob)
+	payload, _ := msg["payload"].(map[string]interface{})
+	if msg["type"] != "presence" || msg["user_id"] != "alice" || payload["cursor"] != float64(42) {
+		t.Fatalf("Expected alice's cursor at 42, got %v", msg)
+	}
+
+	// Presence never reaches the document.
+	snapshot, _ := engine.GetFullState("ws-presence")
+	if len(snapshot.Data) != 0 {
+		t.Errorf("Expected an empty document, got %v", snapshot.Data)
+	}
+
+	// Late joiners see the current state in their init message.
+	carol := dialUser(t, s, "ws-presence", "carol")
+	initMsg = readFrame(t, carol)
+	users, _ := initMsg["presence"].([]interface{})
+	found := false
+	for _, u := range users {
+		user, _ := u.(map[string]interface{})
+		state, _ := user["state"].(map[string]interface{})
+		if user["user_id"] == "alice" && state["cursor"] == float64(42) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected alice's cursor in init presence, got %v", users)
+	}
+}
+
+func TestHandleWebSocket_PresenceNotEchoed(t *testing.T) {
+	_, _, handler := createTestHandlerWithComponents()
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	// Echoes of ops are opted into; echoes of the sender's own cursor are not.
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-echo-presence?userId=alice&echo=1", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	readFrame(t, conn) // init
+
+	conn.WriteJSON(map[string]interface{}{
+		"type":    "presence",
+		"payload": map[string]interface{}{"cursor": 1},
+	})
+	conn.WriteJSON(map[string]interface{}{
+		"type":    "op",
+		"payload": map[string]interface{}{"key": "k", "value": "v"},
+	})
+	if msg := readFrame(t, conn); msg["type"] == "presence" {
+		t.Errorf("Expected the op echo, got own presence frame %v", msg)
+	}
+}
+
+func TestHandleWebSocket_PresenceLimits(t *testing.T) {
+	engine := crdt.NewEngine(store.NewMemoryStore())
+	handler := server.NewHandler(engine, presence.NewManager(), pubsub.NewMemoryPubSub(), webhook.NewDispatcher(""), nil, &MockMeteringService{},
+		server.WithPresenceRate(1, 2),
+		server.WithPresenceMaxBytes(32),
+	)
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	conn := dialUser(t, s, "ws-limits", "alice")
+	readFrame(t, conn) // init
+
+	conn.WriteJSON(map[string]interface{}{
+		"type":    "presence",
+		"payload": map[string]interface{}{"text": "far more than thirty-two bytes of presence"},
+	})
+	if msg := readFrame(t, conn); msg["code"] != "presence_too_large" {
+		t.Errorf("Expected presence_too_large, got %v", msg)
+	}
+
+	// The oversized update used up one token of the burst of 2.
+	conn.WriteJSON(map[string]interface{}{"type": "presence"})
+	conn.WriteJSON(map[string]interface{}{"type": "presence"})
+	if msg := readFrame(t, conn); msg["code"] != "rate_limited" {
+		t.Errorf("Expected rate_limited, got %v", msg)
+	}
+}
+
+func TestHandleWebSocket_PresenceTTL(t *testing.T) {
+	hooks := make(chan webhook.EventPayload, 32)
+	hookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var evt webhook.EventPayload
+		json.NewDecoder(r.Body).Decode(&evt)
+		select {
+		case hooks <- evt:
+		default:
+		}
+	}))
+	defer hookServer.Close()
+
+	engine := crdt.NewEngine(store.NewMemoryStore())
+	handler := server.NewHandler(engine, presence.NewManager(), pubsub.NewMemoryPubSub(), webhook.NewDispatcher(hookServer.URL), nil, &MockMeteringService{},
+		server.WithPresenceTTL(100*time.Millisecond, 300*time.Millisecond),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handler.RunPresenceSweeper(ctx, 20*time.Millisecond)
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	bob := dialUser(t, s, "ws-ttl", "bob")
+	bob.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var initMsg map[string]interface{}
+	bob.ReadJSON(&initMsg)
+	dialUser(t, s, "ws-ttl", "alice") // Stays silent, like a dead connection
+
+	// Alice joins, turns idle, and is removed; bob's own transitions are skipped.
+	var got []string
+	for len(got) < 3 {
+		var msg struct {
+			Type string        `json:"type"`
+			User presence.User `json:"user"`
+		}
+		if err := bob.ReadJSON(&msg); err != nil {
+			t.Fatalf("Failed to read presence events, got %v so far: %v", got, err)
+		}
+		if msg.User.UserID == "alice" {
+			got = append(got, msg.Type+":"+msg.User.Status)
+		}
+	}
+	want := []string{"presence.joined:online", "presence.status_changed:idle", "presence.left:offline"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected events %v, got %v", want, got)
+			break
+		}
+	}
+
+	// The same events reach webhooks.
+	seen := make(map[string]bool)
+	timeout := time.After(2 * time.Second)
+	for !(seen[presence.EventJoined] && seen[presence.EventStatusChanged] && seen[presence.EventLeft]) {
+		select {
+		case evt := <-hooks:
+			seen[evt.Event] = true
+		case <-timeout:
+			t.Fatalf("Expected presence webhooks, got %v", seen)
+		}
+	}
+}
+
+func TestHandleWebSocket_HeartbeatsAreNotActivity(t *testing.T) {
+	pm := presence.NewManager()
+	handler := server.NewHandler(crdt.NewEngine(store.NewMemoryStore()), pm, pubsub.NewMemoryPubSub(), webhook.NewDispatcher(""), nil, &MockMeteringService{},
+		server.WithPresenceTTL(100*time.Millisecond, 300*time.Millisecond),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handler.RunPresenceSweeper(ctx, 20*time.Millisecond)
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	// An open tab that only sends heartbeats: JSON pings from the JS SDK and
+	// WebSocket pings from the Go SDK.
+	tab := dialUser(t, s, "ws-heartbeat", "alice")
+	readFrame(t, tab) // init
+	go func() {
+		for {
+			if _, _, err := tab.ReadMessage(); err != nil { // Processes pong frames
+				return
+			}
+		}
+	}()
+	for i := 0; i < 12; i++ {
+		if i%2 == 0 {
+			tab.WriteJSON(map[string]interface{}{"type": "ping"})
+		} else {
+			tab.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// 600ms without activity: idle, but still present.
+	users := pm.GetUsers("ws-heartbeat")
+	if len(users) != 1 || users[0].Status != presence.StatusIdle {
+		t.Fatalf("Expected alice to be present and idle, got %+v", users)
+	}
+	if users[0].LastSeen <= users[0].LastActive {
+		t.Errorf("Expected heartbeats to refresh last_seen only, got %+v", users[0])
+	}
+
+	// An op is activity.
+	tab.WriteJSON(map[string]interface{}{
+		"type":    "op",
+		"payload": map[string]interface{}{"key": "k", "value": "v"},
+	})
+	deadline := time.Now().Add(time.Second)
+	for {
+		if users := pm.GetUsers("ws-heartbeat"); len(users) == 1 && users[0].Status == presence.StatusOnline {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the op to bring alice back online")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHandleGetPresence_Sessions(t *testing.T) {
+	_, _, handler := createTestHandlerWithComponents()
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	getAlice := func() presence.User {
+		rr := httptest.NewRecorder()
+		handler.HandleGetPresence(rr, httptest.NewRequest("GET", "/v1/presence/ws-sessions", nil))
+		var users []presence.User
+		json.Unmarshal(rr.Body.Bytes(), &users)
+		for _, u := range users {
+			if u.UserID == "alice" {
+				return u
+			}
+		}
+		return presence.User{}
+	}
+
+	for _, device := range []string{"laptop", "phone"} {
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-sessions?userId=alice&device="+device, nil)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+		readFrame(t, conn) // init
+		if device == "phone" {
+			conn.Close()
+		}
+	}
+
+	// Closing the phone leaves alice online through the laptop.
+	deadline := time.Now().Add(2 * time.Second)
+	alice := getAlice()
+	for alice.SessionCount != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		alice = getAlice()
+	}
+	if alice.Status != presence.StatusOnline || alice.SessionCount != 1 {
+		t.Fatalf("Expected alice online with 1 session, got %+v", alice)
+	}
+	if device := alice.Sessions[0].Device; device.Name != "laptop" || device.UserAgent == "" {
+		t.Errorf("Expected the laptop with a user agent, got %+v", device)
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// resumeHeads returns the heads a reconnecting client passed in the
+// "heads" query parameter (comma-separated), if any.
+func resumeHeads(r *http.Request) []string {
+	var heads []string
+	for _, h := range strings.Split(r.URL.Query().Get("heads"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			heads = append(heads, h)
+		}
+	}
+	return heads
+}
+
+// headsFromMessage extracts the heads of a {"type":"resume","heads":[...]} message.
+func headsFromMessage(msg map[string]interface{}) []string {
+	raw, _ := msg["heads"].([]interface{})
+	heads := make([]string, 0, len(raw))
+	for _, h := range raw {
+		if s, ok := h.(string); ok && s != "" {
+			heads = append(heads, s)
+		}
+	}
+	return heads
+}
+
+// sendInitialState brings a (re)connecting client up to date.
+//
+// With known heads the client receives only what changed since then:
+//
+//	{"type":"delta","data":{changed keys},"deleted":[removed keys],"heads":[...],"version":"...","presence":[...]}
+//
+// Otherwise (no heads, unknown heads, or a strategy without history) it gets
+// the full snapshot as an "init" message. Both carry the users currently
+// present in the workspace.
+func (h *Handler) sendInitialState(conn *wsConn, workspaceID string, since []string) {
+	if len(since) > 0 {
+		delta, ok, err := h.crdtEngine.GetDelta(workspaceID, since)
+		if err != nil {
+			h.logger.Warn("delta_resume_failed",
+				slog.String("workspace_id", workspaceID),
+				slog.Any("error", err),
+			)
+		}
+		if ok {
+			conn.WriteJSON(map[string]interface{}{
+				"type":     "delta",
+				"data":     delta.Data,
+				"deleted":  delta.Deleted,
+				"heads":    delta.Heads,
+				"version":  delta.Version,
+				"presence": h.presenceSnapshot(workspaceID),
+			})
+			return
+		}
+	}
+
+	snapshot, err := h.crdtEngine.GetFullState(workspaceID)
+	if err != nil {
+		return
+	}
+	conn.WriteJSON(map[string]interface{}{
+		"type":     "init",
+		"data":     snapshot.Data,
+		"heads":    snapshot.Heads,
+		"version":  snapshot.Version,
+		"presence": h.presenceSnapshot(workspaceID),
+	})
+}
@@ -0,0 +1,103 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/gorilla/websocket"
+)
+
+// readFrame returns the next frame, skipping presence events (presence.joined,
+// presence.left, ...) of sessions that come and go around the test.
+func readFrame(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var msg map[string]interface{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		if msgType, _ := msg["type"].(string); !strings.HasPrefix(msgType, "presence.") {
+			return msg
+		}
+	}
+}
+
+func TestHandleWebSocket_DeltaResume(t *testing.T) {
+	engine, _, handler := createTestHandlerWithComponents()
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+	base := "ws" + s.URL[4:] + "/v1/sync/ws-resume"
+
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-resume", Key: "stable", Value: "unchanged"})
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-resume", Key: "old", Value: "gone soon"})
+	before, _ := engine.GetFullState("ws-resume")
+
+	// While the client is away: one key changes, one is removed.
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-resume", Key: "fresh", Value: "new"})
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-resume", Op: "delete", Key: "old"})
+	after, _ := engine.GetFullState("ws-resume")
+
+	checkDelta := func(msg map[string]interface{}) {
+		t.Helper()
+		if msg["type"] != "delta" {
+			t.Fatalf("Expected delta frame, got %v", msg)
+		}
+		data, _ := msg["data"].(map[string]interface{})
+		if len(data) != 1 || data["fresh"] != "new" {
+			t.Errorf("Expected only 'fresh' in delta data, got %v", data)
+		}
+		deleted, _ := msg["deleted"].([]interface{})
+		if len(deleted) != 1 || deleted[0] != "old" {
+			t.Errorf("Expected 'old' to be reported deleted, got %v", deleted)
+		}
+		heads, _ := msg["heads"].([]interface{})
+		if len(heads) != len(after.Heads) || heads[0] != after.Heads[0] {
+			t.Errorf("Expected current heads %v, got %v", after.Heads, heads)
+		}
+	}
+
+	// 1. Heads as query parameter.
+	conn, _, err := websocket.DefaultDialer.Dial(base+"?heads="+strings.Join(before.Heads, ","), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	checkDelta(readFrame(t, conn))
+	conn.Close()
+
+	// 2. Heads in the first message.
+	conn, _, err = websocket.DefaultDialer.Dial(base+"?resume=1", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	conn.WriteJSON(map[string]interface{}{"type": "resume", "heads": before.Heads})
+	checkDelta(readFrame(t, conn))
+	conn.Close()
+
+	// 3. Unknown heads fall back to a full snapshot.
+	conn, _, err = websocket.DefaultDialer.Dial(base+"?heads="+strings.Repeat("ab", 32), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	msg := readFrame(t, conn)
+	data, _ := msg["data"].(map[string]interface{})
+	if msg["type"] != "init" || len(data) != 2 {
+		t.Errorf("Expected full init snapshot, got %v", msg)
+	}
+	conn.Close()
+
+	// 4. ?resume=1 without a resume message still bootstraps the client.
+	conn, _, err = websocket.DefaultDialer.Dial(base+"?resume=1", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(map[string]interface{}{"type": "ping"})
+	if msg := readFrame(t, conn); msg["type"] != "init" {
+		t.Errorf("Expected init after a non-resume first message, got %v", msg)
+	}
+}
@@ -0,0 +1,78 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/auth"
+	"github.com/gorilla/websocket"
+)
+
+func TestSchema_RegisterAndReject(t *testing.T) {
+	_, _, handler := createTestHandlerWithComponents()
+
+	put := func(body string, scopes ...string) int {
+		req := httptest.NewRequest("PUT", "/v1/workspaces/ws-schema/schema", strings.NewReader(body))
+		req = req.WithContext(auth.NewContextWithScopes(req.Context(), scopes))
+		rr := httptest.NewRecorder()
+		handler.HandleWorkspace(rr, req)
+		return rr.Code
+	}
+	if code := put(`{"type":"object"}`, "write"); code != http.StatusForbidden {
+		t.Errorf("Expected 403 without admin scope, got %d", code)
+	}
+	if code := put(`{"type": 5}`, "admin"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid schema, got %d", code)
+	}
+	// Oversized schemas are refused rather than truncated into valid JSON.
+	huge := `{"type":"object","description":"` + strings.Repeat("x", 1<<20) + `"}`
+	if code := put(huge, "admin"); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for an oversized schema, got %d", code)
+	}
+	schema := `{"type":"object","properties":{"title":{"type":"string","maxLength":5}}}`
+	if code := put(schema, "admin"); code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", code)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.HandleWorkspace(rr, httptest.NewRequest("GET", "/v1/workspaces/ws-schema/schema", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != schema {
+		t.Errorf("Expected registered schema back, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-schema", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var initMsg map[string]interface{}
+	conn.ReadJSON(&initMsg)
+
+	conn.WriteJSON(map[string]interface{}{
+		"type":    "op",
+		"payload": map[string]interface{}{"key": "title", "value": "far too long"},
+	})
+	var errMsg struct {
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+		Details []struct {
+			Path    string `json:"path"`
+			Message string `json:"message"`
+		} `json:"details"`
+	}
+	if err := conn.ReadJSON(&errMsg); err != nil {
+		t.Fatalf("Failed to read error frame: %v", err)
+	}
+	if errMsg.Type != "error" || errMsg.Code != "schema_violation" {
+		t.Fatalf("Expected schema_violation error frame, got %+v", errMsg)
+	}
+	if len(errMsg.Details) != 1 || errMsg.Details[0].Path != "/title" || errMsg.Details[0].Message == "" {
+		t.Errorf("Expected one violation at /title, got %+v", errMsg.Details)
+	}
+}
@@ -0,0 +1,292 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/automerge/automerge-go"
+	"github.com/bneb/etherply/etherply-sync-server/internal/auth"
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/presence"
+	"github.com/bneb/etherply/etherply-sync-server/internal/pubsub"
+	"github.com/bneb/etherply/etherply-sync-server/internal/server"
+	"github.com/bneb/etherply/etherply-sync-server/internal/store"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+	"github.com/bneb/etherply/etherply-sync-server/internal/webhook"
+	"github.com/gorilla/websocket"
+)
+
+const syncProtocol = "etherply.automerge-sync.v1"
+
+// dialSync opens a connection that negotiates the binary sync subprotocol.
+func dialSync(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	dialer := websocket.Dialer{Subprotocols: []string{syncProtocol}}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	if conn.Subprotocol() != syncProtocol {
+		t.Fatalf("Expected subprotocol %q, got %q", syncProtocol, conn.Subprotocol())
+	}
+	return conn
+}
+
+// syncClient is the client side of the sync protocol over one connection.
+type syncClient struct {
+	conn *websocket.Conn
+	ss   *automerge.SyncState
+	in   chan []byte
+}
+
+func newSyncClient(conn *websocket.Conn, doc *automerge.Doc) *syncClient {
+	c := &syncClient{conn: conn, ss: automerge.NewSyncState(doc), in: make(chan []byte, 16)}
+	go func() {
+		defer close(c.in)
+		for {
+			msgType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msgType == websocket.BinaryMessage {
+				c.in <- data
+			}
+		}
+	}()
+	return c
+}
+
+// syncUntil exchanges sync messages until done returns true.
+func (c *syncClient) syncUntil(t *testing.T, done func() bool) {
+	t.Helper()
+	deadline := time.After(3 * time.Second)
+	for !done() {
+		if msg, ok := c.ss.GenerateMessage(); ok {
+			if err := c.conn.WriteMessage(websocket.BinaryMessage, msg.Bytes()); err != nil {
+				t.Fatalf("Failed to send sync message: %v", err)
+			}
+		}
+		select {
+		case data, ok := <-c.in:
+			if !ok {
+				t.Fatal("Connection closed during sync")
+			}
+			if _, err := c.ss.ReceiveMessage(data); err != nil {
+				t.Fatalf("Failed to receive sync message: %v", err)
+			}
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("Timeout waiting for sync to converge")
+		}
+	}
+}
+
+func TestAutomergeSync_Bidirectional(t *testing.T) {
+	engine, _, handler := createTestHandlerWithComponents()
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-sync", Key: "from_server", Value: "hi"})
+
+	clientDoc := automerge.New()
+	clientDoc.Path("from_client").Set("hello")
+	clientDoc.Commit("client edit")
+
+	conn := dialSync(t, "ws"+s.URL[4:]+"/v1/sync/ws-sync")
+	defer conn.Close()
+	client := newSyncClient(conn, clientDoc)
+
+	client.syncUntil(t, func() bool {
+		snapshot, _ := engine.GetFullState("ws-sync")
+		v, _ := clientDoc.Path("from_server").Get()
+		return snapshot.Data["from_client"] == "hello" && v.Kind() == automerge.KindStr
+	})
+
+	// Changes made through JSON ops reach the sync client as sync messages.
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-sync", Key: "later", Value: "x"})
+	nudgeWorkspace(t, s.URL, "ws-sync")
+	client.syncUntil(t, func() bool {
+		v, _ := clientDoc.Path("later").Get()
+		return v.Kind() == automerge.KindStr
+	})
+}
+
+// nudgeWorkspace sends a JSON op on a second connection so that the
+// workspace PubSub announces a change to every subscriber.
+func nudgeWorkspace(t *testing.T, baseURL, workspaceID string) {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+baseURL[4:]+"/v1/sync/"+workspaceID+"?echo=1", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect JSON client: %v", err)
+	}
+	defer conn.Close()
+	var initMsg map[string]interface{}
+	conn.ReadJSON(&initMsg)
+	conn.WriteJSON(map[string]interface{}{
+		"type":    "op",
+		"payload": map[string]interface{}{"key": "nudge", "value": true},
+	})
+	var echo map[string]interface{}
+	conn.ReadJSON(&echo)
+}
+
+func TestAutomergeSync_JSONClientsReceiveState(t *testing.T) {
+	_, _, handler := createTestHandlerWithComponents()
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	jsonConn, _, err := websocket.DefaultDialer.Dial("ws"+s.URL[4:]+"/v1/sync/ws-mixed", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer jsonConn.Close()
+	var initMsg map[string]interface{}
+	jsonConn.ReadJSON(&initMsg)
+
+	clientDoc := automerge.New()
+	clientDoc.Path("title").Set("synced")
+	clientDoc.Commit("edit")
+	ss := automerge.NewSyncState(clientDoc)
+
+	conn := dialSync(t, "ws"+s.URL[4:]+"/v1/sync/ws-mixed")
+	defer conn.Close()
+	msgType, data, err := conn.ReadMessage()
+	if err != nil || msgType != websocket.BinaryMessage {
+		t.Fatalf("Expected opening sync message, got type %d (err %v)", msgType, err)
+	}
+	ss.ReceiveMessage(data)
+	msg, _ := ss.GenerateMessage()
+	conn.WriteMessage(websocket.BinaryMessage, msg.Bytes())
+
+	jsonConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var stateMsg map[string]interface{}
+	if err := jsonConn.ReadJSON(&stateMsg); err != nil {
+		t.Fatalf("Failed to read state frame: %v", err)
+	}
+	data2, _ := stateMsg["data"].(map[string]interface{})
+	if stateMsg["type"] != "state" || data2["title"] != "synced" {
+		t.Errorf("Expected state frame with title 'synced', got %v", stateMsg)
+	}
+}
+
+func TestAutomergeSync_ReadOnlyCannotPush(t *testing.T) {
+	engine, _, handler := createTestHandlerWithComponents()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := auth.NewContextWithScopes(r.Context(), []string{"read"})
+		handler.HandleWebSocket(w, r.WithContext(ctx))
+	}))
+	defer s.Close()
+
+	clientDoc := automerge.New()
+	clientDoc.Path("illegal").Set("write")
+	clientDoc.Commit("edit")
+	ss := automerge.NewSyncState(clientDoc)
+
+	conn := dialSync(t, "ws"+s.URL[4:]+"/v1/sync/ws-readonly")
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	_, data, _ := conn.ReadMessage()
+	ss.ReceiveMessage(data)
+
+	// Exchange messages until the client tries to send its change.
+	denied := false
+	for i := 0; i < 5 && !denied; i++ {
+		msg, ok := ss.GenerateMessage()
+		if !ok {
+			break
+		}
+		conn.WriteMessage(websocket.BinaryMessage, msg.Bytes())
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if msgType == websocket.TextMessage {
+			if !strings.Contains(string(data), "permission_denied") {
+				t.Fatalf("Expected permission_denied, got %s", data)
+			}
+			denied = true
+			continue
+		}
+		ss.ReceiveMessage(data)
+	}
+
+	if !denied {
+		t.Error("Expected the change-carrying sync message to be denied")
+	}
+	snapshot, _ := engine.GetFullState("ws-readonly")
+	if _, exists := snapshot.Data["illegal"]; exists {
+		t.Error("Read-only client must not be able to push changes")
+	}
+}
+
+func TestAutomergeSync_UnsupportedStrategy(t *testing.T) {
+	engine := crdt.NewEngine(store.NewMemoryStore(), crdt.WithStrategy(sync.NewLWWStrategy()))
+	handler := server.NewHandler(engine, presence.NewManager(), pubsub.NewMemoryPubSub(), webhook.NewDispatcher(""), nil, &MockMeteringService{})
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	conn := dialSync(t, "ws"+s.URL[4:]+"/v1/sync/ws-lww")
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseUnsupportedData) {
+		t.Errorf("Expected close with CloseUnsupportedData, got %v", err)
+	}
+}
+
+func TestAutomergeSync_SchemaViolation(t *testing.T) {
+	ms := store.NewMemoryStore()
+	engine := crdt.NewEngine(ms)
+	handler := server.NewHandler(engine, presence.NewManager(), pubsub.NewMemoryPubSub(), webhook.NewDispatcher(""), nil, &MockMeteringService{})
+	s := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer s.Close()
+
+	schema := `{"type":"object","properties":{"title":{"type":"string","maxLength":5}}}`
+	if err := engine.SetSchema("ws-sync-schema", []byte(schema)); err != nil {
+		t.Fatal(err)
+	}
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-sync-schema", Key: "count", Value: int64(1)})
+
+	clientDoc := automerge.New()
+	clientDoc.Path("title").Set("far too long")
+	clientDoc.Commit("invalid edit")
+	ss := automerge.NewSyncState(clientDoc)
+
+	conn := dialSync(t, "ws"+s.URL[4:]+"/v1/sync/ws-sync-schema")
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+
+	// Exchange messages until the server rejects the client's change.
+	var rejection string
+	for rejection == "" {
+		if msg, ok := ss.GenerateMessage(); ok {
+			conn.WriteMessage(websocket.BinaryMessage, msg.Bytes())
+		}
+		msgType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Expected a schema_violation error, read failed: %v", err)
+		}
+		if msgType == websocket.TextMessage {
+			rejection = string(data)
+			continue
+		}
+		ss.ReceiveMessage(data)
+	}
+
+	if !strings.Contains(rejection, "schema_violation") || !strings.Contains(rejection, "/title") {
+		t.Fatalf("Expected a schema_violation error naming /title, got %s", rejection)
+	}
+	snapshot, _ := engine.GetFullState("ws-sync-schema")
+	if _, exists := snapshot.Data["title"]; exists {
+		t.Errorf("Expected the invalid change not to be applied, got %v", snapshot.Data)
+	}
+	snapshot, _ = crdt.NewEngine(ms).GetFullState("ws-sync-schema")
+	if _, exists := snapshot.Data["title"]; exists || snapshot.Data["count"] != int64(1) {
+		t.Errorf("Expected only valid data to be stored, got %v", snapshot.Data)
+	}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/auth"
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+// maxSchemaBytes bounds the size of a registered JSON Schema.
+const maxSchemaBytes = 1 << 20
+
+// workspaceResponse describes a workspace and the strategy its document uses.
+type workspaceResponse struct {
+	WorkspaceID string            `json:"workspace_id"`
+	Strategy    sync.StrategyType `json:"strategy"`
+	Bound       bool              `json:"bound"`
+	CreatedAt   *time.Time        `json:"created_at,omitempty"`
+}
+
+// HandleWorkspace serves /v1/workspaces/{workspace_id} and its migrate and
+// schema sub-resources.
+//
+// GET returns the workspace's strategy. A workspace that was never written
+// reports the server default with bound=false.
+// PUT binds the workspace to {"strategy": "..."} (default if omitted). It is
+// idempotent and returns 409 if the workspace is already bound to another strategy.
+func (h *Handler) HandleWorkspace(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 4 || parts[3] == "" {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	workspaceID := parts[3]
+	if !h.requireWorkspace(w, r, workspaceID) {
+		return
+	}
+	if len(parts) > 4 {
+		switch parts[4] {
+		case "migrate":
+			h.handleMigrate(w, r, workspaceID)
+		case "schema":
+			h.handleSchema(w, r, workspaceID)
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+		return
+	}
+
+	var (
+		meta  *crdt.DocumentMeta
+		bound bool
+		err   error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		meta, bound, err = h.crdtEngine.WorkspaceMeta(workspaceID)
+	case http.MethodPut:
+		if !canWrite(r) {
+			http.Error(w, "Write permission required", http.StatusForbidden)
+			return
+		}
+		var req struct {
+			Strategy sync.StrategyType `json:"strategy"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Strategy == "" {
+			req.Strategy = sync.StrategyType(h.crdtEngine.Strategy())
+		}
+		meta, err = h.crdtEngine.CreateWorkspace(workspaceID, req.Strategy)
+		bound = err == nil
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case errors.Is(err, crdt.ErrUnknownStrategy):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, crdt.ErrStrategyMismatch):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		h.logger.Error("workspace_request_failed", slog.String("workspace_id", workspaceID), slog.Any("error", err))
+		http.Error(w, "Failed to access workspace", http.StatusInternalServerError)
+		return
+	}
+
+	resp := workspaceResponse{
+		WorkspaceID: workspaceID,
+		Strategy:    sync.StrategyType(h.crdtEngine.Strategy()),
+		Bound:       bound,
+	}
+	if meta != nil {
+		resp.Strategy = meta.Strategy
+		resp.CreatedAt = &meta.CreatedAt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// canAdmin reports whether the request's token allows maintenance actions.
+// As with canWrite, tokens without scopes are allowed.
+func canAdmin(r *http.Request) bool {
+	scopes := auth.ScopesFromContext(r.Context())
+	if len(scopes) == 0 {
+		return true
+	}
+	for _, s := range scopes {
+		if s == "admin" {
+			return true
+		}
+	}
+	return false
+}
+
+// handleMigrate serves POST /v1/workspaces/{workspace_id}/migrate with
+// {"strategy": "...", "dry_run": true}. It converts the workspace's document
+// online and announces the converted state to connected clients.
+func (h *Handler) handleMigrate(w http.ResponseWriter, r *http.Request, workspaceID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !canAdmin(r) {
+		http.Error(w, "Admin permission required", http.StatusForbidden)
+		return
+	}
+	var req struct {
+		Strategy sync.StrategyType `json:"strategy"`
+		DryRun   bool              `json:"dry_run"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Strategy == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.crdtEngine.MigrateWorkspace(workspaceID, req.Strategy, req.DryRun)
+	switch {
+	case errors.Is(err, crdt.ErrUnknownStrategy):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		h.logger.Error("workspace_migration_failed", slog.String("workspace_id", workspaceID), slog.Any("error", err))
+		http.Error(w, "Migration failed: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if !report.DryRun && !report.Unchanged {
+		h.publishState(workspaceID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// handleSchema serves /v1/workspaces/{workspace_id}/schema. PUT registers the
+// request body as the workspace's JSON Schema, DELETE removes it and GET
+// returns it. Changing the schema requires the admin scope.
+func (h *Handler) handleSchema(w http.ResponseWriter, r *http.Request, workspaceID string) {
+	switch r.Method {
+	case http.MethodGet:
+		raw, exists, err := h.crdtEngine.Schema(workspaceID)
+		if err != nil {
+			h.logger.Error("schema_read_failed", slog.String("workspace_id", workspaceID), slog.Any("error", err))
+			http.Error(w, "Failed to read schema", http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			http.Error(w, "No schema registered", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.Write(raw)
+		return
+	case http.MethodPut, http.MethodDelete:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !canAdmin(r) {
+		http.Error(w, "Admin permission required", http.StatusForbidden)
+		return
+	}
+	var raw []byte
+	if r.Method == http.MethodPut {
+		var err error
+		raw, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxSchemaBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Schema exceeds %d bytes", maxSchemaBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil || len(raw) == 0 {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := h.crdtEngine.SetSchema(workspaceID, raw)
+	switch {
+	case errors.Is(err, crdt.ErrInvalidSchema):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		h.logger.Error("schema_update_failed", slog.String("workspace_id", workspaceID), slog.Any("error", err))
+		http.Error(w, "Failed to store schema", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
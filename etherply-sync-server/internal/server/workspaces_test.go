@@ -0,0 +1,82 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/auth"
+	"github.com/bneb/etherply/etherply-sync-server/internal/crdt"
+)
+
+func TestHandleWorkspace(t *testing.T) {
+	_, _, handler := createTestHandlerWithComponents()
+
+	do := func(method, path, body string, scopes ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(auth.NewContextWithScopes(req.Context(), scopes))
+		rr := httptest.NewRecorder()
+		handler.HandleWorkspace(rr, req)
+		return rr
+	}
+
+	rr := do("GET", "/v1/workspaces/ws-new", "")
+	var resp map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || resp["strategy"] != "automerge" || resp["bound"] != false {
+		t.Errorf("Expected unbound default strategy, got %d %v", rr.Code, resp)
+	}
+
+	rr = do("PUT", "/v1/workspaces/ws-new", `{"strategy":"server-auth"}`)
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || resp["strategy"] != "server-auth" || resp["bound"] != true {
+		t.Errorf("Expected workspace bound to server-auth, got %d %v", rr.Code, resp)
+	}
+
+	if rr := do("PUT", "/v1/workspaces/ws-new", `{"strategy":"lww"}`); rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a different strategy, got %d", rr.Code)
+	}
+	if rr := do("PUT", "/v1/workspaces/ws-other", `{"strategy":"nope"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown strategy, got %d", rr.Code)
+	}
+	if rr := do("PUT", "/v1/workspaces/ws-other", `{"strategy":"lww"}`, "read"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a read-only token, got %d", rr.Code)
+	}
+}
+
+func TestHandleWorkspace_Migrate(t *testing.T) {
+	engine, _, handler := createTestHandlerWithComponents()
+	engine.ProcessOperation(crdt.Operation{WorkspaceID: "ws-mig", Key: "k", Value: "v"})
+
+	migrate := func(body string, scopes ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/workspaces/ws-mig/migrate", strings.NewReader(body))
+		req = req.WithContext(auth.NewContextWithScopes(req.Context(), scopes))
+		rr := httptest.NewRecorder()
+		handler.HandleWorkspace(rr, req)
+		return rr
+	}
+
+	if rr := migrate(`{"strategy":"lww"}`, "write"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without admin scope, got %d", rr.Code)
+	}
+
+	rr := migrate(`{"strategy":"lww","dry_run":true}`, "admin")
+	var report map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &report)
+	if rr.Code != http.StatusOK || report["from"] != "automerge" || report["dry_run"] != true {
+		t.Errorf("Expected dry-run report, got %d %v", rr.Code, report)
+	}
+	if meta, _, _ := engine.WorkspaceMeta("ws-mig"); meta.Strategy != "automerge" {
+		t.Errorf("Expected dry run to keep automerge, got %s", meta.Strategy)
+	}
+
+	if rr := migrate(`{"strategy":"lww"}`, "admin"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected migration to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	snapshot, _ := engine.GetFullState("ws-mig")
+	if meta, _, _ := engine.WorkspaceMeta("ws-mig"); meta.Strategy != "lww" || snapshot.Data["k"] != "v" {
+		t.Errorf("Expected ws-mig migrated to lww with data intact, got %s %v", meta.Strategy, snapshot.Data)
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsConn serializes writes to a WebSocket connection.
+//
+// gorilla/websocket allows one concurrent writer, but frames are sent both
+// from the read loop (init, errors) and from the write pump (broadcasts).
+type wsConn struct {
+	*websocket.Conn
+	writeMu sync.Mutex
+}
+
+func newWSConn(conn *websocket.Conn) *wsConn {
+	return &wsConn{Conn: conn}
+}
+
+// WriteMessage writes a single frame.
+func (c *wsConn) WriteMessage(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.Conn.WriteMessage(messageType, data)
+}
+
+// WriteJSON writes v as a JSON text frame.
+func (c *wsConn) WriteJSON(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.Conn.WriteJSON(v)
+}
+
+// errorFrame is sent when a client message is not applied. Payload repeats
+// code and reason as "<code>: <reason>" for clients predating Code/Reason.
+type errorFrame struct {
+	Type    string      `json:"type"`
+	Payload string      `json:"payload"`
+	Code    string      `json:"code"`
+	Reason  string      `json:"reason"`
+	OpID    string      `json:"op_id,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// writeError sends an error frame: {"type":"error","payload":"<code>: <reason>","code":...,"reason":...}.
+func (c *wsConn) writeError(code, reason string) error {
+	return c.writeOpError("", code, reason, nil)
+}
+
+// writeOpError sends an error frame answering the client op opID.
+func (c *wsConn) writeOpError(opID, code, reason string, details interface{}) error {
+	return c.WriteJSON(errorFrame{
+		Type:    "error",
+		Payload: code + ": " + reason,
+		Code:    code,
+		Reason:  reason,
+		OpID:    opID,
+		Details: details,
+	})
+}
+
+// writeAck confirms that the client op opID was applied and persisted:
+// {"type":"ack","op_id":"...","heads":[...]}. Replays of an op that was
+// applied before are acknowledged with "already_applied":true.
+func (c *wsConn) writeAck(opID string, heads []string, alreadyApplied bool) error {
+	frame := map[string]interface{}{
+		"type":  "ack",
+		"op_id": opID,
+		"heads": heads,
+	}
+	if alreadyApplied {
+		frame["already_applied"] = true
+	}
+	return c.WriteJSON(frame)
+}
@@ -72,6 +72,13 @@ func (s *BadgerStore) Set(namespace, key string, value interface{}) error {
 	})
 }
 
+func (s *BadgerStore) Delete(namespace, key string) error {
+	dbKey := makeKey(namespace, key)
+	return s.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(dbKey)
+	})
+}
+
 func (s *BadgerStore) GetAll(namespace string) (map[string]interface{}, error) {
 	result := make(map[string]interface{})
 	prefix := []byte(namespace + ":")
@@ -113,6 +120,24 @@ func (s *BadgerStore) GetAll(namespace string) (map[string]interface{}, error) {
 	return result, nil
 }
 
+// Keys returns every "<namespace>:<key>" that starts with prefix.
+func (s *BadgerStore) Keys(prefix string) ([]string, error) {
+	var keys []string
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		p := []byte(prefix)
+		for it.Seek(p); it.ValidForPrefix(p); it.Next() {
+			keys = append(keys, string(it.Item().Key()))
+		}
+		return nil
+	})
+	return keys, err
+}
+
 func (s *BadgerStore) Close() error {
 	return s.db.Close()
 }
@@ -182,4 +207,7 @@ func decode(data []byte) (interface{}, error) {
 }
 
 // Ensure interface satisfaction
-var _ Store = (*BadgerStore)(nil)
+var (
+	_ Store     = (*BadgerStore)(nil)
+	_ KeyLister = (*BadgerStore)(nil)
+)
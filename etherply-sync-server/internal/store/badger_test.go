@@ -104,3 +104,28 @@ func TestBadgerStore_Persistence(t *testing.T) {
 		t.Errorf("Expected 'persistent', got %v", val)
 	}
 }
+
+func TestBadgerStore_Delete(t *testing.T) {
+	dir, err := os.MkdirTemp("", "badger-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bs, err := store.NewBadgerStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create badger store: %v", err)
+	}
+	defer bs.Close()
+
+	bs.Set("ws1", "k1", "v1")
+	if err := bs.Delete("ws1", "k1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, exists, _ := bs.Get("ws1", "k1"); exists {
+		t.Error("Expected k1 to be deleted")
+	}
+	if err := bs.Delete("ws1", "missing"); err != nil {
+		t.Errorf("Delete of missing key failed: %v", err)
+	}
+}
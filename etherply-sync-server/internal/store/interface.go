@@ -10,6 +10,9 @@ type Store interface {
 	// Set writes a value to the store.
 	Set(namespace, key string, value interface{}) error
 
+	// Delete removes a key from the store. Deleting a missing key is not an error.
+	Delete(namespace, key string) error
+
 	// GetAll retrieves all key-value pairs for a given namespace.
 	GetAll(namespace string) (map[string]interface{}, error)
 
@@ -23,3 +26,10 @@ type Store interface {
 	// Returns nil if healthy, error otherwise.
 	Ping() error
 }
+
+// KeyLister is implemented by stores that can enumerate their keys. It is
+// used by maintenance tasks such as strategy migration, never on hot paths.
+type KeyLister interface {
+	// Keys returns every "<namespace>:<key>" that starts with prefix.
+	Keys(prefix string) ([]string, error)
+}
@@ -1,6 +1,8 @@
 package store
 
 import (
+	"sort"
+	"strings"
 	"sync"
 )
 
@@ -40,6 +42,21 @@ func (s *MemoryStore) Set(namespace string, key string, value interface{}) error
 	return nil
 }
 
+func (s *MemoryStore) Delete(namespace string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	workspace, ok := s.data[namespace]
+	if !ok {
+		return nil
+	}
+	delete(workspace, key)
+	if len(workspace) == 0 {
+		delete(s.data, namespace)
+	}
+	return nil
+}
+
 func (s *MemoryStore) GetAll(namespace string) (map[string]interface{}, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -58,6 +75,23 @@ func (s *MemoryStore) GetAll(namespace string) (map[string]interface{}, error) {
 	return result, nil
 }
 
+// Keys returns every "<namespace>:<key>" that starts with prefix, sorted.
+func (s *MemoryStore) Keys(prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []string
+	for namespace, workspace := range s.data {
+		for key := range workspace {
+			if full := namespace + ":" + key; strings.HasPrefix(full, prefix) {
+				keys = append(keys, full)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
 // Close is a no-op for MemoryStore but provided for interface consistency
 // with DiskStore. This allows both stores to be used interchangeably.
 func (s *MemoryStore) Close() error {
@@ -85,4 +119,7 @@ func (s *MemoryStore) Ping() error {
 }
 
 // Ensure interface satisfaction
-var _ Store = (*MemoryStore)(nil)
+var (
+	_ Store     = (*MemoryStore)(nil)
+	_ KeyLister = (*MemoryStore)(nil)
+)
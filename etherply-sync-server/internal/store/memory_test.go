@@ -163,3 +163,29 @@ func TestMemoryStore_Close(t *testing.T) {
 		t.Errorf("Close should return nil, got %v", err)
 	}
 }
+
+func TestMemoryStore_Delete(t *testing.T) {
+	ms := store.NewMemoryStore()
+	defer ms.Close()
+
+	ms.Set("ws", "k1", "v1")
+	ms.Set("ws", "k2", "v2")
+
+	if err := ms.Delete("ws", "k1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, exists, _ := ms.Get("ws", "k1"); exists {
+		t.Error("Expected k1 to be deleted")
+	}
+	if _, exists, _ := ms.Get("ws", "k2"); !exists {
+		t.Error("Expected k2 to survive deleting k1")
+	}
+
+	// Deleting a missing key or namespace is not an error
+	if err := ms.Delete("ws", "missing"); err != nil {
+		t.Errorf("Delete of missing key failed: %v", err)
+	}
+	if err := ms.Delete("no-such-ws", "k"); err != nil {
+		t.Errorf("Delete in missing namespace failed: %v", err)
+	}
+}
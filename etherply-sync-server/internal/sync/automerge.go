@@ -21,28 +21,38 @@ func (s *AutomergeStrategy) Name() string {
 	return string(StrategyAutomerge)
 }
 
-// ProcessWrite applies a key-value mutation using Automerge.
-func (s *AutomergeStrategy) ProcessWrite(current []byte, key string, value interface{}, ts time.Time) ([]byte, error) {
-	var doc *automerge.Doc
-	var err error
-
+// Open loads the document into a live Automerge doc.
+func (s *AutomergeStrategy) Open(current []byte) (Document, error) {
 	if len(current) == 0 {
-		doc = automerge.New()
-	} else {
-		doc, err = automerge.Load(current)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load automerge doc: %w", err)
-		}
+		return &automergeDocument{doc: automerge.New()}, nil
 	}
 
-	if err := doc.Path(key).Set(value); err != nil {
-		return nil, fmt.Errorf("failed to set key %q: %w", key, err)
+	doc, err := automerge.Load(current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load automerge doc: %w", err)
 	}
+	// Load does not reset the incremental save point, so the first
+	// SaveIncremental would return the whole loaded history. Consume it here.
+	doc.SaveIncremental()
+	return &automergeDocument{doc: doc}, nil
+}
 
-	commitOpts := automerge.CommitOptions{Time: &ts}
-	doc.Commit(fmt.Sprintf("set %s", key), commitOpts)
+// ProcessWrite applies a key-value mutation using Automerge.
+func (s *AutomergeStrategy) ProcessWrite(current []byte, key string, value interface{}, ts time.Time) ([]byte, error) {
+	return s.Apply(current, SetMutation(key, value), ts)
+}
 
-	return doc.Save(), nil
+// Apply applies a typed mutation. Automerge supports every op kind: lists,
+// counters and text are created on first use.
+func (s *AutomergeStrategy) Apply(current []byte, m Mutation, ts time.Time) ([]byte, error) {
+	d, err := s.Open(current)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Apply(m, ts); err != nil {
+		return nil, err
+	}
+	return d.Save(), nil
 }
 
 // Merge combines local and remote documents using Automerge's CRDT merge.
@@ -55,35 +65,144 @@ func (s *AutomergeStrategy) Merge(local, remote []byte) ([]byte, error) {
 		return remote, nil
 	}
 
-	localDoc, err := automerge.Load(local)
+	d, err := s.Open(local)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load local doc: %w", err)
 	}
+	if err := d.Merge(remote); err != nil {
+		return nil, err
+	}
+	return d.Save(), nil
+}
 
-	remoteDoc, err := automerge.Load(remote)
+// GetState materializes the document as a map.
+func (s *AutomergeStrategy) GetState(doc []byte) (map[string]interface{}, error) {
+	if len(doc) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	d, err := s.Open(doc)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load remote doc: %w", err)
+		return nil, err
 	}
+	return d.State()
+}
 
-	if _, err := localDoc.Merge(remoteDoc); err != nil {
-		return nil, fmt.Errorf("failed to merge: %w", err)
+// GetHeads returns the current vector clock heads.
+func (s *AutomergeStrategy) GetHeads(doc []byte) ([]string, error) {
+	if len(doc) == 0 {
+		return []string{}, nil
 	}
 
-	return localDoc.Save(), nil
+	d, err := s.Open(doc)
+	if err != nil {
+		return nil, err
+	}
+	return d.Heads()
 }
 
-// GetState materializes the document as a map.
-func (s *AutomergeStrategy) GetState(doc []byte) (map[string]interface{}, error) {
+// GetChanges returns changes since the given heads, encoded as concatenated
+// Automerge change chunks (loadable with LoadIncremental).
+func (s *AutomergeStrategy) GetChanges(doc []byte, since []string) ([]byte, error) {
 	if len(doc) == 0 {
-		return map[string]interface{}{}, nil
+		return []byte{}, nil
+	}
+	if len(since) == 0 {
+		return doc, nil // Full sync
 	}
 
-	d, err := automerge.Load(doc)
+	d, err := s.Open(doc)
 	if err != nil {
 		return nil, err
 	}
+	return d.Changes(since)
+}
 
-	rootVal, err := d.Path().Get()
+// GetHistory returns the commit history.
+func (s *AutomergeStrategy) GetHistory(doc []byte) ([]Change, error) {
+	if len(doc) == 0 {
+		return []Change{}, nil
+	}
+
+	d, err := s.Open(doc)
+	if err != nil {
+		return nil, err
+	}
+	return d.History()
+}
+
+// automergeDocument is the live Document form of an Automerge doc.
+type automergeDocument struct {
+	doc *automerge.Doc
+}
+
+func (d *automergeDocument) Apply(m Mutation, ts time.Time) error {
+	if err := d.apply(m); err != nil {
+		return err
+	}
+	commitOpts := automerge.CommitOptions{Time: &ts}
+	d.doc.Commit(fmt.Sprintf("%s %s", m.Kind, m.PathString()), commitOpts)
+	return nil
+}
+
+// ApplyBatch applies all mutations and commits them as one Automerge change,
+// so peers never observe part of the batch.
+func (d *automergeDocument) ApplyBatch(ms []Mutation, ts time.Time) error {
+	for _, m := range ms {
+		if err := d.apply(m); err != nil {
+			return err
+		}
+	}
+	commitOpts := automerge.CommitOptions{Time: &ts}
+	d.doc.Commit(fmt.Sprintf("batch of %d ops", len(ms)), commitOpts)
+	return nil
+}
+
+// apply performs m on the working state without committing it.
+func (d *automergeDocument) apply(m Mutation) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	var err error
+	switch m.Kind {
+	case OpSet:
+		err = d.doc.Path(m.Path...).Set(m.Value)
+	case OpDelete:
+		err = d.doc.Path(m.Path...).Delete()
+	case OpInsert:
+		last := len(m.Path) - 1
+		err = d.doc.Path(m.Path[:last]...).List().Insert(m.Path[last].(int), m.Value)
+	case OpSplice:
+		text, _ := m.Value.(string)
+		err = d.doc.Path(m.Path...).Text().Splice(m.Index, m.DeleteCount, text)
+	case OpIncrement:
+		err = d.doc.Path(m.Path...).Counter().Inc(m.Delta)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to %s %q: %w", m.Kind, m.PathString(), err)
+	}
+	return nil
+}
+
+func (d *automergeDocument) Merge(remote []byte) error {
+	if len(remote) == 0 {
+		return nil
+	}
+
+	remoteDoc, err := automerge.Load(remote)
+	if err != nil {
+		return fmt.Errorf("failed to load remote doc: %w", err)
+	}
+
+	if _, err := d.doc.Merge(remoteDoc); err != nil {
+		return fmt.Errorf("failed to merge: %w", err)
+	}
+	return nil
+}
+
+func (d *automergeDocument) State() (map[string]interface{}, error) {
+	rootVal, err := d.doc.Path().Get()
 	if err != nil {
 		return nil, err
 	}
@@ -96,18 +215,29 @@ func (s *AutomergeStrategy) GetState(doc []byte) (map[string]interface{}, error)
 	return m, nil
 }
 
-// GetHeads returns the current vector clock heads.
-func (s *AutomergeStrategy) GetHeads(doc []byte) ([]string, error) {
-	if len(doc) == 0 {
-		return []string{}, nil
+func (d *automergeDocument) StateAt(heads []string) (map[string]interface{}, error) {
+	hashes := make([]automerge.ChangeHash, 0, len(heads))
+	for _, h := range heads {
+		hash, err := automerge.NewChangeHash(h)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownHeads, h)
+		}
+		// Changes and Fork silently ignore hashes they do not know.
+		if _, err := d.doc.Change(hash); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownHeads, h)
+		}
+		hashes = append(hashes, hash)
 	}
 
-	d, err := automerge.Load(doc)
+	past, err := d.doc.Fork(hashes...)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to fork at heads: %w", err)
 	}
+	return (&automergeDocument{doc: past}).State()
+}
 
-	heads := d.Heads()
+func (d *automergeDocument) Heads() ([]string, error) {
+	heads := d.doc.Heads()
 	result := make([]string, len(heads))
 	for i, h := range heads {
 		result[i] = h.String()
@@ -115,19 +245,12 @@ func (s *AutomergeStrategy) GetHeads(doc []byte) ([]string, error) {
 	return result, nil
 }
 
-// GetChanges returns changes since the given heads.
-func (s *AutomergeStrategy) GetChanges(doc []byte, since []string) ([]byte, error) {
-	if len(doc) == 0 {
-		return []byte{}, nil
-	}
-
-	d, err := automerge.Load(doc)
-	if err != nil {
-		return nil, err
-	}
-
+func (d *automergeDocument) Changes(since []string) ([]byte, error) {
 	if len(since) == 0 {
-		return doc, nil // Full sync
+		if len(d.doc.Heads()) == 0 {
+			return []byte{}, nil // Nothing committed yet
+		}
+		return d.doc.Save(), nil // Full sync
 	}
 
 	heads := make([]automerge.ChangeHash, 0, len(since))
@@ -139,38 +262,22 @@ func (s *AutomergeStrategy) GetChanges(doc []byte, since []string) ([]byte, erro
 		heads = append(heads, hash)
 	}
 
-	changes, err := d.Changes(heads...)
+	changes, err := d.doc.Changes(heads...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get changes: %w", err)
 	}
 
-	// Frame changes with length prefix
-	var buf []byte
+	// Concatenated change chunks: the same encoding as SaveIncremental, so
+	// deltas can be applied with LoadIncremental or appended to a saved doc.
+	buf := []byte{}
 	for _, ch := range changes {
-		chBytes := ch.Save()
-		lenBytes := make([]byte, 4)
-		lenBytes[0] = byte(len(chBytes) >> 24)
-		lenBytes[1] = byte(len(chBytes) >> 16)
-		lenBytes[2] = byte(len(chBytes) >> 8)
-		lenBytes[3] = byte(len(chBytes))
-		buf = append(buf, lenBytes...)
-		buf = append(buf, chBytes...)
+		buf = append(buf, ch.Save()...)
 	}
 	return buf, nil
 }
 
-// GetHistory returns the commit history.
-func (s *AutomergeStrategy) GetHistory(doc []byte) ([]Change, error) {
-	if len(doc) == 0 {
-		return []Change{}, nil
-	}
-
-	d, err := automerge.Load(doc)
-	if err != nil {
-		return nil, err
-	}
-
-	changes, err := d.Changes()
+func (d *automergeDocument) History() ([]Change, error) {
+	changes, err := d.doc.Changes()
 	if err != nil {
 		return nil, err
 	}
@@ -185,3 +292,97 @@ func (s *AutomergeStrategy) GetHistory(doc []byte) ([]Change, error) {
 	}
 	return history, nil
 }
+
+func (d *automergeDocument) Save() []byte {
+	return d.doc.Save()
+}
+
+// SaveIncremental returns the changes committed since the last save. Automerge
+// accepts a saved document followed by any number of these chunks in one Load.
+func (d *automergeDocument) SaveIncremental() []byte {
+	return d.doc.SaveIncremental()
+}
+
+func (d *automergeDocument) Fork() (SyncDocument, error) {
+	fork, err := d.doc.Fork()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fork automerge doc: %w", err)
+	}
+	return &automergeDocument{doc: fork}, nil
+}
+
+func (d *automergeDocument) MergeFrom(other SyncDocument) error {
+	o, ok := other.(*automergeDocument)
+	if !ok {
+		return fmt.Errorf("cannot merge %T into an automerge document", other)
+	}
+	if _, err := d.doc.Merge(o.doc); err != nil {
+		return fmt.Errorf("failed to merge: %w", err)
+	}
+	return nil
+}
+
+func (d *automergeDocument) NewSyncPeer() SyncPeer {
+	return &automergeSyncPeer{state: automerge.NewSyncState(d.doc)}
+}
+
+// automergeSyncPeer wraps automerge.SyncState.
+type automergeSyncPeer struct {
+	state *automerge.SyncState
+}
+
+func (p *automergeSyncPeer) Receive(msg []byte) error {
+	if _, err := p.state.ReceiveMessage(msg); err != nil {
+		return fmt.Errorf("failed to receive sync message: %w", err)
+	}
+	return nil
+}
+
+func (p *automergeSyncPeer) Generate() ([]byte, bool) {
+	msg, ok := p.state.GenerateMessage()
+	if !ok {
+		return nil, false
+	}
+	return msg.Bytes(), true
+}
+
+// SyncMessageHasChanges reports whether an Automerge sync message carries
+// changes (as opposed to only heads and bloom filters).
+func SyncMessageHasChanges(msg []byte) (bool, error) {
+	sm, err := automerge.LoadSyncMessage(msg)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode sync message: %w", err)
+	}
+	return len(sm.Changes()) > 0, nil
+}
+
+// CRDTTypes counts the Automerge counters and text objects in doc, which
+// other strategies store as plain numbers and strings. Documents of other
+// strategies have none.
+func CRDTTypes(doc Document) (counters, texts int) {
+	d, ok := doc.(*automergeDocument)
+	if !ok {
+		return 0, 0
+	}
+	var walk func(v *automerge.Value)
+	walk = func(v *automerge.Value) {
+		switch v.Kind() {
+		case automerge.KindCounter:
+			counters++
+		case automerge.KindText:
+			texts++
+		case automerge.KindMap:
+			values, _ := v.Map().Values()
+			for _, child := range values {
+				walk(child)
+			}
+		case automerge.KindList:
+			values, _ := v.List().Values()
+			for _, child := range values {
+				walk(child)
+			}
+		}
+	}
+	walk(d.doc.Root())
+	return counters, texts
+}
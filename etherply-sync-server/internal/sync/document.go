@@ -0,0 +1,166 @@
+package sync
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrUnknownHeads is returned when heads refer to changes the document does not have.
+var ErrUnknownHeads = errors.New("unknown heads")
+
+// Document is a live, mutable view of a single workspace's state.
+//
+// SyncStrategy works on serialized bytes, which forces a full decode/encode
+// round-trip per operation. A Document keeps the decoded form resident so the
+// engine can apply many operations and only serialize what changed.
+//
+// Implementations are not safe for concurrent use; callers must serialize access.
+type Document interface {
+	// Apply applies a typed mutation. After an error the document may hold
+	// uncommitted partial state and should be discarded.
+	Apply(m Mutation, ts time.Time) error
+
+	// ApplyBatch applies mutations in order as a single change: either all of
+	// them take effect or, after an error, the document must be discarded.
+	ApplyBatch(ms []Mutation, ts time.Time) error
+
+	// Merge folds a serialized remote document into this one.
+	Merge(remote []byte) error
+
+	// State materializes the document as a JSON-like map.
+	State() (map[string]interface{}, error)
+
+	// Heads returns version identifiers for delta sync.
+	Heads() ([]string, error)
+
+	// Changes returns changes since the given heads (full document if since is empty).
+	Changes(since []string) ([]byte, error)
+
+	// History returns the change log for the document.
+	History() ([]Change, error)
+
+	// Save serializes the complete document.
+	Save() []byte
+
+	// SaveIncremental returns the data added since the last Save or SaveIncremental,
+	// encoded so that it can be appended to the previous output and loaded as a whole.
+	// Returns nil if the format has no incremental encoding, in which case callers must Save.
+	SaveIncremental() []byte
+}
+
+// DocumentStrategy is implemented by strategies that have a native live
+// document representation (e.g. an in-memory Automerge doc).
+type DocumentStrategy interface {
+	SyncStrategy
+
+	// Open decodes current (nil/empty for new documents) into a live Document.
+	Open(current []byte) (Document, error)
+}
+
+// OpenDocument returns a live Document for current using strategy s.
+// Strategies without a native representation are adapted by keeping the
+// serialized bytes and delegating every call to the strategy.
+func OpenDocument(s SyncStrategy, current []byte) (Document, error) {
+	if ds, ok := s.(DocumentStrategy); ok {
+		return ds.Open(current)
+	}
+	return &bytesDocument{strategy: s, data: current}, nil
+}
+
+// bytesDocument adapts a byte-oriented SyncStrategy to the Document interface.
+type bytesDocument struct {
+	strategy SyncStrategy
+	data     []byte
+}
+
+func (d *bytesDocument) Apply(m Mutation, ts time.Time) error {
+	updated, err := d.strategy.Apply(d.data, m, ts)
+	if err != nil {
+		return err
+	}
+	d.data = updated
+	return nil
+}
+
+// ApplyBatch applies every mutation to a copy and keeps it only if all succeed.
+func (d *bytesDocument) ApplyBatch(ms []Mutation, ts time.Time) error {
+	updated := d.data
+	for _, m := range ms {
+		var err error
+		if updated, err = d.strategy.Apply(updated, m, ts); err != nil {
+			return err
+		}
+	}
+	d.data = updated
+	return nil
+}
+
+func (d *bytesDocument) Merge(remote []byte) error {
+	merged, err := d.strategy.Merge(d.data, remote)
+	if err != nil {
+		return err
+	}
+	d.data = merged
+	return nil
+}
+
+func (d *bytesDocument) State() (map[string]interface{}, error) {
+	return d.strategy.GetState(d.data)
+}
+
+func (d *bytesDocument) Heads() ([]string, error) {
+	return d.strategy.GetHeads(d.data)
+}
+
+func (d *bytesDocument) Changes(since []string) ([]byte, error) {
+	return d.strategy.GetChanges(d.data, since)
+}
+
+func (d *bytesDocument) History() ([]Change, error) {
+	return d.strategy.GetHistory(d.data)
+}
+
+func (d *bytesDocument) Save() []byte {
+	return d.data
+}
+
+// SaveIncremental returns nil: the JSON formats are always rewritten whole.
+func (d *bytesDocument) SaveIncremental() []byte {
+	return nil
+}
+
+// VersionedDocument is implemented by documents that keep enough history to
+// materialize past versions, which lets the engine compute what a client
+// holding an older version is missing.
+type VersionedDocument interface {
+	Document
+
+	// StateAt materializes the document as of heads. Returns ErrUnknownHeads
+	// if any of them is not part of the document's history.
+	StateAt(heads []string) (map[string]interface{}, error)
+}
+
+// SyncDocument is implemented by documents that speak the binary Automerge
+// sync protocol, which exchanges only the changes a peer is missing.
+type SyncDocument interface {
+	Document
+
+	// Fork returns an independent copy of the document.
+	Fork() (SyncDocument, error)
+
+	// MergeFrom folds the changes of other (of the same kind) into this document.
+	MergeFrom(other SyncDocument) error
+
+	// NewSyncPeer creates the sync state for one remote peer, bound to this document.
+	NewSyncPeer() SyncPeer
+}
+
+// SyncPeer tracks what one remote peer is known to have.
+// It is bound to the document that created it and shares its locking rules.
+type SyncPeer interface {
+	// Receive applies a sync message from the peer, including any changes it carries.
+	Receive(msg []byte) error
+
+	// Generate returns the next message for the peer, or false if it is up to date.
+	Generate() ([]byte, bool)
+}
@@ -0,0 +1,60 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// automergeMagic starts every Automerge document and change chunk.
+var automergeMagic = []byte{0x85, 0x6f, 0x4a, 0x83}
+
+// DetectFormat guesses which strategy wrote data, for legacy documents that
+// have no format tag. It returns "" for empty or unrecognized data.
+//
+// Automerge is binary and unambiguous. An LWW document is the JSON object
+// {"entries": {key: {"v": ..., "ts": ...}}}; any other JSON object is taken
+// to be a server-authoritative document. The guess between the two JSON
+// formats is only a heuristic: a server-authoritative document may itself
+// hold nothing but an "entries" key. Prefer a stored tag whenever there is one.
+func DetectFormat(data []byte) StrategyType {
+	if len(data) == 0 {
+		return ""
+	}
+	if bytes.HasPrefix(data, automergeMagic) {
+		return StrategyAutomerge
+	}
+
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return ""
+	}
+	if raw, ok := top["entries"]; ok && len(top) == 1 && isLWWEntries(raw) {
+		return StrategyLWW
+	}
+	return StrategyServerAuthoritative
+}
+
+// isLWWEntries reports whether raw is a map of LWW entries.
+func isLWWEntries(raw json.RawMessage) bool {
+	var entries map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if _, ok := entry["ts"]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatMatches reports whether data may have been written by st. It only
+// tells binary Automerge data from JSON, which is unambiguous; LWW and
+// server-authoritative JSON both match either JSON strategy.
+func FormatMatches(data []byte, st StrategyType) bool {
+	format := DetectFormat(data)
+	if format == "" {
+		return true
+	}
+	return (format == StrategyAutomerge) == (st == StrategyAutomerge)
+}
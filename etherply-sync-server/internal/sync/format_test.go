@@ -0,0 +1,50 @@
+package sync_test
+
+import (
+	"testing"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+func TestDetectFormat(t *testing.T) {
+	for _, st := range []sync.StrategyType{sync.StrategyAutomerge, sync.StrategyLWW, sync.StrategyServerAuthoritative} {
+		doc := applyAll(t, sync.NewStrategy(st), sync.SetMutation("title", "x"), sync.SetMutation("n", 1))
+		if got := sync.DetectFormat(doc); got != st {
+			t.Errorf("Expected %s document to be detected as %s, got %q", st, st, got)
+		}
+	}
+
+	// A server-auth document that happens to have an "entries" key is not LWW.
+	if got := sync.DetectFormat([]byte(`{"entries":{"a":1}}`)); got != sync.StrategyServerAuthoritative {
+		t.Errorf("Expected server-auth, got %q", got)
+	}
+	for _, data := range []string{"", "not json", "[1,2]"} {
+		if got := sync.DetectFormat([]byte(data)); got != "" {
+			t.Errorf("Expected no format for %q, got %q", data, got)
+		}
+	}
+}
+
+func TestFormatMatches(t *testing.T) {
+	am := applyAll(t, sync.NewStrategy(sync.StrategyAutomerge), sync.SetMutation("k", "v"))
+	lwwShaped := []byte(`{"entries":{"x":{"ts":1}}}`)
+
+	tests := []struct {
+		data []byte
+		st   sync.StrategyType
+		want bool
+	}{
+		{am, sync.StrategyAutomerge, true},
+		{am, sync.StrategyLWW, false},
+		{lwwShaped, sync.StrategyAutomerge, false},
+		// The JSON formats cannot be told apart; the tag decides.
+		{lwwShaped, sync.StrategyLWW, true},
+		{lwwShaped, sync.StrategyServerAuthoritative, true},
+		{nil, sync.StrategyLWW, true},
+	}
+	for _, tt := range tests {
+		if got := sync.FormatMatches(tt.data, tt.st); got != tt.want {
+			t.Errorf("FormatMatches(%.20q, %s): expected %v, got %v", tt.data, tt.st, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package sync
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	gosync "sync"
+	"time"
+)
+
+// HLC is a hybrid logical clock timestamp: wall-clock microseconds, a logical
+// counter that orders events within the same microsecond, and the ID of the
+// node that generated it as a final tiebreak. HLCs are totally ordered, so
+// two distinct writes never compare equal.
+type HLC struct {
+	Physical int64  // Unix microseconds
+	Logical  uint32 // counter within Physical
+	Node     string // generating node, for deterministic tiebreaks
+}
+
+// HLCFromTime returns the HLC of a plain wall-clock timestamp (no node).
+func HLCFromTime(t time.Time) HLC {
+	return HLC{Physical: t.UnixMicro()}
+}
+
+// IsZero reports whether h is unset.
+func (h HLC) IsZero() bool {
+	return h.Physical == 0 && h.Logical == 0 && h.Node == ""
+}
+
+// Time returns the physical component as a time.
+func (h HLC) Time() time.Time {
+	return time.UnixMicro(h.Physical)
+}
+
+// Compare returns -1, 0 or +1 depending on whether h orders before, equal to
+// or after o.
+func (h HLC) Compare(o HLC) int {
+	switch {
+	case h.Physical != o.Physical:
+		return cmpInt(h.Physical < o.Physical)
+	case h.Logical != o.Logical:
+		return cmpInt(h.Logical < o.Logical)
+	default:
+		return strings.Compare(h.Node, o.Node)
+	}
+}
+
+func cmpInt(less bool) int {
+	if less {
+		return -1
+	}
+	return 1
+}
+
+// String encodes h as "<physical>:<logical>:<node>", the wire format used by
+// clients in the operation's "hlc" field.
+func (h HLC) String() string {
+	return fmt.Sprintf("%d:%d:%s", h.Physical, h.Logical, h.Node)
+}
+
+// ParseHLC decodes the String format.
+func ParseHLC(s string) (HLC, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return HLC{}, fmt.Errorf("invalid hlc %q: expected <physical>:<logical>:<node>", s)
+	}
+	physical, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || physical < 0 {
+		return HLC{}, fmt.Errorf("invalid hlc %q: bad physical time", s)
+	}
+	logical, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return HLC{}, fmt.Errorf("invalid hlc %q: bad logical counter", s)
+	}
+	return HLC{Physical: physical, Logical: uint32(logical), Node: parts[2]}, nil
+}
+
+// Clock generates monotonically increasing HLCs for one node.
+// It is safe for concurrent use.
+type Clock struct {
+	mu   gosync.Mutex
+	node string
+	last HLC
+	now  func() time.Time
+}
+
+// NewClock creates a clock for node using the system time.
+func NewClock(node string) *Clock {
+	return NewClockWithTime(node, time.Now)
+}
+
+// NewClockWithTime creates a clock with a custom time source (for tests).
+func NewClockWithTime(node string, now func() time.Time) *Clock {
+	return &Clock{node: node, now: now}
+}
+
+// Now returns a timestamp for a local event, greater than any previously
+// returned or observed timestamp.
+func (c *Clock) Now() HLC {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wall := c.now().UnixMicro()
+	if wall > c.last.Physical {
+		c.last = HLC{Physical: wall, Node: c.node}
+	} else {
+		c.last = HLC{Physical: c.last.Physical, Logical: c.last.Logical + 1, Node: c.node}
+	}
+	return c.last
+}
+
+// Observe merges a timestamp received from another node, so that later local
+// events order after it.
+func (c *Clock) Observe(remote HLC) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if remote.Physical > c.last.Physical ||
+		(remote.Physical == c.last.Physical && remote.Logical > c.last.Logical) {
+		c.last = HLC{Physical: remote.Physical, Logical: remote.Logical, Node: c.node}
+	}
+}
@@ -0,0 +1,148 @@
+package sync_test
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+func TestHLC_ParseRoundTrip(t *testing.T) {
+	h := sync.HLC{Physical: 1700000000000000, Logical: 7, Node: "node:a"}
+	parsed, err := sync.ParseHLC(h.String())
+	if err != nil {
+		t.Fatalf("ParseHLC failed: %v", err)
+	}
+	if parsed != h {
+		t.Errorf("Expected %v, got %v", h, parsed)
+	}
+
+	for _, bad := range []string{"", "1:2", "x:0:n", "1:-1:n", "-5:0:n"} {
+		if _, err := sync.ParseHLC(bad); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
+
+func TestHLC_Compare(t *testing.T) {
+	ordered := []sync.HLC{
+		{Physical: 1},
+		{Physical: 1, Logical: 1, Node: "a"},
+		{Physical: 1, Logical: 1, Node: "b"},
+		{Physical: 1, Logical: 2, Node: "a"},
+		{Physical: 2, Node: "a"},
+	}
+	for i := range ordered {
+		for j := range ordered {
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got := ordered[i].Compare(ordered[j]); got != want {
+				t.Errorf("Compare(%v, %v) = %d, want %d", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+}
+
+func TestClock_MonotonicWithStalledWallClock(t *testing.T) {
+	wall := time.UnixMicro(1000)
+	clock := sync.NewClockWithTime("n1", func() time.Time { return wall })
+
+	prev := clock.Now()
+	for i := 0; i < 100; i++ {
+		next := clock.Now()
+		if next.Compare(prev) <= 0 {
+			t.Fatalf("Clock went backwards or stalled: %v after %v", next, prev)
+		}
+		prev = next
+	}
+
+	// Observing a timestamp from the future moves the clock past it.
+	remote := sync.HLC{Physical: 5000, Logical: 3, Node: "n2"}
+	clock.Observe(remote)
+	if next := clock.Now(); next.Compare(remote) <= 0 {
+		t.Errorf("Expected %v to order after observed %v", next, remote)
+	}
+
+	// Wall clock catching up resets the logical counter.
+	wall = time.UnixMicro(9000)
+	if next := clock.Now(); next.Physical != 9000 || next.Logical != 0 {
+		t.Errorf("Expected fresh physical time, got %v", next)
+	}
+}
+
+// randomLWWDoc builds a document from random writes and deletes. Timestamps
+// are drawn from a tiny range so that equal physical times and equal HLCs
+// from different nodes are common.
+func randomLWWDoc(t *testing.T, r *rand.Rand) []byte {
+	t.Helper()
+	s := sync.NewLWWStrategy()
+	var doc []byte
+	for i := 0; i < r.Intn(8); i++ {
+		m := sync.SetMutation(fmt.Sprintf("k%d", r.Intn(4)), r.Intn(3))
+		if r.Intn(4) == 0 {
+			m = sync.Mutation{Kind: sync.OpDelete, Path: []interface{}{m.Key()}}
+		}
+		m.HLC = sync.HLC{
+			Physical: int64(r.Intn(3)),
+			Logical:  uint32(r.Intn(2)),
+			Node:     fmt.Sprintf("n%d", r.Intn(2)),
+		}
+		var err error
+		if doc, err = s.Apply(doc, m, time.Time{}); err != nil {
+			t.Fatalf("Apply failed: %v", err)
+		}
+	}
+	return doc
+}
+
+func TestLWWStrategy_MergeProperties(t *testing.T) {
+	s := sync.NewLWWStrategy()
+	r := rand.New(rand.NewSource(42))
+
+	merge := func(a, b []byte) []byte {
+		out, err := s.Merge(a, b)
+		if err != nil {
+			t.Fatalf("Merge failed: %v", err)
+		}
+		return out
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b, c := randomLWWDoc(t, r), randomLWWDoc(t, r), randomLWWDoc(t, r)
+
+		if ab, ba := merge(a, b), merge(b, a); !bytes.Equal(ab, ba) {
+			t.Fatalf("Merge is not commutative:\n a=%s\n b=%s\n ab=%s\n ba=%s", a, b, ab, ba)
+		}
+		if left, right := merge(merge(a, b), c), merge(a, merge(b, c)); !bytes.Equal(left, right) {
+			t.Fatalf("Merge is not associative:\n a=%s\n b=%s\n c=%s", a, b, c)
+		}
+		if aa := merge(a, a); !bytes.Equal(aa, merge(a, nil)) {
+			t.Fatalf("Merge is not idempotent: %s vs %s", aa, a)
+		}
+	}
+}
+
+func TestLWWStrategy_HLCBreaksPhysicalTies(t *testing.T) {
+	s := sync.NewLWWStrategy()
+
+	first := sync.SetMutation("k", "from-a")
+	first.HLC = sync.HLC{Physical: 100, Node: "a"}
+	second := sync.SetMutation("k", "from-b")
+	second.HLC = sync.HLC{Physical: 100, Node: "b"}
+
+	// Same physical time: the node ID decides, in either application order.
+	for _, order := range [][]sync.Mutation{{first, second}, {second, first}} {
+		doc := applyAll(t, s, order...)
+		state, _ := s.GetState(doc)
+		if state["k"] != "from-b" {
+			t.Errorf("Expected node tiebreak to pick 'from-b', got %v", state["k"])
+		}
+	}
+}
@@ -1,15 +1,43 @@
 package sync
 
 import (
+	"bytes"
 	"encoding/json"
-	"fmt"
 	"time"
 )
 
 // lwwEntry represents a single key-value pair with timestamp for LWW resolution.
+// A deleted key is kept as a tombstone so that merging an older write
+// from another replica cannot resurrect it.
+//
+// The write's hybrid logical clock is stored as Timestamp (physical part),
+// Logical and Node. Entries written before HLCs have only Timestamp.
 type lwwEntry struct {
 	Value     interface{} `json:"v"`
-	Timestamp int64       `json:"ts"` // Unix microseconds
+	Timestamp int64       `json:"ts"`          // Unix microseconds
+	Logical   uint32      `json:"l,omitempty"` // HLC logical counter
+	Node      string      `json:"n,omitempty"` // HLC node ID
+	Deleted   bool        `json:"d,omitempty"` // tombstone
+}
+
+func (e lwwEntry) hlc() HLC {
+	return HLC{Physical: e.Timestamp, Logical: e.Logical, Node: e.Node}
+}
+
+// wins reports whether e replaces other. Entries are ordered by HLC; equal
+// HLCs (which only a misbehaving client can produce) fall back to the entry
+// content, so that every replica picks the same winner regardless of the
+// order in which it sees them.
+func (e lwwEntry) wins(other lwwEntry) bool {
+	if c := e.hlc().Compare(other.hlc()); c != 0 {
+		return c > 0
+	}
+	if e.Deleted != other.Deleted {
+		return e.Deleted
+	}
+	a, _ := json.Marshal(e.Value)
+	b, _ := json.Marshal(other.Value)
+	return bytes.Compare(a, b) > 0
 }
 
 // lwwDocument is the internal structure for LWW storage.
@@ -36,30 +64,60 @@ func (s *LWWStrategy) Name() string {
 
 // ProcessWrite applies a mutation, keeping the value with the highest timestamp.
 func (s *LWWStrategy) ProcessWrite(current []byte, key string, value interface{}, ts time.Time) ([]byte, error) {
+	return s.Apply(current, SetMutation(key, value), ts)
+}
+
+// Apply supports top-level set and delete. Timestamps are tracked per
+// top-level key, so nested paths and list, text or counter edits have no
+// sound LWW semantics and are rejected with ErrUnsupportedOp.
+func (s *LWWStrategy) Apply(current []byte, m Mutation, ts time.Time) ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	if m.Kind != OpSet && m.Kind != OpDelete {
+		return nil, unsupported(s.Name(), m, "only set and delete are supported")
+	}
+	if len(m.Path) != 1 {
+		return nil, unsupported(s.Name(), m, "only top-level keys are supported")
+	}
+
 	doc := s.loadOrCreate(current)
+	key, value := m.Key(), m.Value
+	deleted := m.Kind == OpDelete
+	if deleted {
+		value = nil
+	}
 
-	tsMicro := ts.UnixMicro()
-	existing, exists := doc.Entries[key]
+	stamp := m.HLC
+	if stamp.IsZero() {
+		stamp = HLCFromTime(ts)
+	}
+	entry := lwwEntry{
+		Value:     value,
+		Timestamp: stamp.Physical,
+		Logical:   stamp.Logical,
+		Node:      stamp.Node,
+		Deleted:   deleted,
+	}
 
-	// Only update if new timestamp is greater
-	if !exists || tsMicro > existing.Timestamp {
-		doc.Entries[key] = lwwEntry{
-			Value:     value,
-			Timestamp: tsMicro,
-		}
+	// Only update if the new write orders after the existing one
+	if existing, exists := doc.Entries[key]; !exists || entry.wins(existing) {
+		doc.Entries[key] = entry
 	}
 
 	return json.Marshal(doc)
 }
 
-// Merge combines documents by taking the highest timestamp for each key.
+// Merge combines documents by taking the latest write (by HLC) for each key.
+// Tombstones compete like values, so a delete beats any older write. Because
+// the order is total, Merge is commutative, associative and idempotent.
 func (s *LWWStrategy) Merge(local, remote []byte) ([]byte, error) {
 	localDoc := s.loadOrCreate(local)
 	remoteDoc := s.loadOrCreate(remote)
 
 	for key, remoteEntry := range remoteDoc.Entries {
 		localEntry, exists := localDoc.Entries[key]
-		if !exists || remoteEntry.Timestamp > localEntry.Timestamp {
+		if !exists || remoteEntry.wins(localEntry) {
 			localDoc.Entries[key] = remoteEntry
 		}
 	}
@@ -67,29 +125,32 @@ func (s *LWWStrategy) Merge(local, remote []byte) ([]byte, error) {
 	return json.Marshal(localDoc)
 }
 
-// GetState materializes the document, stripping timestamps.
+// GetState materializes the document, stripping timestamps and tombstones.
 func (s *LWWStrategy) GetState(doc []byte) (map[string]interface{}, error) {
 	d := s.loadOrCreate(doc)
 	result := make(map[string]interface{}, len(d.Entries))
 	for key, entry := range d.Entries {
+		if entry.Deleted {
+			continue
+		}
 		result[key] = entry.Value
 	}
 	return result, nil
 }
 
-// GetHeads returns a single "head" based on max timestamp (for compatibility).
+// GetHeads returns a single "head": the latest HLC in the document (for compatibility).
 func (s *LWWStrategy) GetHeads(doc []byte) ([]string, error) {
 	d := s.loadOrCreate(doc)
-	var maxTs int64
+	var latest HLC
 	for _, entry := range d.Entries {
-		if entry.Timestamp > maxTs {
-			maxTs = entry.Timestamp
+		if entry.hlc().Compare(latest) > 0 {
+			latest = entry.hlc()
 		}
 	}
-	if maxTs == 0 {
+	if latest.IsZero() {
 		return []string{}, nil
 	}
-	return []string{fmt.Sprintf("%d", maxTs)}, nil
+	return []string{latest.String()}, nil
 }
 
 // GetChanges returns full document (LWW does not support incremental sync).
@@ -0,0 +1,137 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// OpKind identifies the kind of mutation an operation performs.
+type OpKind string
+
+const (
+	// OpSet writes Value at Path, creating missing parent maps and lists.
+	OpSet OpKind = "set"
+
+	// OpDelete removes the map key or list element at Path.
+	OpDelete OpKind = "delete"
+
+	// OpInsert inserts Value into the list at Path[:len-1], before the index Path[len-1].
+	OpInsert OpKind = "insert"
+
+	// OpSplice edits the collaborative text at Path: removes DeleteCount
+	// characters at Index and inserts Value (a string) in their place.
+	OpSplice OpKind = "splice"
+
+	// OpIncrement adds Delta to the counter at Path.
+	OpIncrement OpKind = "increment"
+)
+
+// ErrUnsupportedOp is returned when a strategy cannot apply a mutation kind or path.
+var ErrUnsupportedOp = errors.New("unsupported operation")
+
+// ErrInvalidMutation is returned for malformed mutations.
+var ErrInvalidMutation = errors.New("invalid mutation")
+
+// Mutation is a typed change to a document.
+//
+// Path segments are map keys (string) or list indexes (int), e.g.
+// ["board", "cards", 3, "title"].
+type Mutation struct {
+	Kind        OpKind
+	Path        []interface{}
+	Value       interface{}
+	Index       int
+	DeleteCount int
+	Delta       int64
+
+	// HLC orders the write for strategies that resolve conflicts by time.
+	// If zero, it is derived from the timestamp passed to Apply.
+	HLC HLC
+}
+
+// SetMutation returns the top-level key write used by ProcessWrite.
+func SetMutation(key string, value interface{}) Mutation {
+	return Mutation{Kind: OpSet, Path: []interface{}{key}, Value: value}
+}
+
+// Validate checks the mutation shape and normalizes path segments decoded
+// from JSON (numbers arrive as float64) to int list indexes.
+func (m *Mutation) Validate() error {
+	switch m.Kind {
+	case OpSet, OpDelete, OpInsert, OpSplice, OpIncrement:
+	default:
+		return fmt.Errorf("%w: unknown op %q", ErrInvalidMutation, m.Kind)
+	}
+
+	if len(m.Path) == 0 {
+		return fmt.Errorf("%w: path is empty", ErrInvalidMutation)
+	}
+	for i, seg := range m.Path {
+		switch v := seg.(type) {
+		case string:
+			if v == "" {
+				return fmt.Errorf("%w: empty key at path[%d]", ErrInvalidMutation, i)
+			}
+		case int:
+			if v < 0 {
+				return fmt.Errorf("%w: negative index at path[%d]", ErrInvalidMutation, i)
+			}
+		case float64:
+			if v < 0 || v != math.Trunc(v) {
+				return fmt.Errorf("%w: invalid index %v at path[%d]", ErrInvalidMutation, v, i)
+			}
+			m.Path[i] = int(v)
+		default:
+			return fmt.Errorf("%w: path[%d] must be a string or index, got %T", ErrInvalidMutation, i, seg)
+		}
+	}
+	if _, ok := m.Path[0].(string); !ok {
+		return fmt.Errorf("%w: path must start with a key", ErrInvalidMutation)
+	}
+
+	switch m.Kind {
+	case OpInsert:
+		if _, ok := m.Path[len(m.Path)-1].(int); !ok {
+			return fmt.Errorf("%w: insert path must end with a list index", ErrInvalidMutation)
+		}
+	case OpSplice:
+		if _, ok := m.Value.(string); !ok && m.Value != nil {
+			return fmt.Errorf("%w: splice value must be a string", ErrInvalidMutation)
+		}
+		if m.Index < 0 || m.DeleteCount < 0 {
+			return fmt.Errorf("%w: splice index and delete count must be non-negative", ErrInvalidMutation)
+		}
+	}
+	return nil
+}
+
+// Key returns the top-level key the mutation touches.
+func (m Mutation) Key() string {
+	if len(m.Path) == 0 {
+		return ""
+	}
+	key, _ := m.Path[0].(string)
+	return key
+}
+
+// PathString renders the path for logs and commit messages, e.g. "board.cards.3.title".
+func (m Mutation) PathString() string {
+	parts := make([]string, len(m.Path))
+	for i, seg := range m.Path {
+		switch v := seg.(type) {
+		case int:
+			parts[i] = strconv.Itoa(v)
+		default:
+			parts[i] = fmt.Sprint(v)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// unsupported builds the error a strategy returns for a mutation it cannot apply.
+func unsupported(strategy string, m Mutation, reason string) error {
+	return fmt.Errorf("%w: %s on %q is not supported by %s (%s)", ErrUnsupportedOp, m.Kind, m.PathString(), strategy, reason)
+}
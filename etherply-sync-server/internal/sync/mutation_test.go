@@ -0,0 +1,255 @@
+package sync_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bneb/etherply/etherply-sync-server/internal/sync"
+)
+
+// applyAll applies mutations in order, failing the test on the first error.
+func applyAll(t *testing.T, s sync.SyncStrategy, muts ...sync.Mutation) []byte {
+	t.Helper()
+	var doc []byte
+	for _, m := range muts {
+		var err error
+		doc, err = s.Apply(doc, m, time.Now())
+		if err != nil {
+			t.Fatalf("%s: Apply(%s %s) failed: %v", s.Name(), m.Kind, m.PathString(), err)
+		}
+	}
+	return doc
+}
+
+func path(segs ...interface{}) []interface{} { return segs }
+
+func TestMutation_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		m    sync.Mutation
+		ok   bool
+	}{
+		{"set", sync.Mutation{Kind: sync.OpSet, Path: path("a")}, true},
+		{"json index", sync.Mutation{Kind: sync.OpSet, Path: path("a", float64(2))}, true},
+		{"unknown kind", sync.Mutation{Kind: "move", Path: path("a")}, false},
+		{"empty path", sync.Mutation{Kind: sync.OpSet}, false},
+		{"leading index", sync.Mutation{Kind: sync.OpSet, Path: path(0)}, false},
+		{"fractional index", sync.Mutation{Kind: sync.OpSet, Path: path("a", 1.5)}, false},
+		{"bad segment", sync.Mutation{Kind: sync.OpSet, Path: path("a", true)}, false},
+		{"insert needs index", sync.Mutation{Kind: sync.OpInsert, Path: path("a", "b")}, false},
+		{"splice needs string", sync.Mutation{Kind: sync.OpSplice, Path: path("a"), Value: 3}, false},
+	}
+
+	for _, tc := range tests {
+		err := tc.m.Validate()
+		if tc.ok && err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+		if !tc.ok && !errors.Is(err, sync.ErrInvalidMutation) {
+			t.Errorf("%s: expected ErrInvalidMutation, got %v", tc.name, err)
+		}
+	}
+
+	m := sync.Mutation{Kind: sync.OpSet, Path: path("a", float64(2))}
+	m.Validate()
+	if _, ok := m.Path[1].(int); !ok {
+		t.Errorf("Expected JSON index to be normalized to int, got %T", m.Path[1])
+	}
+}
+
+func TestAutomergeStrategy_NestedOps(t *testing.T) {
+	s := sync.NewAutomergeStrategy()
+
+	doc := applyAll(t, s,
+		sync.Mutation{Kind: sync.OpSet, Path: path("board", "title"), Value: "Sprint"},
+		sync.Mutation{Kind: sync.OpInsert, Path: path("board", "cards", 0), Value: "a"},
+		sync.Mutation{Kind: sync.OpInsert, Path: path("board", "cards", 1), Value: "c"},
+		sync.Mutation{Kind: sync.OpInsert, Path: path("board", "cards", 1), Value: "b"},
+		sync.Mutation{Kind: sync.OpDelete, Path: path("board", "cards", 0)},
+		sync.Mutation{Kind: sync.OpIncrement, Path: path("votes"), Delta: 2},
+		sync.Mutation{Kind: sync.OpIncrement, Path: path("votes"), Delta: 3},
+		sync.Mutation{Kind: sync.OpSplice, Path: path("notes"), Value: "hello world"},
+		sync.Mutation{Kind: sync.OpSplice, Path: path("notes"), Index: 0, DeleteCount: 5, Value: "goodbye"},
+	)
+
+	state, err := s.GetState(doc)
+	if err != nil {
+		t.Fatalf("GetState failed: %v", err)
+	}
+
+	board, _ := state["board"].(map[string]interface{})
+	if board["title"] != "Sprint" {
+		t.Errorf("Expected board.title 'Sprint', got %v", board["title"])
+	}
+	if cards := board["cards"]; !reflect.DeepEqual(cards, []interface{}{"b", "c"}) {
+		t.Errorf("Expected board.cards [b c], got %v", cards)
+	}
+	if votes, _ := state["votes"].(int64); votes != 5 {
+		t.Errorf("Expected votes 5, got %v (%T)", state["votes"], state["votes"])
+	}
+	if state["notes"] != "goodbye world" {
+		t.Errorf("Expected notes 'goodbye world', got %v", state["notes"])
+	}
+
+	history, _ := s.GetHistory(doc)
+	if msg := history[1].Message; msg != "insert board.cards.0" {
+		t.Errorf("Expected commit message 'insert board.cards.0', got %q", msg)
+	}
+}
+
+func TestAutomergeStrategy_ConcurrentCounterIncrements(t *testing.T) {
+	s := sync.NewAutomergeStrategy()
+	base := applyAll(t, s, sync.Mutation{Kind: sync.OpIncrement, Path: path("n"), Delta: 1})
+
+	a, _ := s.Apply(base, sync.Mutation{Kind: sync.OpIncrement, Path: path("n"), Delta: 10}, time.Now())
+	b, _ := s.Apply(base, sync.Mutation{Kind: sync.OpIncrement, Path: path("n"), Delta: 100}, time.Now())
+
+	merged, err := s.Merge(a, b)
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	state, _ := s.GetState(merged)
+	if n, _ := state["n"].(int64); n != 111 {
+		t.Errorf("Expected concurrent increments to add up to 111, got %v", state["n"])
+	}
+}
+
+func TestServerAuthStrategy_NestedOps(t *testing.T) {
+	s := sync.NewServerAuthStrategy()
+
+	doc := applyAll(t, s,
+		sync.Mutation{Kind: sync.OpSet, Path: path("board", "title"), Value: "Sprint"},
+		sync.Mutation{Kind: sync.OpInsert, Path: path("board", "cards", 0), Value: "a"},
+		sync.Mutation{Kind: sync.OpInsert, Path: path("board", "cards", 1), Value: "c"},
+		sync.Mutation{Kind: sync.OpInsert, Path: path("board", "cards", 1), Value: "b"},
+		sync.Mutation{Kind: sync.OpDelete, Path: path("board", "cards", 0)},
+		sync.Mutation{Kind: sync.OpSet, Path: path("board", "cards", 0), Value: "B"},
+		sync.Mutation{Kind: sync.OpIncrement, Path: path("votes"), Delta: 2},
+		sync.Mutation{Kind: sync.OpIncrement, Path: path("votes"), Delta: 3},
+		sync.Mutation{Kind: sync.OpSplice, Path: path("notes"), Value: "hello world"},
+		sync.Mutation{Kind: sync.OpSplice, Path: path("notes"), Index: 0, DeleteCount: 5, Value: "goodbye"},
+	)
+
+	state, _ := s.GetState(doc)
+	board, _ := state["board"].(map[string]interface{})
+	if board["title"] != "Sprint" {
+		t.Errorf("Expected board.title 'Sprint', got %v", board["title"])
+	}
+	if cards := board["cards"]; !reflect.DeepEqual(cards, []interface{}{"B", "c"}) {
+		t.Errorf("Expected board.cards [B c], got %v", cards)
+	}
+	if state["votes"] != float64(5) {
+		t.Errorf("Expected votes 5, got %v", state["votes"])
+	}
+	if state["notes"] != "goodbye world" {
+		t.Errorf("Expected notes 'goodbye world', got %v", state["notes"])
+	}
+
+	// Out-of-range indexes and type mismatches are rejected without touching the doc.
+	bad := []sync.Mutation{
+		{Kind: sync.OpInsert, Path: path("board", "cards", 5), Value: "x"},
+		{Kind: sync.OpIncrement, Path: path("notes"), Delta: 1},
+		{Kind: sync.OpSet, Path: path("board", "title", "sub"), Value: 1},
+	}
+	for _, m := range bad {
+		if _, err := s.Apply(doc, m, time.Now()); !errors.Is(err, sync.ErrInvalidMutation) {
+			t.Errorf("Expected ErrInvalidMutation for %s %s, got %v", m.Kind, m.PathString(), err)
+		}
+	}
+}
+
+func TestLWWStrategy_RejectsUnsupportedOps(t *testing.T) {
+	s := sync.NewLWWStrategy()
+
+	doc := applyAll(t, s, sync.Mutation{Kind: sync.OpSet, Path: path("a"), Value: "1"})
+
+	rejected := []sync.Mutation{
+		{Kind: sync.OpSet, Path: path("a", "b"), Value: "nested"},
+		{Kind: sync.OpInsert, Path: path("list", 0), Value: "x"},
+		{Kind: sync.OpSplice, Path: path("a"), Value: "x"},
+		{Kind: sync.OpIncrement, Path: path("n"), Delta: 1},
+	}
+	for _, m := range rejected {
+		_, err := s.Apply(doc, m, time.Now())
+		if !errors.Is(err, sync.ErrUnsupportedOp) {
+			t.Errorf("Expected ErrUnsupportedOp for %s %s, got %v", m.Kind, m.PathString(), err)
+		}
+	}
+
+	state, _ := s.GetState(doc)
+	if len(state) != 1 || state["a"] != "1" {
+		t.Errorf("Expected document to be unchanged, got %v", state)
+	}
+}
+
+func TestDelete_AllStrategies(t *testing.T) {
+	for _, st := range []sync.StrategyType{sync.StrategyAutomerge, sync.StrategyLWW, sync.StrategyServerAuthoritative} {
+		s := sync.NewStrategy(st)
+
+		doc := applyAll(t, s,
+			sync.SetMutation("keep", "1"),
+			sync.SetMutation("gone", "2"),
+			sync.Mutation{Kind: sync.OpDelete, Path: path("gone")},
+		)
+
+		state, err := s.GetState(doc)
+		if err != nil {
+			t.Fatalf("%s: GetState failed: %v", st, err)
+		}
+		if _, exists := state["gone"]; exists {
+			t.Errorf("%s: expected 'gone' to be deleted, got %v", st, state)
+		}
+		if state["keep"] != "1" {
+			t.Errorf("%s: expected keep='1', got %v", st, state["keep"])
+		}
+
+		// Deleting a missing key is a no-op, not an error.
+		if _, err := s.Apply(doc, sync.Mutation{Kind: sync.OpDelete, Path: path("never")}, time.Now()); err != nil {
+			t.Errorf("%s: deleting a missing key failed: %v", st, err)
+		}
+	}
+}
+
+func TestLWWStrategy_TombstonePreventsResurrection(t *testing.T) {
+	s := sync.NewLWWStrategy()
+	t0 := time.UnixMicro(1000)
+
+	// Replica A writes then deletes; replica B only saw the original write.
+	written, _ := s.Apply(nil, sync.SetMutation("k", "v"), t0)
+	deleted, err := s.Apply(written, sync.Mutation{Kind: sync.OpDelete, Path: path("k")}, t0.Add(time.Second))
+	if err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	for _, merged := range [][]byte{mustMerge(t, s, deleted, written), mustMerge(t, s, written, deleted)} {
+		state, _ := s.GetState(merged)
+		if _, exists := state["k"]; exists {
+			t.Errorf("Expected tombstone to win over older write, got %v", state)
+		}
+	}
+
+	// A write newer than the tombstone brings the key back.
+	rewritten, _ := s.Apply(deleted, sync.SetMutation("k", "again"), t0.Add(2*time.Second))
+	state, _ := s.GetState(rewritten)
+	if state["k"] != "again" {
+		t.Errorf("Expected newer write to replace tombstone, got %v", state["k"])
+	}
+
+	// A delete older than the current value is ignored.
+	stale, _ := s.Apply(rewritten, sync.Mutation{Kind: sync.OpDelete, Path: path("k")}, t0)
+	state, _ = s.GetState(stale)
+	if state["k"] != "again" {
+		t.Errorf("Expected stale delete to be ignored, got %v", state["k"])
+	}
+}
+
+func mustMerge(t *testing.T, s sync.SyncStrategy, a, b []byte) []byte {
+	t.Helper()
+	merged, err := s.Merge(a, b)
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	return merged
+}
@@ -2,6 +2,8 @@ package sync
 
 import (
 	"encoding/json"
+	"fmt"
+	"slices"
 	"time"
 )
 
@@ -22,11 +24,137 @@ func (s *ServerAuthStrategy) Name() string {
 
 // ProcessWrite applies a mutation directly (server always succeeds).
 func (s *ServerAuthStrategy) ProcessWrite(current []byte, key string, value interface{}, ts time.Time) ([]byte, error) {
+	return s.Apply(current, SetMutation(key, value), ts)
+}
+
+// Apply edits the plain JSON tree in place. Every op kind is supported:
+// counters are JSON numbers and text is a JSON string, since there are no
+// concurrent edits to merge. Missing maps are created along the path; list
+// indexes must exist (or equal the length, to append).
+func (s *ServerAuthStrategy) Apply(current []byte, m Mutation, ts time.Time) ([]byte, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+
 	doc := s.loadOrCreate(current)
-	doc[key] = value
+	if _, err := applyJSON(doc, m.Path, m); err != nil {
+		return nil, err
+	}
 	return json.Marshal(doc)
 }
 
+// applyJSON applies m at path below node and returns the updated node
+// (lists may be reallocated). A nil node is a missing container.
+func applyJSON(node interface{}, path []interface{}, m Mutation) (interface{}, error) {
+	switch seg := path[0].(type) {
+	case string:
+		obj, ok := node.(map[string]interface{})
+		if node == nil {
+			obj, ok = make(map[string]interface{}), true
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %q is not inside an object", ErrInvalidMutation, seg)
+		}
+
+		child, exists := obj[seg]
+		if len(path) == 1 {
+			if m.Kind == OpDelete {
+				delete(obj, seg)
+				return obj, nil
+			}
+			v, err := applyValue(child, m)
+			if err != nil {
+				return nil, err
+			}
+			obj[seg] = v
+			return obj, nil
+		}
+
+		if !exists && m.Kind == OpDelete {
+			return obj, nil
+		}
+		v, err := applyJSON(child, path[1:], m)
+		if err != nil {
+			return nil, err
+		}
+		obj[seg] = v
+		return obj, nil
+
+	case int:
+		list, ok := node.([]interface{})
+		if node == nil {
+			ok = true
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: index %d is not inside a list", ErrInvalidMutation, seg)
+		}
+
+		if len(path) == 1 {
+			switch {
+			case m.Kind == OpInsert && seg <= len(list):
+				return slices.Insert(list, seg, m.Value), nil
+			case m.Kind == OpSet && seg == len(list):
+				return append(list, m.Value), nil
+			case seg >= len(list):
+				return nil, fmt.Errorf("%w: index %d out of range (len %d)", ErrInvalidMutation, seg, len(list))
+			case m.Kind == OpDelete:
+				return slices.Delete(list, seg, seg+1), nil
+			}
+			v, err := applyValue(list[seg], m)
+			if err != nil {
+				return nil, err
+			}
+			list[seg] = v
+			return list, nil
+		}
+
+		if seg >= len(list) {
+			return nil, fmt.Errorf("%w: index %d out of range (len %d)", ErrInvalidMutation, seg, len(list))
+		}
+		v, err := applyJSON(list[seg], path[1:], m)
+		if err != nil {
+			return nil, err
+		}
+		list[seg] = v
+		return list, nil
+	}
+	return nil, fmt.Errorf("%w: invalid path segment %v", ErrInvalidMutation, path[0])
+}
+
+// applyValue computes the new value of a leaf for set, splice and increment.
+func applyValue(current interface{}, m Mutation) (interface{}, error) {
+	switch m.Kind {
+	case OpIncrement:
+		if current == nil {
+			return float64(m.Delta), nil
+		}
+		n, ok := current.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%w: cannot increment %T at %q", ErrInvalidMutation, current, m.PathString())
+		}
+		return n + float64(m.Delta), nil
+
+	case OpSplice:
+		if current == nil {
+			current = ""
+		}
+		str, ok := current.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: cannot splice %T at %q", ErrInvalidMutation, current, m.PathString())
+		}
+		runes := []rune(str)
+		if m.Index > len(runes) || m.Index+m.DeleteCount > len(runes) {
+			return nil, fmt.Errorf("%w: splice range out of bounds at %q", ErrInvalidMutation, m.PathString())
+		}
+		text, _ := m.Value.(string)
+		return string(runes[:m.Index]) + text + string(runes[m.Index+m.DeleteCount:]), nil
+
+	case OpInsert:
+		return nil, fmt.Errorf("%w: insert target %q is not a list index", ErrInvalidMutation, m.PathString())
+	}
+	return m.Value, nil
+}
+
 // Merge keeps local (server) state, ignoring remote.
 func (s *ServerAuthStrategy) Merge(local, remote []byte) ([]byte, error) {
 	if len(local) == 0 {
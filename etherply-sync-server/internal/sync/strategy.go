@@ -25,6 +25,13 @@ type SyncStrategy interface {
 	// Returns updated document bytes or error.
 	ProcessWrite(current []byte, key string, value interface{}, ts time.Time) ([]byte, error)
 
+	// Apply applies a typed mutation (set, delete, insert, splice, increment)
+	// at a possibly nested path. Returns the updated document bytes.
+	//
+	// Strategies that cannot represent a mutation kind or path return an
+	// error wrapping ErrUnsupportedOp and leave the document untouched.
+	Apply(current []byte, m Mutation, ts time.Time) ([]byte, error)
+
 	// Merge combines two document states (local and remote).
 	// This is the core conflict resolution mechanism.
 	//
@@ -33,7 +40,8 @@ type SyncStrategy interface {
 	//   - Commutative: Merge(a, b) == Merge(b, a)
 	//   - Associative: Merge(Merge(a, b), c) == Merge(a, Merge(b, c))
 	//
-	// Not all strategies guarantee all properties (e.g., LWW is not commutative).
+	// Not all strategies guarantee all properties (e.g., server-auth is not
+	// commutative: it always keeps the local state).
 	Merge(local, remote []byte) ([]byte, error)
 
 	// GetState materializes the document as a JSON-like map for API responses.
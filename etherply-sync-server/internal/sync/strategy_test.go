@@ -215,3 +215,79 @@ func TestNewStrategy_Factory(t *testing.T) {
 		}
 	}
 }
+
+func TestOpenDocument_AllStrategies(t *testing.T) {
+	for _, st := range []sync.StrategyType{sync.StrategyAutomerge, sync.StrategyLWW, sync.StrategyServerAuthoritative} {
+		s := sync.NewStrategy(st)
+
+		doc, err := sync.OpenDocument(s, nil)
+		if err != nil {
+			t.Fatalf("%s: OpenDocument failed: %v", st, err)
+		}
+		if err := doc.Apply(sync.SetMutation("key1", "value1"), time.Now()); err != nil {
+			t.Fatalf("%s: Apply failed: %v", st, err)
+		}
+
+		// The saved bytes must be readable by the byte-oriented strategy API.
+		state, err := s.GetState(doc.Save())
+		if err != nil {
+			t.Fatalf("%s: GetState failed: %v", st, err)
+		}
+		if state["key1"] != "value1" {
+			t.Errorf("%s: expected key1='value1', got '%v'", st, state["key1"])
+		}
+	}
+}
+
+func TestAutomergeDocument_SaveIncremental(t *testing.T) {
+	s := sync.NewAutomergeStrategy()
+
+	base, _ := s.ProcessWrite(nil, "a", "1", time.Now())
+	doc, err := s.Open(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing has changed since load.
+	if inc := doc.SaveIncremental(); len(inc) != 0 {
+		t.Errorf("expected empty increment after load, got %d bytes", len(inc))
+	}
+
+	doc.Apply(sync.SetMutation("b", "2"), time.Now())
+	inc := doc.SaveIncremental()
+	if len(inc) == 0 {
+		t.Fatal("expected non-empty increment after write")
+	}
+
+	// base + increment must load as the complete document.
+	state, err := s.GetState(append(append([]byte{}, base...), inc...))
+	if err != nil {
+		t.Fatalf("GetState on appended blob failed: %v", err)
+	}
+	if state["a"] != "1" || state["b"] != "2" {
+		t.Errorf("unexpected state after append: %v", state)
+	}
+}
+
+func TestDocument_ApplyBatch(t *testing.T) {
+	for _, st := range []sync.StrategyType{sync.StrategyAutomerge, sync.StrategyLWW, sync.StrategyServerAuthoritative} {
+		s := sync.NewStrategy(st)
+		doc, _ := sync.OpenDocument(s, nil)
+
+		batch := []sync.Mutation{sync.SetMutation("a", "1"), sync.SetMutation("b", "2")}
+		if err := doc.ApplyBatch(batch, time.Now()); err != nil {
+			t.Fatalf("%s: ApplyBatch failed: %v", st, err)
+		}
+		state, _ := s.GetState(doc.Save())
+		if state["a"] != "1" || state["b"] != "2" {
+			t.Errorf("%s: expected a=1 b=2, got %v", st, state)
+		}
+	}
+
+	// Automerge records the whole batch as one change.
+	doc, _ := sync.OpenDocument(sync.NewAutomergeStrategy(), nil)
+	doc.ApplyBatch([]sync.Mutation{sync.SetMutation("a", "1"), sync.SetMutation("b", "2")}, time.Now())
+	if history, _ := doc.History(); len(history) != 1 {
+		t.Errorf("Expected 1 change for the batch, got %d", len(history))
+	}
+}
@@ -34,13 +34,33 @@ import (
 //     sync engines are write-heavy. Persistent allows recovery from crash loops.
 //  3. Engine: Starts the sync engine with configurable strategy (CRDT, LWW, etc).
 //  4. Replication: Optionally enables multi-region replication via NATS JetStream.
-//  5. Presence: Starts the ephemeral Presence Manager (Redis-backed in prod, memory in dev).
+//  5. Presence: Starts the ephemeral presence backend (Redis-backed in prod, memory in dev).
 //  6. HTTP: Sets up routes and health checks (readiness/liveness probes).
 //  7. Graceful Shutdown: Handles SIGTERM for K8s rolling updates. A hard kill
 //     risks corrupting the LSM tree.
 //
 // Configuration:
-//   - SYNC_STRATEGY: automerge (default), lww, server-auth
+//   - SYNC_STRATEGY: automerge (default), lww, server-auth - for workspaces not created
+//     with an explicit strategy via PUT /v1/workspaces/{id}
+//   - COMPACTION_THRESHOLD: Change log entries before a new snapshot (default: 100)
+//   - COMPACTION_INTERVAL_SECONDS: Background compaction interval (default: 30)
+//   - TIMESTAMP_POLICY: reject, clamp (default), ignore - for client timestamps out of range
+//   - MAX_CLOCK_SKEW_SECONDS: How far ahead of server time a client timestamp may be (default: 60)
+//   - MAX_TIMESTAMP_AGE_SECONDS: How far behind server time a client timestamp may be (default: unlimited)
+//   - DEDUP_WINDOW: Recent op IDs remembered per client to drop replayed ops (default: 128, 0 disables)
+//   - PUBSUB_BACKEND: memory (default), nats, redis - nats and redis broadcast ops to clients on every instance
+//   - PUBSUB_NATS_URL: NATS server for the nats backend (default: first NATS_URL)
+//   - PRESENCE_BACKEND: memory (default), redis - redis shares who is online across instances
+//   - PRESENCE_REDIS_TTL_SECONDS: How long a crashed instance's users stay listed (default: 30)
+//   - PRESENCE_RATE_LIMIT: Presence updates per second and connection (default: 20)
+//   - PRESENCE_MAX_BYTES: Largest presence payload (default: 4096)
+//   - PRESENCE_IDLE_SECONDS: Silence before a user turns idle (default: 60, 0 disables)
+//   - PRESENCE_OFFLINE_SECONDS: Silence before a user is removed as offline (default: 300, 0 disables)
+//   - PRESENCE_SWEEP_INTERVAL_SECONDS: How often presence TTLs are checked (default: 5)
+//   - REDIS_URL: Redis server for the redis backends, e.g. redis://host:6379/0
+//   - PUBSUB_OVERFLOW_POLICY: disconnect, resync (default), spill - for clients that fall behind
+//   - PUBSUB_BUFFER_SIZE: Broadcasts a client may fall behind before the policy applies (default: 100)
+//   - PUBSUB_SPILL_SIZE: Extra queue length of the spill policy (default: 1000)
 //   - ETHERPLY_JWT_SECRET: Required for authentication
 //   - BADGER_PATH: Storage path (default: ./badger.db)
 //   - NATS_URL: Enable multi-region replication
@@ -82,8 +102,24 @@ func main() {
 	crdtEngine := crdt.NewEngine(stateStore,
 		crdt.WithStrategy(strategy),
 		crdt.WithLogger(logger),
+		crdt.WithCompactionThreshold(cfg.CompactionThreshold),
+		crdt.WithTimestampPolicy(crdt.TimestampPolicy{
+			Action:  crdt.TimestampAction(cfg.TimestampPolicy),
+			MaxSkew: cfg.MaxClockSkew,
+			MaxAge:  cfg.MaxTimestampAge,
+		}),
+		crdt.WithDedupWindow(cfg.DedupWindow),
+		crdt.WithDedupTTL(cfg.DedupTTL),
 	)
 
+	// Fold change logs into snapshots in the background, off the write path.
+	compactorCtx, stopCompactor := context.WithCancel(context.Background())
+	compactorDone := make(chan struct{})
+	go func() {
+		crdtEngine.RunCompactor(compactorCtx, cfg.CompactionInterval)
+		close(compactorDone)
+	}()
+
 	// Initialize Multi-Region Replication (if configured)
 	var replicator *replication.NATSReplicator
 	if len(cfg.NATSURLs) > 0 {
@@ -113,13 +149,63 @@ func main() {
 	}
 
 	// Initialize supporting services
-	presenceManager := presence.NewManager()
-	pubsubService := pubsub.NewMemoryPubSub()
+	var presenceManager presence.Backend
+	if cfg.PresenceBackend == "redis" {
+		redisPresence, err := presence.NewRedisBackend(presence.RedisConfig{
+			URL:        cfg.RedisURL,
+			InstanceID: cfg.ServerID,
+			TTL:        cfg.PresenceTTL,
+		})
+		if err != nil {
+			logger.Error("presence_init_failed", "backend", cfg.PresenceBackend, "error", err)
+			os.Exit(1)
+		}
+		defer redisPresence.Close()
+		presenceManager = redisPresence
+	} else {
+		presenceManager = presence.NewManager()
+	}
+	logger.Info("presence_initialized", "backend", cfg.PresenceBackend)
+
+	pubsubOpts := []pubsub.MemoryOption{
+		pubsub.WithOverflowPolicy(pubsub.OverflowPolicy(cfg.PubSubOverflow)),
+		pubsub.WithBufferSize(cfg.PubSubBufferSize),
+		pubsub.WithSpillSize(cfg.PubSubSpillSize),
+	}
+	var pubsubService pubsub.PubSub
+	switch cfg.PubSubBackend {
+	case "nats":
+		natsPubSub, err := pubsub.NewNATSPubSub(pubsub.NATSConfig{URL: cfg.PubSubNATSURL, Name: cfg.ServerID}, pubsubOpts...)
+		if err != nil {
+			logger.Error("pubsub_init_failed", "backend", cfg.PubSubBackend, "error", err)
+			os.Exit(1)
+		}
+		defer natsPubSub.Close()
+		pubsubService = natsPubSub
+	case "redis":
+		redisPubSub, err := pubsub.NewRedisPubSub(pubsub.RedisConfig{URL: cfg.RedisURL}, pubsubOpts...)
+		if err != nil {
+			logger.Error("pubsub_init_failed", "backend", cfg.PubSubBackend, "error", err)
+			os.Exit(1)
+		}
+		defer redisPubSub.Close()
+		pubsubService = redisPubSub
+	default:
+		pubsubService = pubsub.NewMemoryPubSub(pubsubOpts...)
+	}
+	logger.Info("pubsub_initialized", "backend", cfg.PubSubBackend)
 	dispatcher := webhook.NewDispatcher(cfg.WebhookURL)
 	meteringService := metering.NewBadgerMeteringService(stateStore)
 
 	// Initialize Handlers
-	srv := server.NewHandler(crdtEngine, presenceManager, pubsubService, dispatcher, stateStore, meteringService)
+	srv := server.NewHandler(crdtEngine, presenceManager, pubsubService, dispatcher, stateStore, meteringService,
+		server.WithPresenceRate(float64(cfg.PresenceRate), cfg.PresenceRate),
+		server.WithPresenceMaxBytes(cfg.PresenceMaxBytes),
+		server.WithPresenceTTL(cfg.PresenceIdle, cfg.PresenceOffline),
+	)
+	sweeperCtx, stopSweeper := context.WithCancel(context.Background())
+	defer stopSweeper()
+	go srv.RunPresenceSweeper(sweeperCtx, cfg.PresenceSweep)
 	healthChecker := server.NewHealthChecker(stateStore)
 
 	// Router
@@ -148,6 +234,7 @@ func main() {
 	mux.HandleFunc("/v1/presence/", srv.HandleGetPresence)
 	mux.HandleFunc("/v1/stats", srv.HandleGetStats)
 	mux.HandleFunc("/v1/history/", srv.HandleGetHistory)
+	mux.HandleFunc("/v1/workspaces/", srv.HandleWorkspace)
 
 	// Metrics Endpoint (P0 Enterprise Feature)
 	mux.Handle("/metrics", promhttp.Handler())
@@ -200,6 +287,12 @@ func main() {
 			httpServer.Close()
 		}
 
+		stopSweeper()
+
+		// Flush pending compactions before the store is closed.
+		stopCompactor()
+		<-compactorDone
+
 		logger.Info("server_shutdown_complete")
 	}
 }
@@ -98,6 +98,8 @@ export class EtherPlyClient {
     private operationQueue: QueuedOperation[] = [];
     private currentState: Record<string, unknown> = {};
     private isDestroyed = false;
+    /** Whether userId came from the config rather than generateUserId. */
+    private readonly hasConfiguredUserId: boolean;
 
     /**
      * Creates a new EtherPly client instance.
@@ -131,6 +133,7 @@ export class EtherPlyClient {
             throw new ConfigurationError('EtherPly: maxQueueSize must be a non-negative number');
         }
 
+        this.hasConfiguredUserId = Boolean(config.userId?.trim());
         this.config = {
             ...DEFAULT_CONFIG,
             workspaceId: config.workspaceId.trim(),
@@ -202,10 +205,12 @@ export class EtherPlyClient {
      */
     private buildUrl(): string {
         const base = this.config.serverUrl.replace(/\/+$/, '');
-        const params = new URLSearchParams({
-            token: this.config.token,
-            userId: this.config.userId,
-        });
+        const params = new URLSearchParams({ token: this.config.token });
+        // The server takes the user from the token and rejects a userId that
+        // differs from its subject, so a generated one is never sent.
+        if (this.hasConfiguredUserId) {
+            params.set('userId', this.config.userId);
+        }
         // Encode workspaceId to handle special characters
         const encodedWorkspace = encodeURIComponent(this.config.workspaceId);
         return `${base}/v1/sync/${encodedWorkspace}?${params.toString()}`;
@@ -18,7 +18,9 @@ export interface EtherPlyConfig {
 
     /**
      * Optional user identifier for presence tracking.
-     * If not provided, a random ID will be generated.
+     * If not provided, a random ID will be generated locally.
+     * The server identifies users by the token's `sub` claim and rejects
+     * a userId that differs from it.
      */
     userId?: string;
 
@@ -0,0 +1,132 @@
+package etherply
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrAckTimeout is returned by SendOperationWait when the server did not
+// acknowledge the operation in time. The operation may still be applied later.
+var ErrAckTimeout = errors.New("timed out waiting for ack")
+
+// ErrNotListening is returned by SendOperationWait when Listen is not
+// running, since acks are only read by the Listen loop.
+var ErrNotListening = errors.New("Listen must be running to receive acks")
+
+// Ack confirms that the server applied and persisted an operation.
+type Ack struct {
+	OpID string
+	// Heads is the document version right after the operation.
+	Heads []string
+}
+
+// OpError is returned when the server rejects an operation.
+type OpError struct {
+	OpID   string
+	Code   string // e.g. "invalid_operation", "permission_denied", "conflict"
+	Reason string
+}
+
+func (e *OpError) Error() string {
+	return fmt.Sprintf("operation %s rejected: %s: %s", e.OpID, e.Code, e.Reason)
+}
+
+// ackResult is what a pending SendOperationWait call receives.
+type ackResult struct {
+	ack *Ack
+	err error
+}
+
+// SendOperationWait sends a key-value operation like SendOperation, then waits
+// until the server acknowledges it, rejects it (*OpError), or timeout passes
+// (ErrAckTimeout). Listen must be running.
+//
+// Offline Support: an operation queued while disconnected is sent on
+// reconnect; it is acknowledged only if that happens within timeout.
+func (c *Client) SendOperationWait(key string, value interface{}, timeout time.Duration) (*Ack, error) {
+	c.mu.RLock()
+	listening := c.listening
+	c.mu.RUnlock()
+	if !listening {
+		return nil, ErrNotListening
+	}
+
+	opID := newOpID()
+	done := make(chan ackResult, 1)
+	c.pendingMu.Lock()
+	c.pending[opID] = done
+	c.pendingMu.Unlock()
+	defer func() {
+		c.pendingMu.Lock()
+		delete(c.pending, opID)
+		c.pendingMu.Unlock()
+	}()
+
+	err := c.send(map[string]interface{}{
+		"key":   key,
+		"value": value,
+	}, opID)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return res.ack, res.err
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: operation %s", ErrAckTimeout, opID)
+	}
+}
+
+// resolvePending hands "ack" and "error" frames to the SendOperationWait call
+// waiting for their op_id.
+func (c *Client) resolvePending(msg map[string]interface{}) {
+	opID, _ := msg["op_id"].(string)
+	if opID == "" {
+		return
+	}
+
+	var res ackResult
+	switch msg["type"] {
+	case "ack":
+		res.ack = &Ack{OpID: opID, Heads: stringSlice(msg["heads"])}
+	case "error":
+		code, _ := msg["code"].(string)
+		reason, _ := msg["reason"].(string)
+		res.err = &OpError{OpID: opID, Code: code, Reason: reason}
+	default:
+		return
+	}
+
+	c.pendingMu.Lock()
+	done, ok := c.pending[opID]
+	delete(c.pending, opID)
+	c.pendingMu.Unlock()
+	if ok {
+		done <- res
+	}
+}
+
+// newOpID returns a random client-generated operation ID.
+func newOpID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// stringSlice converts a decoded JSON array to strings, skipping other values.
+func stringSlice(v interface{}) []string {
+	raw, _ := v.([]interface{})
+	out := make([]string, 0, len(raw))
+	for _, item := range raw {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -3,6 +3,8 @@ package etherply
 import (
 	"fmt"
 	"log"
+	neturl "net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,7 +16,7 @@ import (
 // and listen for updates.
 //
 // Thread Safety:
-// - SendOperation is thread-safe.
+// - SendOperation and SendOperationWait are thread-safe.
 // - Listen runs in its own goroutine.
 // - Close is thread-safe.
 type Client struct {
@@ -23,20 +25,59 @@ type Client struct {
 	Conn    *websocket.Conn
 	mu      sync.RWMutex // Guards writes to Conn and Queue
 
+	// Echo asks the server to also send this client's own ops back, e.g. to
+	// learn their position in the broadcast order. Set it before Connect.
+	Echo bool
+
+	// HeartbeatInterval is how often a WebSocket ping is sent, which keeps
+	// the session present (but idle) while the client sends nothing.
+	// Zero disables heartbeats. Set it before Connect.
+	HeartbeatInterval time.Duration
+	heartbeating      bool // True while the heartbeat goroutine runs
+
 	// Offline Support
 	queue       []map[string]interface{}
 	workspaceID string
 	isClosed    bool // True if user explicitly called Close()
+
+	// clock stamps outgoing operations with hybrid logical clock timestamps.
+	clock *hlcClock
+
+	// clientID identifies this client across reconnects. The server scopes
+	// op_id deduplication by it, so ops replayed from the queue apply once.
+	clientID string
+
+	// heads is the document version of the last full-state or delta message.
+	// Reconnects send it so the server only replies with what changed.
+	heads []string
+
+	// presence is the last state passed to SetPresence, re-sent after reconnects.
+	presence interface{}
+
+	// listening is set once Listen runs; only then can acks be received.
+	listening bool
+	// pending holds SendOperationWait calls waiting for their ack, by op_id.
+	pendingMu sync.Mutex
+	pending   map[string]chan ackResult
 }
 
+// DefaultHeartbeatInterval keeps sessions well within the server's offline
+// TTL (5 minutes by default).
+const DefaultHeartbeatInterval = 30 * time.Second
+
 // NewClient creates a new Client instance.
 // baseURL should be the root URL of the sync server (e.g., "ws://localhost:8080").
 // token must be a valid JWT signed with the server's secret.
 func NewClient(baseURL, token string) *Client {
 	return &Client{
-		BaseURL: baseURL,
-		Token:   token,
-		queue:   make([]map[string]interface{}, 0),
+		BaseURL:  baseURL,
+		Token:    token,
+		queue:    make([]map[string]interface{}, 0),
+		clock:    newHLCClock(),
+		clientID: newOpID(),
+		pending:  make(map[string]chan ackResult),
+
+		HeartbeatInterval: DefaultHeartbeatInterval,
 	}
 }
 
@@ -47,22 +88,61 @@ func (c *Client) Connect(workspaceID string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.workspaceID != workspaceID {
+		c.heads = nil // Heads are only meaningful within one workspace
+	}
 	c.workspaceID = workspaceID
 	c.isClosed = false
 
 	if err := c.dialLocked(); err != nil {
 		return err
 	}
+	if c.HeartbeatInterval > 0 && !c.heartbeating {
+		c.heartbeating = true
+		go c.heartbeat(c.HeartbeatInterval)
+	}
 
 	log.Println("[SDK] Connected to EtherPly Sync Server")
 	return nil
 }
 
+// heartbeat pings the server every interval until the client is closed.
+// Pings carry no message, so the server counts them as liveness but not as
+// activity. Across reconnects, the current connection is pinged.
+func (c *Client) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.mu.Lock()
+		if c.isClosed {
+			c.heartbeating = false
+			c.mu.Unlock()
+			return
+		}
+		conn := c.Conn
+		c.mu.Unlock()
+
+		if conn == nil {
+			continue // Reconnecting
+		}
+		if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)); err != nil {
+			log.Printf("[SDK] Heartbeat failed: %v", err)
+		}
+	}
+}
+
 // internal helper, assumes lock is held
 func (c *Client) dialLocked() error {
 	// Construct URL
 	// Query params are used for auth during WS handshake.
-	url := c.BaseURL + "/v1/sync/" + c.workspaceID + "?token=" + c.Token
+	url := c.BaseURL + "/v1/sync/" + c.workspaceID + "?token=" + c.Token + "&client_id=" + c.clientID
+	if c.Echo {
+		url += "&echo=1"
+	}
+	if len(c.heads) > 0 {
+		url += "&heads=" + neturl.QueryEscape(strings.Join(c.heads, ","))
+	}
 
 	// DefaultDialer is used; in production, you might want to customize the HandshakeTimeout.
 	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
@@ -74,22 +154,46 @@ func (c *Client) dialLocked() error {
 }
 
 // SendOperation transmits a key-value operation to the sync server.
-// It automatically attaches a microsecond-precision timestamp for LWW (Last-Write-Wins)
-// conflict resolution.
+// It automatically attaches a microsecond-precision timestamp and a hybrid
+// logical clock for LWW (Last-Write-Wins) conflict resolution.
 //
 // Offline Support: If the client is disconnected, the operation is queued and sent
 // automatically upon reconnection.
 func (c *Client) SendOperation(key string, value interface{}) error {
+	return c.sendOp(map[string]interface{}{
+		"key":   key,
+		"value": value,
+	})
+}
+
+// DeleteKey removes key from the workspace document.
+// The delete is timestamped like SendOperation, so under LWW it only wins over
+// older writes, and it is queued while offline in the same way.
+func (c *Client) DeleteKey(key string) error {
+	return c.sendOp(map[string]interface{}{
+		"op":  "delete",
+		"key": key,
+	})
+}
+
+// sendOp stamps payload and sends it as an "op" message, queueing it while offline.
+// Every op carries an op_id, so a resend after reconnect is not applied twice.
+func (c *Client) sendOp(payload map[string]interface{}) error {
+	return c.send(payload, newOpID())
+}
+
+// send is sendOp with the given op_id.
+func (c *Client) send(payload map[string]interface{}, opID string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	stamp := c.clock.Now()
+	payload["timestamp"] = stamp.physical
+	payload["hlc"] = stamp.String()
 	msg := map[string]interface{}{
-		"type": "op",
-		"payload": map[string]interface{}{
-			"key":       key,
-			"value":     value,
-			"timestamp": time.Now().UnixMicro(),
-		},
+		"type":    "op",
+		"op_id":   opID,
+		"payload": payload,
 	}
 
 	// If disconnected or closed (but queueing allowed?), just queue if generic disconnect.
@@ -129,6 +233,10 @@ func (c *Client) SendOperation(key string, value interface{}) error {
 // Blocking: This function itself returns immediately, but the spawned goroutine runs until
 // the connection is closed.
 func (c *Client) Listen(handler func(msg map[string]interface{})) {
+	c.mu.Lock()
+	c.listening = true
+	c.mu.Unlock()
+
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
@@ -178,11 +286,47 @@ func (c *Client) Listen(handler func(msg map[string]interface{})) {
 				conn.Close() // Ensure closed
 				continue
 			}
+			c.trackHeads(msg)
+			c.observeHLC(msg)
+			c.resolvePending(msg)
 			handler(msg)
 		}
 	}()
 }
 
+// Heads returns the document version the client last synchronized to.
+func (c *Client) Heads() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string(nil), c.heads...)
+}
+
+// observeHLC advances the clock past the timestamps of broadcast ops, so that
+// later local writes order after changes this client has seen.
+func (c *Client) observeHLC(msg map[string]interface{}) {
+	payload, _ := msg["payload"].(map[string]interface{})
+	if s, ok := payload["hlc"].(string); ok {
+		if remote, ok := parseHLC(s); ok {
+			c.clock.Observe(remote)
+		}
+	}
+}
+
+// trackHeads records the heads of messages that bring the client fully up to
+// date ("init", "delta", "state"). Broadcast ops carry no heads.
+func (c *Client) trackHeads(msg map[string]interface{}) {
+	switch msg["type"] {
+	case "init", "delta", "state":
+	default:
+		return
+	}
+	heads := stringSlice(msg["heads"])
+
+	c.mu.Lock()
+	c.heads = heads
+	c.mu.Unlock()
+}
+
 // reconnect attempts to re-establish connection with exponential backoff.
 // It also flushes the operation queue upon success.
 func (c *Client) reconnect() error {
@@ -224,6 +368,13 @@ func (c *Client) reconnect() error {
 			}
 			c.queue = newQueue
 
+			// Presence is per connection; restore it on the new one.
+			if c.presence != nil {
+				if wErr := c.Conn.WriteJSON(presenceMessage(c.presence)); wErr != nil {
+					log.Printf("[SDK] Failed to restore presence: %v", wErr)
+				}
+			}
+
 			c.mu.Unlock()
 
 			if failedCount > 0 {
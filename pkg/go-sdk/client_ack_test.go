@@ -0,0 +1,70 @@
+package etherply_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	etherply "github.com/bneb/etherply/pkg/go-sdk"
+	"github.com/gorilla/websocket"
+)
+
+// TestClient_SendOperationWait verifies that acks and error frames are matched
+// to the waiting call by op_id, and that a missing ack times out.
+func TestClient_SendOperationWait(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		for {
+			var msg map[string]interface{}
+			if err := c.ReadJSON(&msg); err != nil {
+				return
+			}
+			payload, _ := msg["payload"].(map[string]interface{})
+			switch payload["key"] {
+			case "ok":
+				c.WriteJSON(map[string]interface{}{"type": "ack", "op_id": msg["op_id"], "heads": []string{"h1"}})
+			case "bad":
+				c.WriteJSON(map[string]interface{}{"type": "error", "op_id": msg["op_id"], "code": "invalid_operation", "reason": "nope"})
+			}
+			// Anything else is never answered.
+		}
+	}))
+	defer server.Close()
+
+	client := etherply.NewClient("ws"+strings.TrimPrefix(server.URL, "http"), "token")
+	if err := client.Connect("ws-ack"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	if _, err := client.SendOperationWait("ok", 1, time.Second); !errors.Is(err, etherply.ErrNotListening) {
+		t.Fatalf("Expected ErrNotListening before Listen, got %v", err)
+	}
+	client.Listen(func(msg map[string]interface{}) {})
+
+	ack, err := client.SendOperationWait("ok", 1, 2*time.Second)
+	if err != nil {
+		t.Fatalf("Expected ack, got %v", err)
+	}
+	if ack.OpID == "" || len(ack.Heads) != 1 || ack.Heads[0] != "h1" {
+		t.Errorf("Expected ack with heads [h1], got %+v", ack)
+	}
+
+	_, err = client.SendOperationWait("bad", 1, 2*time.Second)
+	var opErr *etherply.OpError
+	if !errors.As(err, &opErr) || opErr.Code != "invalid_operation" || opErr.Reason != "nope" {
+		t.Errorf("Expected OpError invalid_operation, got %v", err)
+	}
+
+	if _, err := client.SendOperationWait("silent", 1, 50*time.Millisecond); !errors.Is(err, etherply.ErrAckTimeout) {
+		t.Errorf("Expected ErrAckTimeout, got %v", err)
+	}
+}
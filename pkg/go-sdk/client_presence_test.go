@@ -0,0 +1,89 @@
+package etherply_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	etherply "github.com/bneb/etherply/pkg/go-sdk"
+	"github.com/gorilla/websocket"
+)
+
+func TestClient_SetPresence(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		var msg map[string]interface{}
+		if err := c.ReadJSON(&msg); err == nil {
+			received <- msg
+		}
+	}))
+	defer server.Close()
+
+	client := etherply.NewClient("ws"+strings.TrimPrefix(server.URL, "http"), "token")
+	if err := client.Connect("ws-presence"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.SetPresence(map[string]interface{}{"cursor": 7}); err != nil {
+		t.Fatalf("SetPresence failed: %v", err)
+	}
+	select {
+	case msg := <-received:
+		payload, _ := msg["payload"].(map[string]interface{})
+		if msg["type"] != "presence" || payload["cursor"] != float64(7) {
+			t.Errorf("Expected presence message with cursor 7, got %v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for presence message")
+	}
+}
+
+func TestClient_Heartbeat(t *testing.T) {
+	pings := make(chan struct{}, 8)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.SetPingHandler(func(string) error {
+			select {
+			case pings <- struct{}{}:
+			default:
+			}
+			return nil
+		})
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := etherply.NewClient("ws"+strings.TrimPrefix(server.URL, "http"), "token")
+	client.HeartbeatInterval = 20 * time.Millisecond
+	if err := client.Connect("ws-heartbeat"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	// A client that sends nothing still pings the server.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-pings:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timeout waiting for heartbeat %d", i+1)
+		}
+	}
+}
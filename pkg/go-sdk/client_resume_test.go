@@ -0,0 +1,78 @@
+package etherply_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	etherply "github.com/bneb/etherply/pkg/go-sdk"
+	"github.com/gorilla/websocket"
+)
+
+// TestClient_ResumeWithHeads verifies that the client remembers the heads of
+// the last init/delta message and sends them when it reconnects.
+func TestClient_ResumeWithHeads(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	var mu sync.Mutex
+	var dialHeads []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		dialHeads = append(dialHeads, r.URL.Query().Get("heads"))
+		first := len(dialHeads) == 1
+		mu.Unlock()
+
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		if first {
+			c.WriteJSON(map[string]interface{}{"type": "init", "data": map[string]interface{}{}, "heads": []string{"h1", "h2"}})
+			// Broadcasts must not move the resume point.
+			c.WriteJSON(map[string]interface{}{"type": "op", "payload": map[string]interface{}{"key": "k"}})
+			time.Sleep(50 * time.Millisecond)
+			return // Drop the connection to force a reconnect
+		}
+		c.WriteJSON(map[string]interface{}{"type": "delta", "data": map[string]interface{}{}, "deleted": []string{}, "heads": []string{"h3"}})
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := etherply.NewClient("ws"+strings.TrimPrefix(server.URL, "http"), "token")
+	if err := client.Connect("ws-resume"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	deltas := make(chan struct{}, 1)
+	client.Listen(func(msg map[string]interface{}) {
+		if msg["type"] == "delta" {
+			deltas <- struct{}{}
+		}
+	})
+
+	select {
+	case <-deltas:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for reconnect")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dialHeads) < 2 || dialHeads[0] != "" || dialHeads[1] != "h1,h2" {
+		t.Errorf("Expected reconnect with heads 'h1,h2', got %q", dialHeads)
+	}
+	if heads := client.Heads(); len(heads) != 1 || heads[0] != "h3" {
+		t.Errorf("Expected heads [h3] after delta, got %v", heads)
+	}
+}
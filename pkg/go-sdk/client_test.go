@@ -1,9 +1,14 @@
 package etherply_test
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	etherply "github.com/bneb/etherply/pkg/go-sdk"
+	"github.com/gorilla/websocket"
 )
 
 func TestNewClient_Fields(t *testing.T) {
@@ -42,6 +47,46 @@ func TestClient_SendOperation_NotConnected(t *testing.T) {
 	}
 }
 
+func TestClient_DeleteKey(t *testing.T) {
+	received := make(chan map[string]interface{}, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		var msg map[string]interface{}
+		if err := c.ReadJSON(&msg); err == nil {
+			received <- msg
+		}
+	}))
+	defer server.Close()
+
+	client := etherply.NewClient("ws"+strings.TrimPrefix(server.URL, "http"), "token")
+	if err := client.Connect("ws-delete"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.DeleteKey("obsolete"); err != nil {
+		t.Fatalf("DeleteKey failed: %v", err)
+	}
+
+	select {
+	case msg := <-received:
+		payload, _ := msg["payload"].(map[string]interface{})
+		if msg["type"] != "op" || payload["op"] != "delete" || payload["key"] != "obsolete" {
+			t.Errorf("Expected delete op for 'obsolete', got %v", msg)
+		}
+		if _, ok := payload["timestamp"].(float64); !ok {
+			t.Errorf("Expected delete op to carry a timestamp, got %v", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for delete op")
+	}
+}
+
 // contains checks if s contains substr (simple helper for tests)
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > 0 && containsHelper(s, substr))
@@ -55,3 +100,28 @@ func containsHelper(s, substr string) bool {
 	}
 	return false
 }
+
+func TestClient_EchoOption(t *testing.T) {
+	queries := make(chan string, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.RawQuery
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		c.Close()
+	}))
+	defer server.Close()
+
+	client := etherply.NewClient("ws"+strings.TrimPrefix(server.URL, "http"), "token")
+	client.Echo = true
+	if err := client.Connect("ws-echo"); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+
+	if q := <-queries; !strings.Contains(q, "echo=1") {
+		t.Errorf("Expected echo=1 in the connect query, got %q", q)
+	}
+}
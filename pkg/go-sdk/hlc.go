@@ -0,0 +1,77 @@
+package etherply
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hlc is a hybrid logical clock timestamp: wall-clock microseconds, a logical
+// counter for events within the same microsecond, and a node ID tiebreak.
+// It is sent as "<physical>:<logical>:<node>" in the op's "hlc" field and
+// lets the server order writes even when client clocks disagree.
+type hlc struct {
+	physical int64
+	logical  uint32
+	node     string
+}
+
+func (h hlc) String() string {
+	return fmt.Sprintf("%d:%d:%s", h.physical, h.logical, h.node)
+}
+
+func parseHLC(s string) (hlc, bool) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return hlc{}, false
+	}
+	physical, err1 := strconv.ParseInt(parts[0], 10, 64)
+	logical, err2 := strconv.ParseUint(parts[1], 10, 32)
+	if err1 != nil || err2 != nil {
+		return hlc{}, false
+	}
+	return hlc{physical: physical, logical: uint32(logical), node: parts[2]}, true
+}
+
+// hlcClock generates monotonically increasing timestamps for one client.
+type hlcClock struct {
+	mu   sync.Mutex
+	node string
+	last hlc
+	now  func() time.Time
+}
+
+func newHLCClock() *hlcClock {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return &hlcClock{node: "sdk-" + hex.EncodeToString(b), now: time.Now}
+}
+
+// Now returns a timestamp greater than any previously returned or observed one.
+func (c *hlcClock) Now() hlc {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wall := c.now().UnixMicro()
+	if wall > c.last.physical {
+		c.last = hlc{physical: wall, node: c.node}
+	} else {
+		c.last = hlc{physical: c.last.physical, logical: c.last.logical + 1, node: c.node}
+	}
+	return c.last
+}
+
+// Observe merges a timestamp seen from another client or the server.
+func (c *hlcClock) Observe(remote hlc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if remote.physical > c.last.physical ||
+		(remote.physical == c.last.physical && remote.logical > c.last.logical) {
+		c.last = hlc{physical: remote.physical, logical: remote.logical, node: c.node}
+	}
+}
@@ -0,0 +1,31 @@
+package etherply
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHLCClock_Monotonic(t *testing.T) {
+	clock := newHLCClock()
+	wall := time.UnixMicro(1000)
+	clock.now = func() time.Time { return wall }
+
+	prev := clock.Now()
+	for i := 0; i < 10; i++ {
+		next := clock.Now()
+		if next.physical < prev.physical || (next.physical == prev.physical && next.logical <= prev.logical) {
+			t.Fatalf("Clock did not advance: %v after %v", next, prev)
+		}
+		prev = next
+	}
+
+	clock.Observe(hlc{physical: 5000, logical: 2, node: "server"})
+	if next := clock.Now(); next.physical != 5000 || next.logical != 3 || next.node != clock.node {
+		t.Errorf("Expected 5000:3:%s after observing the server, got %v", clock.node, next)
+	}
+
+	parsed, ok := parseHLC(prev.String())
+	if !ok || parsed != prev {
+		t.Errorf("Expected %v to round-trip, got %v (ok=%v)", prev, parsed, ok)
+	}
+}
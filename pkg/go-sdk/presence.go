@@ -0,0 +1,35 @@
+package etherply
+
+import "fmt"
+
+// SetPresence publishes state (a cursor position, a selection, ...) as this
+// client's presence in the workspace. Other clients receive it as a
+// {"type":"presence","user_id":...,"payload":state} message; it is never
+// written to the document. state must marshal to JSON.
+//
+// Presence is ephemeral: it is not queued while offline. The latest state is
+// sent again after a reconnect instead. The server rate-limits updates, so
+// high-frequency sources such as mouse moves should be throttled.
+func (c *Client) SetPresence(state interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.isClosed {
+		return fmt.Errorf("client is closed")
+	}
+	c.presence = state
+	if c.Conn == nil {
+		return nil
+	}
+	if err := c.Conn.WriteJSON(presenceMessage(state)); err != nil {
+		return fmt.Errorf("failed to send presence: %w", err)
+	}
+	return nil
+}
+
+func presenceMessage(state interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":    "presence",
+		"payload": state,
+	}
+}